@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+var (
+	// @Name:  complex-mode
+	// @Desc:  Evaluates functions with complex numbers, e.g. sqrt(-4) is 2i instead of an error. The DSL has no syntax for complex literals, write them as quoted strings like "3+4i".
+	// @Range: -
+	// @Unit:  -
+	complexMode = false
+)
+
+// polarForm is a complex number given by its magnitude and angle
+type polarForm struct {
+	r, theta float64
+}
+
+func (p polarForm) String() string {
+	return fmt.Sprintf("%g∠%g", p.r, p.theta)
+}
+
+// @Name: re
+// @Desc: Returns the real part of a complex number, complex literals are quoted because the DSL can't parse a bare 3+4i, e.g. re("3+4i") is 3
+// @Param:      z       - -   0   Complex number, e.g. "3+4i"
+// @Returns:    result  - -   0   Real part of the number
+func re(z any) (result any, err error) {
+	c, err := toComplex(z)
+	return setRealResult(real(c), err)
+}
+
+// @Name: im
+// @Desc: Returns the imaginary part of a complex number given as result or quoted literal, e.g. im("3+4i") is 4
+// @Param:      z       - -   0   Complex number, e.g. "3+4i"
+// @Returns:    result  - -   0   Imaginary part of the number
+func im(z any) (result any, err error) {
+	c, err := toComplex(z)
+	return setRealResult(imag(c), err)
+}
+
+// @Name: conj
+// @Desc: Returns the complex conjugate of a number, the sign of the imaginary part is flipped, e.g. conj("3+4i") is 3-4i
+// @Param:      z       - -   0   Complex number, e.g. "3+4i"
+// @Returns:    result  - -   0   Conjugate of the number
+func conj(z any) (result any, err error) {
+	c, err := toComplex(z)
+	return setComplexResult(cmplx.Conj(c), err)
+}
+
+// @Name: arg
+// @Desc: Returns the argument (phase) of a complex number given as result or quoted literal, e.g. arg("1i") is π/2
+// @Param:      z       - 	-   0   Complex number, e.g. "3+4i"
+// @Returns:    result  rad	-   0   Angle to the positive real axis, between -π and π
+func arg(z any) (result any, err error) {
+	c, err := toComplex(z)
+	return setRealResult(cmplx.Phase(c), err)
+}
+
+// @Name: polar
+// @Desc: Converts a complex number to polar form, e.g. polar("3+4i") is 5∠0.927
+// @Param:      z       - -   0   Complex number, e.g. "3+4i"
+// @Returns:    result  - -   0   Magnitude and angle (in radians) of the number, e.g. 5∠0.927
+func polar(z any) (result any, err error) {
+	c, err := toComplex(z)
+	if err != nil {
+		res = 0.0
+		return res, err
+	}
+	r, theta := cmplx.Polar(c)
+	res = polarForm{r, theta}
+	return res, nil
+}
+
+// @Name: rect
+// @Desc: Creates a complex number from polar form
+// @Param:      r       - 	0..   1   Magnitude
+// @Param:      theta   rad	-   0   Angle to the positive real axis
+// @Returns:    result  - 	-   1   The complex number
+func rect(r, theta any) (result any, err error) {
+	m, err := toReal(r)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	a, err := toReal(theta)
+	return setComplexResult(cmplx.Rect(m, a), err)
+}
+
+// Helper function to check whether arguments have to be evaluated with complex numbers,
+// that is in complex mode or when one of them has an imaginary part
+func useComplex(args ...any) bool {
+	if complexMode {
+		return true
+	}
+	for _, a := range args {
+		if c, err := toComplex(a); err == nil && imag(c) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to convert a value to a complex number, strings are parsed as complex literals like "3+4i" or "-2i"
+func toComplex(v any) (complex128, error) {
+	switch n := v.(type) {
+	case complex128:
+		return n, nil
+	case polarForm:
+		return cmplx.Rect(n.r, n.theta), nil
+	case string:
+		c, err := strconv.ParseComplex(strings.ReplaceAll(n, " ", ""), 128)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", n)
+		}
+		return c, nil
+	}
+	f, err := toReal(v)
+	return complex(f, 0), err
+}
+
+// Helper function to convert a value to a real number, complex numbers must not have an imaginary part
+func toReal(v any) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case *bigNumber:
+		return n.float64(), nil
+	case complex128, polarForm, string:
+		c, err := toComplex(n)
+		if err != nil {
+			return 0, err
+		}
+		if imag(c) != 0 {
+			return 0, fmt.Errorf("%v is not a real number", complexValue(c))
+		}
+		return real(c), nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// Helper function to return a complex result, results without imaginary part are returned as real numbers
+func complexValue(c complex128) any {
+	if imag(c) == 0 {
+		return real(c)
+	}
+	return c
+}
+
+// Helper function to evaluate a function of one number, the complex variant is used if useComplex says so
+func unary(x any, realFn func(float64) (float64, error), complexFn func(complex128) (complex128, error)) (any, error) {
+	if useComplex(x) {
+		c, err := toComplex(x)
+		if err != nil {
+			return setComplexResult(0, err)
+		}
+		return setComplexResult(complexFn(c))
+	}
+	f, err := toReal(x)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	return setRealResult(realFn(f))
+}
+
+// Helper function to evaluate a function of two numbers, the complex variant is used if useComplex says so
+func binary(x, y any, realFn func(a, b float64) (float64, error), complexFn func(a, b complex128) (complex128, error)) (any, error) {
+	if useComplex(x, y) {
+		a, err := toComplex(x)
+		if err != nil {
+			return setComplexResult(0, err)
+		}
+		b, err := toComplex(y)
+		if err != nil {
+			return setComplexResult(0, err)
+		}
+		return setComplexResult(complexFn(a, b))
+	}
+	a, err := toReal(x)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	b, err := toReal(y)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	return setRealResult(realFn(a, b))
+}
+
+// Helper function to store a real result as last result, errors reset it to 0
+func setRealResult(f float64, err error) (any, error) {
+	if err != nil {
+		res = 0.0
+		return res, err
+	}
+	res = f
+	return res, nil
+}
+
+// Helper function to store a complex result as last result, errors and results that are not finite reset it to 0
+func setComplexResult(c complex128, err error) (any, error) {
+	if err == nil && (cmplx.IsNaN(c) || cmplx.IsInf(c)) {
+		err = fmt.Errorf("result is not a finite number")
+	}
+	if err != nil {
+		res = 0.0
+		return res, err
+	}
+	res = complexValue(c)
+	return res, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// tolerance is the maximum difference between a float64 result and the expected value
+const tolerance = 1e-12
+
+// calcCase calls a function and compares its result to want, or expects an error if want is nil
+type calcCase struct {
+	name string
+	run  func() (any, error)
+	want any
+}
+
+// Helper function to run cases, results are compared as complex numbers, so real results match real and complex wants
+func runCalcCases(t *testing.T, cases []calcCase) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.run()
+			if c.want == nil {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				if got != 0.0 || res != 0.0 {
+					t.Errorf("got %v and last %v after an error, want 0", got, res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			g, err := toComplex(got)
+			if err != nil {
+				t.Fatalf("result %v (%T) is not a number: %v", got, got, err)
+			}
+			w := must(toComplex(c.want))
+			if cmplx.Abs(g-w) > tolerance*max(1, cmplx.Abs(w)) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+			if res != got {
+				t.Errorf("last is %v, want the result %v", res, got)
+			}
+		})
+	}
+}
+
+// Helper function to enable or disable complex-mode for the rest of a test
+func setComplexMode(t *testing.T, on bool) {
+	prev := complexMode
+	complexMode = on
+	t.Cleanup(func() { complexMode = prev })
+}
+
+// Helper function to get the value of a call that must not fail
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestComplexMode(t *testing.T) {
+	setComplexMode(t, true)
+	runCalcCases(t, []calcCase{
+		{"sqrt of negative", func() (any, error) { return sqrt(-4.0) }, 2i},
+		{"sqrt of negative fraction", func() (any, error) { return sqrt(-0.25) }, 0.5i},
+		{"sqrt of positive stays real", func() (any, error) { return sqrt(9.0) }, 3.0},
+		{"log of negative", func() (any, error) { return log(-1.0) }, complex(0, math.Pi)},
+		{"log of zero", func() (any, error) { return log(0.0) }, nil},
+		{"pow with fractional exponent", func() (any, error) { return pow(-8.0, 1.0/3) }, cmplx.Pow(-8, 1.0/3)},
+		{"i squared is real", func() (any, error) { return mul("1i", "1i") }, -1.0},
+		{"division by zero", func() (any, error) { return div("1+1i", 0.0) }, nil},
+		{"abs is the magnitude", func() (any, error) { return abs("3+4i") }, 5.0},
+		{"exp of iπ", func() (any, error) { return exp(complex(0, math.Pi)) }, -1.0},
+	})
+}
+
+func TestComplexWithoutMode(t *testing.T) {
+	setComplexMode(t, false)
+	runCalcCases(t, []calcCase{
+		{"sqrt of negative", func() (any, error) { return sqrt(-4.0) }, nil},
+		{"log of negative", func() (any, error) { return log(-1.0) }, nil},
+		{"pow of negative with fractional exponent", func() (any, error) { return pow(-8.0, 0.5) }, nil},
+		{"pow of negative with integer exponent", func() (any, error) { return pow(-2.0, 3.0) }, -8.0},
+		{"complex argument", func() (any, error) { return add("1+2i", 3.0) }, 4 + 2i},
+		{"complex argument with spaces", func() (any, error) { return sub("1 + 2i", "2i") }, 1.0},
+		{"complex result of previous call", func() (any, error) { return mul(2i, 2.0) }, 4i},
+		{"not a number", func() (any, error) { return add("3+4j", 1.0) }, nil},
+	})
+}
+
+func TestComplexFunctions(t *testing.T) {
+	setComplexMode(t, false)
+	runCalcCases(t, []calcCase{
+		{"re", func() (any, error) { return re("3+4i") }, 3.0},
+		{"re of real", func() (any, error) { return re(2.5) }, 2.5},
+		{"im", func() (any, error) { return im("3+4i") }, 4.0},
+		{"im of result", func() (any, error) { return im(-2i) }, -2.0},
+		{"conj", func() (any, error) { return conj("3+4i") }, 3 - 4i},
+		{"arg", func() (any, error) { return arg("1i") }, math.Pi / 2},
+		{"arg of negative real", func() (any, error) { return arg(-1.0) }, math.Pi},
+		{"polar and back", func() (any, error) { return add(must(polar("3+4i")), 0.0) }, 3 + 4i},
+		{"rect", func() (any, error) { return rect(2.0, math.Pi/2) }, 2i},
+		{"rect with complex magnitude", func() (any, error) { return rect("1i", 0.0) }, nil},
+		{"re of invalid literal", func() (any, error) { return re("3+4") }, nil},
+	})
+}
+
+func TestPolarForm(t *testing.T) {
+	got, err := polar("3+4i")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := got.(polarForm)
+	if !ok {
+		t.Fatalf("got %T, want polarForm", got)
+	}
+	if math.Abs(p.r-5) > tolerance || math.Abs(p.theta-math.Atan2(4, 3)) > tolerance {
+		t.Errorf("got %v, want 5∠%g", p, math.Atan2(4, 3))
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"math/big"
+	"math/cmplx"
 
 	"github.com/toxyl/math"
 )
@@ -11,7 +13,7 @@ var (
 	// @Desc:  last result
 	// @Range: -
 	// @Unit:  -
-	res = 0.0
+	res any = 0.0
 )
 
 // @Name: add
@@ -19,9 +21,13 @@ var (
 // @Param:      x       - -   0   First number
 // @Param:      y       - -   0   Second number
 // @Returns:    result  - -   0   Sum of the numbers
-func add(x, y float64) (result float64, err error) {
-	res = x + y
-	return res, nil
+func add(x, y any) (result any, err error) {
+	if usePrecise(x, y) {
+		return preciseBinary(x, y, func(a, b *bigNumber) (*bigNumber, error) { return a.combine(b, (*big.Rat).Add, (*big.Float).Add) })
+	}
+	return binary(x, y,
+		func(a, b float64) (float64, error) { return a + b, nil },
+		func(a, b complex128) (complex128, error) { return a + b, nil })
 }
 
 // @Name: sub
@@ -29,9 +35,13 @@ func add(x, y float64) (result float64, err error) {
 // @Param:      x       - -   0   First number
 // @Param:      y       - -   0   Second number
 // @Returns:    result  - -   0   Difference of the numbers
-func sub(x, y float64) (result float64, err error) {
-	res = x - y
-	return res, nil
+func sub(x, y any) (result any, err error) {
+	if usePrecise(x, y) {
+		return preciseBinary(x, y, func(a, b *bigNumber) (*bigNumber, error) { return a.combine(b, (*big.Rat).Sub, (*big.Float).Sub) })
+	}
+	return binary(x, y,
+		func(a, b float64) (float64, error) { return a - b, nil },
+		func(a, b complex128) (complex128, error) { return a - b, nil })
 }
 
 // @Name: mul
@@ -39,9 +49,13 @@ func sub(x, y float64) (result float64, err error) {
 // @Param:      x       - -   0   First number
 // @Param:      y       - -   0   Second number
 // @Returns:    result  - -   0   Product of the numbers
-func mul(x, y float64) (result float64, err error) {
-	res = x * y
-	return res, nil
+func mul(x, y any) (result any, err error) {
+	if usePrecise(x, y) {
+		return preciseBinary(x, y, func(a, b *bigNumber) (*bigNumber, error) { return a.combine(b, (*big.Rat).Mul, (*big.Float).Mul) })
+	}
+	return binary(x, y,
+		func(a, b float64) (float64, error) { return a * b, nil },
+		func(a, b complex128) (complex128, error) { return a * b, nil })
 }
 
 // @Name: div
@@ -49,110 +63,173 @@ func mul(x, y float64) (result float64, err error) {
 // @Param:      x       - -   0   First number
 // @Param:      y       - -   0   Second number
 // @Returns:    result  - -   0   Quotient of the numbers
-func div(x, y float64) (result float64, err error) {
-	if y == 0 {
-		res = 0
-		return res, fmt.Errorf("division by zero")
+func div(x, y any) (result any, err error) {
+	if usePrecise(x, y) {
+		return preciseBinary(x, y, func(a, b *bigNumber) (*bigNumber, error) {
+			if b.sign() == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return a.combine(b, (*big.Rat).Quo, (*big.Float).Quo)
+		})
 	}
-	res = x / y
-	return res, nil
+	return binary(x, y,
+		func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return a / b, nil
+		},
+		func(a, b complex128) (complex128, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return a / b, nil
+		})
 }
 
 // @Name: pow
-// @Desc: Raises a number to a pow
+// @Desc: Raises a number to a pow, with a precision set the exponent must be a fraction with a denominator of at most 1000 (e.g. "1/3"), otherwise float64 is used
 // @Param:      x       - -   1   Base number
 // @Param:      y       - -   1   Exponent
 // @Returns:    result  - -   1   Base raised to the pow of exp
-func pow(x, y float64) (result float64, err error) {
-	res = math.Pow(x, y)
-	return res, nil
+func pow(x, y any) (result any, err error) {
+	if usePrecise(x, y) {
+		if e, err := toBig(y); err == nil && e.rat != nil && e.rat.Denom().Cmp(big.NewInt(maxRootDegree)) <= 0 {
+			return preciseUnary(x, func(a *bigNumber) (*bigNumber, error) {
+				p, err := a.powInt(e.rat.Num())
+				if err != nil {
+					return nil, err
+				}
+				return p.root(e.rat.Denom().Int64())
+			})
+		}
+	}
+	return binary(x, y,
+		func(a, b float64) (float64, error) {
+			if a < 0 && b != math.Trunc(b) {
+				return 0, fmt.Errorf("cannot raise negative number to a fractional power, enable complex-mode for a complex result")
+			}
+			return math.Pow(a, b), nil
+		},
+		func(a, b complex128) (complex128, error) { return cmplx.Pow(a, b), nil })
 }
 
 // @Name: sqrt
-// @Desc: Calculates square root of a number
+// @Desc: Calculates square root of a number, negative numbers need complex-mode
 // @Param:      x       - -   0   Number to calculate square root of
 // @Returns:    result  - -   0   Square root of the number
-func sqrt(x float64) (result float64, err error) {
-	if x < 0 {
-		res = 0
-		return res, fmt.Errorf("cannot calculate square root of negative number")
+func sqrt(x any) (result any, err error) {
+	if usePrecise(x) {
+		return preciseUnary(x, (*bigNumber).sqrt)
 	}
-	res = math.Sqrt(x)
-	return res, nil
+	return unary(x,
+		func(a float64) (float64, error) {
+			if a < 0 {
+				return 0, fmt.Errorf("cannot calculate square root of negative number, enable complex-mode for a complex result")
+			}
+			return math.Sqrt(a), nil
+		},
+		func(a complex128) (complex128, error) { return cmplx.Sqrt(a), nil })
 }
 
 // @Name: abs
-// @Desc: Returns the absolute value of a number
+// @Desc: Returns the absolute value of a number, for complex numbers their magnitude
 // @Param:      x       -	-   0   Number to get absolute value of
 // @Returns:    result  -	-   0   Absolute value of the number
-func abs(x float64) (result float64, err error) {
-	res = math.Abs(x)
-	return res, nil
+func abs(x any) (result any, err error) {
+	if usePrecise(x) {
+		return preciseUnary(x, func(a *bigNumber) (*bigNumber, error) {
+			if a.sign() < 0 {
+				return a.neg(), nil
+			}
+			return a, nil
+		})
+	}
+	return unary(x,
+		func(a float64) (float64, error) { return math.Abs(a), nil },
+		func(a complex128) (complex128, error) { return complex(cmplx.Abs(a), 0), nil })
 }
 
 // @Name: sin
 // @Desc: Calculates the sine of a number (in radians)
 // @Param:      x       rad	-   0   Angle in radians
 // @Returns:    result  -	-   0   Sine of the angle
-func sin(x float64) (result float64, err error) {
-	res = math.Sin(x)
-	return res, nil
+func sin(x any) (result any, err error) {
+	return unary(x,
+		func(a float64) (float64, error) { return math.Sin(a), nil },
+		func(a complex128) (complex128, error) { return cmplx.Sin(a), nil })
 }
 
 // @Name: cos
 // @Desc: Calculates the cosine of a number (in radians)
 // @Param:      x       -	-   0   Angle in radians
 // @Returns:    result  -	-   0   Cosine of the angle
-func cos(x float64) (result float64, err error) {
-	res = math.Cos(x)
-	return res, nil
+func cos(x any) (result any, err error) {
+	return unary(x,
+		func(a float64) (float64, error) { return math.Cos(a), nil },
+		func(a complex128) (complex128, error) { return cmplx.Cos(a), nil })
 }
 
 // @Name: tan
 // @Desc: Calculates the tangent of a number (in radians)
 // @Param:      x       -	-   0   Angle in radians
 // @Returns:    result  -	-   0   Tangent of the angle
-func tan(x float64) (result float64, err error) {
-	res = math.Tan(x)
-	return res, nil
+func tan(x any) (result any, err error) {
+	return unary(x,
+		func(a float64) (float64, error) { return math.Tan(a), nil },
+		func(a complex128) (complex128, error) { return cmplx.Tan(a), nil })
 }
 
 // @Name: log
-// @Desc: Calculates the natural logarithm of a number
+// @Desc: Calculates the natural logarithm of a number, negative numbers need complex-mode
 // @Param:      x       -	-   0   Number to calculate logarithm of
 // @Returns:    result  -	-   0   Natural logarithm of the number
-func log(x float64) (result float64, err error) {
-	if x <= 0 {
-		res = 0
-		return res, fmt.Errorf("cannot calculate logarithm of non-positive number")
-	}
-	res = math.Log(x)
-	return res, nil
+func log(x any) (result any, err error) {
+	return unary(x,
+		func(a float64) (float64, error) {
+			if a <= 0 {
+				return 0, fmt.Errorf("cannot calculate logarithm of non-positive number, enable complex-mode for a complex result of negative numbers")
+			}
+			return math.Log(a), nil
+		},
+		func(a complex128) (complex128, error) {
+			if a == 0 {
+				return 0, fmt.Errorf("cannot calculate logarithm of zero")
+			}
+			return cmplx.Log(a), nil
+		})
 }
 
 // @Name: exp
 // @Desc: Calculates e raised to the power of x
 // @Param:      x       -	-   0   Exponent
 // @Returns:    result  -	-   0   e raised to the power of x
-func exp(x float64) (result float64, err error) {
-	res = math.Exp(x)
-	return res, nil
+func exp(x any) (result any, err error) {
+	return unary(x,
+		func(a float64) (float64, error) { return math.Exp(a), nil },
+		func(a complex128) (complex128, error) { return cmplx.Exp(a), nil })
 }
 
 // @Name: floor
 // @Desc: Returns the greatest integer value less than or equal to x
 // @Param:      x       -	-   0   Number to round down
 // @Returns:    result  -	-   0   Greatest integer less than or equal to x
-func floor(x float64) (result float64, err error) {
-	res = math.Floor(x)
-	return res, nil
+func floor(x any) (result any, err error) {
+	if usePrecise(x) {
+		return preciseUnary(x, func(a *bigNumber) (*bigNumber, error) { return a.round(false) })
+	}
+	f, err := toReal(x)
+	return setRealResult(math.Floor(f), err)
 }
 
 // @Name: ceil
 // @Desc: Returns the least integer value greater than or equal to x
 // @Param:      x       -	-   0   Number to round up
 // @Returns:    result  -	-   0   Least integer greater than or equal to x
-func ceil(x float64) (result float64, err error) {
-	res = math.Ceil(x)
-	return res, nil
+func ceil(x any) (result any, err error) {
+	if usePrecise(x) {
+		return preciseUnary(x, func(a *bigNumber) (*bigNumber, error) { return a.round(true) })
+	}
+	f, err := toReal(x)
+	return setRealResult(math.Ceil(f), err)
 }
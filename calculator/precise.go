@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/toxyl/math"
+)
+
+var (
+	// @Name:  precision
+	// @Desc:  Significant digits of add, sub, mul, div, pow, sqrt, abs, floor and ceil, 0 uses float64. Results stay exact fractions where possible (0.1+0.2 is exactly 0.3), others are rounded to this many digits.
+	// @Range: 0..10000
+	// @Unit:  digits
+	precision = 0
+)
+
+// maxPrecision is the largest supported number of significant digits
+const maxPrecision = 10000
+
+// maxRootDegree is the largest denominator of exponents that pow calculates with arbitrary precision, e.g. 1/1000
+const maxRootDegree = 1000
+
+// maxExactBits is the largest size of the numerator or denominator of an exact power, larger powers are rounded
+const maxExactBits = 1 << 20
+
+// maxExponent is the largest binary exponent of results that aren't integers, printing numbers
+// further away from 1 takes minutes, e.g. 2^-1000000
+const maxExponent = 1 << 16
+
+// bigNumber is an arbitrary-precision number, exact numbers are kept as fractions
+type bigNumber struct {
+	rat *big.Rat   // the exact value, nil if the number was rounded
+	f   *big.Float // the rounded value if rat is nil
+}
+
+func (n *bigNumber) String() string {
+	if n.rat != nil && n.rat.IsInt() {
+		return n.rat.Num().String()
+	}
+	return n.float().Text('g', digits())
+}
+
+// Helper function to get the value as big.Float with the current precision
+func (n *bigNumber) float() *big.Float {
+	if n.rat == nil {
+		return n.f
+	}
+	return new(big.Float).SetPrec(precisionBits()).SetRat(n.rat)
+}
+
+// Helper function to get the value as float64, for functions that are not calculated with arbitrary precision
+func (n *bigNumber) float64() float64 {
+	f, _ := n.float().Float64()
+	return f
+}
+
+// Helper function to get the sign of the number: -1, 0 or +1
+func (n *bigNumber) sign() int {
+	if n.rat != nil {
+		return n.rat.Sign()
+	}
+	return n.f.Sign()
+}
+
+// Helper function to combine two numbers, exactly if both are exact, rounded otherwise
+func (n *bigNumber) combine(o *bigNumber, exact func(z, a, b *big.Rat) *big.Rat, rounded func(z, a, b *big.Float) *big.Float) (*bigNumber, error) {
+	if n.rat != nil && o.rat != nil {
+		return &bigNumber{rat: exact(new(big.Rat), n.rat, o.rat)}, nil
+	}
+	res := rounded(new(big.Float).SetPrec(precisionBits()), n.float(), o.float())
+	if res.IsInf() {
+		return nil, fmt.Errorf("result is too large")
+	}
+	return &bigNumber{f: res}, nil
+}
+
+// Helper function to flip the sign of the number
+func (n *bigNumber) neg() *bigNumber {
+	if n.rat != nil {
+		return &bigNumber{rat: new(big.Rat).Neg(n.rat)}
+	}
+	return &bigNumber{f: new(big.Float).Neg(n.f)}
+}
+
+// Helper function to round towards negative infinity (floor) or positive infinity (ceil)
+func (n *bigNumber) round(up bool) (*bigNumber, error) {
+	r := n.rat
+	if r == nil {
+		if n.f.IsInf() {
+			return nil, fmt.Errorf("cannot round an infinite number")
+		}
+		r, _ = n.f.Rat(nil)
+	}
+	q, m := new(big.Int).DivMod(r.Num(), r.Denom(), new(big.Int)) // Euclidean division, the remainder is never negative
+	if up && m.Sign() != 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return &bigNumber{rat: new(big.Rat).SetInt(q)}, nil
+}
+
+// Helper function to check that the number can be printed, integers are limited by maxExactBits
+// and print quickly, other numbers must have a binary exponent within ±maxExponent
+func (n *bigNumber) checkRange() error {
+	var exp int
+	switch {
+	case n.rat != nil && n.rat.IsInt():
+		return nil
+	case n.rat != nil:
+		exp = n.rat.Num().BitLen() - n.rat.Denom().BitLen()
+	case n.f.IsInf():
+		return fmt.Errorf("result is too large")
+	default:
+		exp = n.f.MantExp(nil)
+	}
+	if exp > maxExponent {
+		return fmt.Errorf("result is too large")
+	}
+	if exp < -maxExponent {
+		return fmt.Errorf("result is too close to zero")
+	}
+	return nil
+}
+
+// Helper function to raise the number to an integer power, the result is exact unless it gets too large
+func (n *bigNumber) powInt(e *big.Int) (*bigNumber, error) {
+	if n.sign() == 0 && e.Sign() < 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	if n.rat != nil && e.IsInt64() && e.Int64() > -maxExactBits && e.Int64() < maxExactBits {
+		size := max(n.rat.Num().BitLen(), n.rat.Denom().BitLen())
+		if abs := math.Abs(e.Int64()); int64(size)*abs <= maxExactBits {
+			num := new(big.Int).Exp(n.rat.Num(), big.NewInt(abs), nil)
+			den := new(big.Int).Exp(n.rat.Denom(), big.NewInt(abs), nil)
+			if e.Sign() < 0 {
+				num, den = den, num
+			}
+			return &bigNumber{rat: new(big.Rat).SetFrac(num, den)}, nil
+		}
+	}
+	res := floatPow(n.float(), e, precisionBits())
+	if res.IsInf() {
+		return nil, fmt.Errorf("result is too large")
+	}
+	return &bigNumber{f: res}, nil
+}
+
+// Helper function to calculate the q-th root, exact if numerator and denominator are perfect q-th powers
+func (n *bigNumber) root(q int64) (*bigNumber, error) {
+	if n.sign() < 0 && q%2 == 0 {
+		return nil, fmt.Errorf("cannot calculate even root of negative number, enable complex-mode for a complex result")
+	}
+	if n.sign() < 0 {
+		r, err := n.neg().root(q)
+		if err != nil {
+			return nil, err
+		}
+		return r.neg(), nil
+	}
+	if n.sign() == 0 || q == 1 {
+		return n, nil
+	}
+	if n.rat != nil {
+		num, numOK := intRoot(n.rat.Num(), q)
+		den, denOK := intRoot(n.rat.Denom(), q)
+		if numOK && denOK {
+			return &bigNumber{rat: new(big.Rat).SetFrac(num, den)}, nil
+		}
+	}
+	return &bigNumber{f: floatRoot(n.float(), q, precisionBits())}, nil
+}
+
+// Helper function to calculate the square root, exact if numerator and denominator are perfect squares
+func (n *bigNumber) sqrt() (*bigNumber, error) {
+	if n.sign() < 0 {
+		return nil, fmt.Errorf("cannot calculate square root of negative number, enable complex-mode for a complex result")
+	}
+	return n.root(2)
+}
+
+// Helper function to raise a float to an integer power by squaring, with precision bits in the result
+func floatPow(x *big.Float, e *big.Int, precision uint) *big.Float {
+	prec := precision + uint(e.BitLen()) // every squaring can lose a bit
+	base := new(big.Float).SetPrec(prec).Set(x)
+	res := new(big.Float).SetPrec(prec).SetInt64(1)
+	for i := new(big.Int).Abs(e); i.Sign() > 0; i.Rsh(i, 1) {
+		if i.Bit(0) == 1 {
+			res.Mul(res, base)
+		}
+		base.Mul(base, base)
+	}
+	if e.Sign() < 0 {
+		res.Quo(new(big.Float).SetPrec(prec).SetInt64(1), res)
+	}
+	return res.SetPrec(precision)
+}
+
+// Helper function to calculate the q-th root of a positive float with Newton's method, with precision bits in the result
+func floatRoot(a *big.Float, q int64, precision uint) *big.Float {
+	prec := precision + 32
+	// the float64 root of the mantissa is the starting point, the exponent is divided separately,
+	// so the start value doesn't overflow
+	mant := new(big.Float)
+	exp := int64(a.MantExp(mant))
+	eq := exp / q
+	if exp%q < 0 {
+		eq--
+	}
+	m, _ := mant.Float64()
+	x := new(big.Float).SetPrec(prec).SetFloat64(math.Pow(m, 1/float64(q)) * math.Pow(2, float64(exp-eq*q)/float64(q)))
+	x.SetMantExp(x, int(eq))
+
+	qf := new(big.Float).SetPrec(prec).SetInt64(q)
+	q1 := new(big.Float).SetPrec(prec).SetInt64(q - 1)
+	e := big.NewInt(q - 1)
+	for range 200 {
+		// x = ((q-1)x + a/x^(q-1)) / q
+		next := new(big.Float).SetPrec(prec).Quo(a, floatPow(x, e, prec))
+		next.Add(next, new(big.Float).SetPrec(prec).Mul(q1, x))
+		next.Quo(next, qf)
+		diff := new(big.Float).SetPrec(prec).Sub(next, x)
+		x = next
+		if diff.Sign() == 0 || diff.MantExp(nil)+int(precision)+2 < x.MantExp(nil) {
+			break
+		}
+	}
+	return x.SetPrec(precision)
+}
+
+// Helper function to calculate the q-th root of a positive integer, ok is true if the root is an integer
+func intRoot(v *big.Int, q int64) (root *big.Int, ok bool) {
+	f := floatRoot(new(big.Float).SetInt(v), q, uint(v.BitLen())/uint(q)+64)
+	f.Add(f, big.NewFloat(0.5))
+	root, _ = f.Int(nil)
+	return root, new(big.Int).Exp(root, big.NewInt(q), nil).Cmp(v) == 0
+}
+
+// Helper function to check whether arguments are evaluated with arbitrary precision,
+// that is if a precision is set and they don't need complex numbers
+func usePrecise(args ...any) bool {
+	return precision > 0 && !useComplex(args...)
+}
+
+// Helper function to get the current number of significant digits
+func digits() int {
+	return min(max(precision, 1), maxPrecision)
+}
+
+// Helper function to get the mantissa size in bits needed for the current number of significant digits
+func precisionBits() uint {
+	return uint(math.Ceil(float64(digits())*math.Log2(10.0))) + 8 // guard bits against rounding errors in intermediate results
+}
+
+// Helper function to convert a value to an arbitrary-precision number, floats are converted via their
+// shortest decimal representation, so 0.1 is exactly 1/10. Strings can also be fractions like "1/3".
+func toBig(v any) (*bigNumber, error) {
+	var s string
+	switch n := v.(type) {
+	case *bigNumber:
+		return n, nil
+	case string:
+		s = n
+	case int:
+		return &bigNumber{rat: new(big.Rat).SetInt64(int64(n))}, nil
+	case int64:
+		return &bigNumber{rat: new(big.Rat).SetInt64(n)}, nil
+	default:
+		f, err := toReal(v)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%v is not a finite number", f)
+		}
+		s = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a number", s)
+	}
+	return &bigNumber{rat: r}, nil
+}
+
+// Helper function to evaluate a function of one number with arbitrary precision
+func preciseUnary(x any, fn func(*bigNumber) (*bigNumber, error)) (any, error) {
+	a, err := toBig(x)
+	if err != nil {
+		return setPreciseResult(nil, err)
+	}
+	return setPreciseResult(fn(a))
+}
+
+// Helper function to evaluate a function of two numbers with arbitrary precision
+func preciseBinary(x, y any, fn func(a, b *bigNumber) (*bigNumber, error)) (any, error) {
+	a, err := toBig(x)
+	if err != nil {
+		return setPreciseResult(nil, err)
+	}
+	b, err := toBig(y)
+	if err != nil {
+		return setPreciseResult(nil, err)
+	}
+	return setPreciseResult(fn(a, b))
+}
+
+// Helper function to store an arbitrary-precision result as last result, errors and results
+// that are too large to print reset it to 0
+func setPreciseResult(n *bigNumber, err error) (any, error) {
+	if err == nil {
+		err = n.checkRange()
+	}
+	if err != nil {
+		res = 0.0
+		return res, err
+	}
+	res = n
+	return res, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+// Helper function to set the precision for the rest of a test
+func setPrecision(t *testing.T, digits int) {
+	prev := precision
+	precision = digits
+	t.Cleanup(func() { precision = prev })
+}
+
+func TestPrecise(t *testing.T) {
+	setComplexMode(t, false)
+	cases := []struct {
+		name   string
+		digits int
+		run    func() (any, error)
+		want   string // the printed result, empty if an error is expected
+	}{
+		{"sum of tenths is exact", 20, func() (any, error) { return add(0.1, 0.2) }, "0.3"},
+		{"sum of tenths equals 0.3", 20, func() (any, error) { return sub(must(add(0.1, 0.2)), 0.3) }, "0"},
+		{"float64 sum of tenths", 0, func() (any, error) { return add(0.1, 0.2) }, "0.30000000000000004"},
+		{"product of decimals", 20, func() (any, error) { return mul(1.1, 1.1) }, "1.21"},
+		{"fraction string", 20, func() (any, error) { return add("1/3", "2/3") }, "1"},
+		{"division is rounded when printed", 10, func() (any, error) { return div(1, 3) }, "0.3333333333"},
+		{"division by zero", 10, func() (any, error) { return div(1, 0) }, ""},
+		{"large integer power is exact", 10, func() (any, error) { return pow(2, 100) }, "1267650600228229401496703205376"},
+		{"negative power", 10, func() (any, error) { return pow(2, -2) }, "0.25"},
+		{"zero to negative power", 10, func() (any, error) { return pow(0, -1) }, ""},
+		{"cube root is exact", 10, func() (any, error) { return pow(8, "1/3") }, "2"},
+		{"fractional exponent", 10, func() (any, error) { return pow(27, "2/3") }, "9"},
+		{"fractional exponent of fraction", 10, func() (any, error) { return pow("16/81", 0.25) }, "0.6666666667"},
+		{"odd root of negative", 10, func() (any, error) { return pow(-8, "1/3") }, "-2"},
+		{"even root of negative", 10, func() (any, error) { return pow(-8, "1/2") }, ""},
+		{"irrational root", 30, func() (any, error) { return pow(2, 0.5) }, "1.41421356237309504880168872421"},
+		{"sqrt is exact for squares", 10, func() (any, error) { return sqrt("9/4") }, "1.5"},
+		{"sqrt", 30, func() (any, error) { return sqrt(2) }, "1.41421356237309504880168872421"},
+		{"sqrt of negative", 10, func() (any, error) { return sqrt(-4) }, ""},
+		{"abs", 10, func() (any, error) { return abs("-7/2") }, "3.5"},
+		{"floor of negative fraction", 10, func() (any, error) { return floor(-2.5) }, "-3"},
+		{"ceil of negative fraction", 10, func() (any, error) { return ceil(-2.5) }, "-2"},
+		{"floor of small negative fraction", 10, func() (any, error) { return floor(-0.1) }, "-1"},
+		{"ceil of small negative fraction", 10, func() (any, error) { return ceil(-0.1) }, "0"},
+		{"floor of integer", 10, func() (any, error) { return floor(-3) }, "-3"},
+		{"ceil of positive fraction", 10, func() (any, error) { return ceil("7/2") }, "4"},
+		{"floor of rounded value", 10, func() (any, error) { return floor(must(sqrt(2))) }, "1"},
+		{"not a number", 10, func() (any, error) { return add("x", 1) }, ""},
+		{"rounded result too large to print", 5, func() (any, error) { return pow(must(sqrt(2)), 2000000000) }, ""},
+		{"exact result too close to zero to print", 5, func() (any, error) { return pow("1/3", 500000) }, ""},
+		{"large exact integer", 5, func() (any, error) { return sub(must(pow(2, 100000)), must(pow(2, 100000))) }, "0"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setPrecision(t, c.digits)
+			got, err := c.run()
+			if c.want == "" {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s := fmt.Sprint(got); s != c.want {
+				t.Errorf("got %s, want %s", s, c.want)
+			}
+		})
+	}
+}
+
+func TestPreciseOverflow(t *testing.T) {
+	setPrecision(t, 5)
+	huge := &bigNumber{f: new(big.Float).SetPrec(precisionBits()).SetMantExp(big.NewFloat(1), 1<<30)}
+	if _, err := huge.combine(huge, (*big.Rat).Mul, (*big.Float).Mul); err == nil {
+		t.Error("multiplying beyond the float range succeeded, want an error")
+	}
+	inf := &bigNumber{f: new(big.Float).SetInf(false)}
+	if _, err := inf.round(false); err == nil {
+		t.Error("rounding an infinite number succeeded, want an error")
+	}
+	if err := huge.checkRange(); err == nil {
+		t.Error("a number with a binary exponent of 2^30 passed the range check")
+	}
+}
+
+func TestIntRoot(t *testing.T) {
+	pow10 := func(e int64) *big.Int { return new(big.Int).Exp(big.NewInt(10), big.NewInt(e), nil) }
+	cases := []struct {
+		v    *big.Int
+		q    int64
+		want *big.Int
+		ok   bool
+	}{
+		{big.NewInt(1), 5, big.NewInt(1), true},
+		{big.NewInt(27), 3, big.NewInt(3), true},
+		{big.NewInt(28), 3, big.NewInt(3), false},
+		{big.NewInt(26), 3, big.NewInt(3), false},
+		{new(big.Int).Lsh(big.NewInt(1), 200), 4, new(big.Int).Lsh(big.NewInt(1), 50), true},
+		{pow10(300), 3, pow10(100), true},
+		{new(big.Int).Add(pow10(300), big.NewInt(1)), 3, pow10(100), false},
+		{pow10(20), 1000, big.NewInt(1), false},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%d-th root of %s", c.q, c.v), func(t *testing.T) {
+			root, ok := intRoot(c.v, c.q)
+			if root.Cmp(c.want) != 0 || ok != c.ok {
+				t.Errorf("got %s, %t, want %s, %t", root, ok, c.want, c.ok)
+			}
+		})
+	}
+}
+
+func TestFloatRoot(t *testing.T) {
+	const bits = 200
+	for _, q := range []int64{2, 3, 7, 1000} {
+		for _, a := range []string{"2", "0.001", "1e300", "1e-300"} {
+			t.Run(fmt.Sprintf("%d-th root of %s", q, a), func(t *testing.T) {
+				x, _, err := big.ParseFloat(a, 10, bits, big.ToNearestEven)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := floatRoot(x, q, bits)
+				back := floatPow(r, big.NewInt(q), bits)
+				diff := new(big.Float).Sub(back, x)
+				if diff.Sign() != 0 && diff.MantExp(nil)+bits-16 > x.MantExp(nil) {
+					t.Errorf("root %s raised to %d is %s, want %s", r.Text('g', 20), q, back.Text('g', 20), a)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/toxyl/math"
+)
+
+// numberList is a list of real numbers. DSL functions take a fixed number of arguments,
+// so the statistics functions take all their numbers as a single list argument.
+type numberList []float64
+
+func (l numberList) String() string {
+	return fmt.Sprint([]float64(l))
+}
+
+// regression is the result of a linear regression, y = slope * x + intercept
+type regression struct {
+	slope, intercept, r2 float64
+}
+
+func (r regression) String() string {
+	return fmt.Sprintf("slope: %g, intercept: %g, r²: %g", r.slope, r.intercept, r.r2)
+}
+
+// @Name: list
+// @Desc: Creates a list of numbers, e.g. to keep it in last. Statistics functions take one list instead of separate numbers, sum(1 2 3) is written as sum("1 2 3").
+// @Param:      values  - -   ""   Numbers separated by spaces, commas, semicolons or line breaks, e.g. "1 2.5 -3"
+// @Returns:    result  - -   -    The list
+func list(values any) (result any, err error) {
+	xs, err := toList(values)
+	return setListResult(xs, err)
+}
+
+// @Name: sum
+// @Desc: Adds all numbers of a list, exactly if a precision is set, e.g. sum("1 2 3") is 6
+// @Param:      xs      - -   ""   The numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Returns:    result  - -   0    Sum of the numbers
+func sum(xs any) (result any, err error) {
+	l, err := toList(xs)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	if usePrecise() {
+		s, err := preciseSum(l)
+		return setPreciseResult(s, err)
+	}
+	var s float64
+	for _, x := range l {
+		s += x
+	}
+	return setRealResult(s, nil)
+}
+
+// @Name: mean
+// @Desc: Calculates the arithmetic mean of a list, exactly if a precision is set
+// @Param:      xs      - -   ""   The numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Returns:    result  - -   0    Mean of the numbers
+func mean(xs any) (result any, err error) {
+	l, err := nonEmptyList(xs)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	if usePrecise() {
+		s, err := preciseSum(l)
+		if err != nil {
+			return setPreciseResult(nil, err)
+		}
+		return setPreciseResult(s.combine(&bigNumber{rat: new(big.Rat).SetInt64(int64(len(l)))}, (*big.Rat).Quo, (*big.Float).Quo))
+	}
+	return setRealResult(average(l), nil)
+}
+
+// @Name: median
+// @Desc: Returns the middle value of a list, for an even number of values the mean of the two middle values
+// @Param:      xs      - -   ""   The numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Returns:    result  - -   0    Median of the numbers
+func median(xs any) (result any, err error) {
+	l, err := nonEmptyList(xs)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	return setRealResult(quantile(l, 0.5), nil)
+}
+
+// @Name: mode
+// @Desc: Returns the most frequent value of a list, the smallest one if several are equally frequent
+// @Param:      xs      - -   ""   The numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Returns:    result  - -   0    Mode of the numbers
+func mode(xs any) (result any, err error) {
+	l, err := nonEmptyList(xs)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	s := slices.Sorted(slices.Values(l))
+	best, bestCount := s[0], 0
+	for i := 0; i < len(s); {
+		j := i
+		for j < len(s) && s[j] == s[i] {
+			j++
+		}
+		if j-i > bestCount {
+			best, bestCount = s[i], j-i
+		}
+		i = j
+	}
+	return setRealResult(best, nil)
+}
+
+// @Name: variance
+// @Desc: Calculates the sample variance of a list (divided by n-1)
+// @Param:      xs      - -   ""   The numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Returns:    result  - -   0    Variance of the numbers
+func variance(xs any) (result any, err error) {
+	l, err := toList(xs)
+	if err == nil && len(l) < 2 {
+		err = fmt.Errorf("variance needs at least 2 numbers")
+	}
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	return setRealResult(sampleVariance(l), nil)
+}
+
+// @Name: stddev
+// @Desc: Calculates the sample standard deviation of a list (square root of the variance)
+// @Param:      xs      - -   ""   The numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Returns:    result  - -   0    Standard deviation of the numbers
+func stddev(xs any) (result any, err error) {
+	l, err := toList(xs)
+	if err == nil && len(l) < 2 {
+		err = fmt.Errorf("standard deviation needs at least 2 numbers")
+	}
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	return setRealResult(math.Sqrt(sampleVariance(l)), nil)
+}
+
+// @Name: percentile
+// @Desc: Returns the value below which the given percentage of a list falls, interpolating linearly between values
+// @Param:      xs      - 	-   	""   The numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Param:      p       % 	0..100  50   The percentage
+// @Returns:    result  - 	-   	0    The percentile
+func percentile(xs, p any) (result any, err error) {
+	l, err := nonEmptyList(xs)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	pct, err := toReal(p)
+	if err == nil && (pct < 0 || pct > 100) {
+		err = fmt.Errorf("percentage must be between 0 and 100")
+	}
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	return setRealResult(quantile(l, pct/100), nil)
+}
+
+// @Name: min
+// @Desc: Returns the smallest number of a list, e.g. min("3 1 2") is 1
+// @Param:      xs      - -   ""   The numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Returns:    result  - -   0    The smallest number
+func minimum(xs any) (result any, err error) {
+	l, err := nonEmptyList(xs)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	return setRealResult(slices.Min(l), nil)
+}
+
+// @Name: max
+// @Desc: Returns the largest number of a list, e.g. max("3 1 2") is 3
+// @Param:      xs      - -   ""   The numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Returns:    result  - -   0    The largest number
+func maximum(xs any) (result any, err error) {
+	l, err := nonEmptyList(xs)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	return setRealResult(slices.Max(l), nil)
+}
+
+// @Name: correlation
+// @Desc: Calculates the Pearson correlation coefficient of two lists of the same length
+// @Param:      xs      - 	-   	""   The first numbers, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Param:      ys      - 	-   	""   The second numbers
+// @Returns:    result  - 	-1..1  	0    1 if the numbers increase together, -1 if one decreases when the other increases, 0 if they are unrelated
+func correlation(xs, ys any) (result any, err error) {
+	x, y, err := listPair(xs, ys)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	sxx, syy, sxy := covariances(x, y)
+	if sxx == 0 || syy == 0 {
+		return setRealResult(0, fmt.Errorf("correlation is undefined for constant lists"))
+	}
+	return setRealResult(sxy/math.Sqrt(sxx*syy), nil)
+}
+
+// @Name: linear-regression
+// @Desc: Fits a line through points with least squares
+// @Param:      xs      - -   ""   The x coordinates, a list or numbers separated by spaces, commas, semicolons or line breaks
+// @Param:      ys      - -   ""   The y coordinates
+// @Returns:    result  - -   -    Slope, intercept and coefficient of determination (r²) of the line
+func linearRegression(xs, ys any) (result any, err error) {
+	x, y, err := listPair(xs, ys)
+	if err != nil {
+		return setRealResult(0, err)
+	}
+	sxx, syy, sxy := covariances(x, y)
+	if sxx == 0 {
+		return setRealResult(0, fmt.Errorf("linear regression needs at least 2 different x values"))
+	}
+	r := regression{slope: sxy / sxx, r2: 1}
+	r.intercept = average(y) - r.slope*average(x)
+	if syy != 0 {
+		r.r2 = sxy * sxy / (sxx * syy)
+	}
+	res = r
+	return res, nil
+}
+
+// Helper function to convert a value to a list of numbers, strings are split at spaces, commas, semicolons and line breaks
+func toList(v any) (numberList, error) {
+	switch l := v.(type) {
+	case numberList:
+		return l, nil
+	case []float64:
+		return numberList(l), nil
+	case string:
+		fields := strings.FieldsFunc(strings.Trim(strings.TrimSpace(l), "[]"), func(r rune) bool {
+			return r == ' ' || r == ',' || r == ';' || r == '\t' || r == '\n' || r == '\r'
+		})
+		res := make(numberList, 0, len(fields))
+		for _, f := range fields {
+			x, err := toReal(f)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, x)
+		}
+		return res, nil
+	}
+	x, err := toReal(v)
+	if err != nil {
+		return nil, err
+	}
+	return numberList{x}, nil
+}
+
+// Helper function to convert a value to a list that has at least one number
+func nonEmptyList(v any) (numberList, error) {
+	l, err := toList(v)
+	if err == nil && len(l) == 0 {
+		err = fmt.Errorf("list is empty")
+	}
+	return l, err
+}
+
+// Helper function to convert two values to lists of the same length with at least 2 numbers
+func listPair(xs, ys any) (numberList, numberList, error) {
+	x, err := toList(xs)
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := toList(ys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(x) != len(y) {
+		return nil, nil, fmt.Errorf("lists must have the same length, got %d and %d numbers", len(x), len(y))
+	}
+	if len(x) < 2 {
+		return nil, nil, fmt.Errorf("lists must have at least 2 numbers")
+	}
+	return x, y, nil
+}
+
+// Helper function to store a list as last result, errors reset it to 0
+func setListResult(l numberList, err error) (any, error) {
+	if err != nil {
+		res = 0.0
+		return res, err
+	}
+	res = l
+	return res, nil
+}
+
+// Helper function to add numbers exactly
+func preciseSum(l numberList) (*bigNumber, error) {
+	s := &bigNumber{rat: new(big.Rat)}
+	for _, x := range l {
+		b, err := toBig(x)
+		if err != nil {
+			return nil, err
+		}
+		if s, err = s.combine(b, (*big.Rat).Add, (*big.Float).Add); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Helper function to calculate the arithmetic mean of a non-empty list
+func average(l numberList) float64 {
+	var s float64
+	for _, x := range l {
+		s += x
+	}
+	return s / float64(len(l))
+}
+
+// Helper function to calculate the sample variance of a list with at least 2 numbers
+func sampleVariance(l numberList) float64 {
+	m := average(l)
+	var s float64
+	for _, x := range l {
+		s += (x - m) * (x - m)
+	}
+	return s / float64(len(l)-1)
+}
+
+// Helper function to get the q-quantile (0..1) of a non-empty list, interpolating linearly between the closest ranks
+func quantile(l numberList, q float64) float64 {
+	s := slices.Sorted(slices.Values(l))
+	pos := q * float64(len(s)-1)
+	i := int(math.Floor(pos))
+	if i >= len(s)-1 {
+		return s[len(s)-1]
+	}
+	return s[i] + (pos-float64(i))*(s[i+1]-s[i])
+}
+
+// Helper function to calculate the sums of squared deviations of two lists from their means and of their products
+func covariances(x, y numberList) (sxx, syy, sxy float64) {
+	mx, my := average(x), average(y)
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		sxx += dx * dx
+		syy += dy * dy
+		sxy += dx * dy
+	}
+	return sxx, syy, sxy
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	setComplexMode(t, false)
+	setPrecision(t, 0)
+	runCalcCases(t, []calcCase{
+		{"sum", func() (any, error) { return sum("1 2 3") }, 6.0},
+		{"sum with separators and brackets", func() (any, error) { return sum("[1, 2;\t3\n4]") }, 10.0},
+		{"sum of list", func() (any, error) { return sum(must(list("1 2.5 -3"))) }, 0.5},
+		{"sum of single number", func() (any, error) { return sum(4.0) }, 4.0},
+		{"sum of empty list", func() (any, error) { return sum("") }, 0.0},
+		{"sum with invalid number", func() (any, error) { return sum("1 x 3") }, nil},
+		{"mean", func() (any, error) { return mean("1 2 3 4") }, 2.5},
+		{"mean of empty list", func() (any, error) { return mean("") }, nil},
+		{"median of odd count", func() (any, error) { return median("3 1 2") }, 2.0},
+		{"median of even count", func() (any, error) { return median("4 1 3 2") }, 2.5},
+		{"mode", func() (any, error) { return mode("1 3 2 3 2") }, 2.0},
+		{"mode of distinct values", func() (any, error) { return mode("5 4 6") }, 4.0},
+		{"variance", func() (any, error) { return variance("2 4 4 4 5 5 7 9") }, 32.0 / 7},
+		{"variance of single number", func() (any, error) { return variance("1") }, nil},
+		{"stddev", func() (any, error) { return stddev("2 4 4 4 5 5 7 9") }, math.Sqrt(32.0 / 7)},
+		{"stddev of single number", func() (any, error) { return stddev("1") }, nil},
+		{"min", func() (any, error) { return minimum("3 -1 2") }, -1.0},
+		{"max", func() (any, error) { return maximum("3 -1 2") }, 3.0},
+		{"max of empty list", func() (any, error) { return maximum("") }, nil},
+		{"correlation", func() (any, error) { return correlation("1 2 3", "2 4 6") }, 1.0},
+		{"negative correlation", func() (any, error) { return correlation("1 2 3", "3 2 1") }, -1.0},
+		{"correlation of constant list", func() (any, error) { return correlation("1 2 3", "5 5 5") }, nil},
+		{"correlation of different lengths", func() (any, error) { return correlation("1 2 3", "1 2") }, nil},
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	setComplexMode(t, false)
+	cases := []struct {
+		xs   string
+		p    float64
+		want float64 // NaN if an error is expected
+	}{
+		{"1 2 3 4", 0, 1},
+		{"1 2 3 4", 25, 1.75},
+		{"1 2 3 4", 50, 2.5},
+		{"4 3 2 1", 50, 2.5},
+		{"1 2 3 4", 100, 4},
+		{"10 20", 90, 19},
+		{"15 20 35 40 50", 40, 29},
+		{"7", 30, 7},
+		{"1 2 3", -1, math.NaN()},
+		{"1 2 3", 101, math.NaN()},
+		{"", 50, math.NaN()},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%g%% of %s", c.p, c.xs), func(t *testing.T) {
+			got, err := percentile(c.xs, c.p)
+			if math.IsNaN(c.want) {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if f := got.(float64); math.Abs(f-c.want) > tolerance {
+				t.Errorf("got %g, want %g", f, c.want)
+			}
+		})
+	}
+}
+
+func TestLinearRegression(t *testing.T) {
+	cases := []struct {
+		name   string
+		xs, ys string
+		want   regression
+		err    bool
+	}{
+		{"exact line", "1 2 3 4", "3 5 7 9", regression{slope: 2, intercept: 1, r2: 1}, false},
+		{"noisy line", "1 2 3 4", "1 3 2 4", regression{slope: 0.8, intercept: 0.5, r2: 0.64}, false},
+		{"horizontal line", "1 2 3", "5 5 5", regression{slope: 0, intercept: 5, r2: 1}, false},
+		{"constant x", "2 2 2", "1 2 3", regression{}, true},
+		{"single point", "1", "1", regression{}, true},
+		{"different lengths", "1 2 3", "1 2", regression{}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := linearRegression(c.xs, c.ys)
+			if c.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			r := got.(regression)
+			if math.Abs(r.slope-c.want.slope) > tolerance || math.Abs(r.intercept-c.want.intercept) > tolerance || math.Abs(r.r2-c.want.r2) > tolerance {
+				t.Errorf("got %v, want %v", r, c.want)
+			}
+		})
+	}
+}
+
+func TestPreciseStats(t *testing.T) {
+	setComplexMode(t, false)
+	setPrecision(t, 20)
+	for _, c := range []struct {
+		name string
+		run  func() (any, error)
+		want string
+	}{
+		{"sum of tenths", func() (any, error) { return sum("0.1 0.2") }, "0.3"},
+		{"sum of tenths equals 0.3", func() (any, error) { return sub(must(sum("0.1 0.2")), 0.3) }, "0"},
+		{"mean", func() (any, error) { return mean("0.1 0.2 0.4") }, "0.23333333333333333333"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.run()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s := fmt.Sprint(got); s != c.want {
+				t.Errorf("got %s, want %s", s, c.want)
+			}
+		})
+	}
+}
+
+func TestToList(t *testing.T) {
+	for _, c := range []struct {
+		in   any
+		want numberList
+	}{
+		{"1 2 3", numberList{1, 2, 3}},
+		{" [1,2 ; 3] ", numberList{1, 2, 3}},
+		{"1\r\n-2.5e1", numberList{1, -25}},
+		{"", numberList{}},
+		{numberList{4, 5}, numberList{4, 5}},
+		{[]float64{6}, numberList{6}},
+		{7, numberList{7}},
+	} {
+		t.Run(fmt.Sprint(c.in), func(t *testing.T) {
+			got, err := toList(c.in)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
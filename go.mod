@@ -8,6 +8,7 @@ require (
 	github.com/toxyl/flo v0.0.0-20240412132929-869b69ff6976
 	github.com/toxyl/math v0.0.1-alpha.4
 	github.com/yuin/goldmark v1.7.10
+	golang.org/x/image v0.25.0
 )
 
 require (
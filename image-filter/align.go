@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math/cmplx"
+	"math/rand"
+	"strings"
+
+	"github.com/toxyl/math"
+)
+
+const (
+	// descriptorRadius is the radius of the patch around a corner that is compared when matching features
+	descriptorRadius = 5
+
+	// ransacIterations is the number of random samples tried when fitting a homography to matched features
+	ransacIterations = 2000
+
+	// ransacTolerance is the distance in pixels up to which a matched feature agrees with a homography
+	ransacTolerance = 3.0
+)
+
+// @Name: align
+// @Desc: Aligns an image to a reference image, e.g. frames of a burst, the result has the bounds of the reference and is transparent where the image doesn't cover it
+// @Param:      ref     - 	-   	-   		The reference image
+// @Param:      img     - 	-   	-   		The image to align
+// @Param:      method  - 	-   	"phase"   	How the images are matched: phase (phase correlation, finds shifts, needs a large overlap) or features (matched corners, finds shifts, rotation, scale and perspective)
+// @Returns:    result  - 	-   	-   		The aligned image
+func align(ref, img image.Image, method string) (*image.NRGBA, error) {
+	hm, err := estimateAlignment(ref, img, method)
+	if err != nil {
+		return nil, err
+	}
+	return warp(toNRGBA(img), ref.Bounds(), hm.apply)
+}
+
+// @Name: stitch
+// @Desc: Stitches two overlapping images into a panorama, the second image is aligned to the first and the seam is hidden with multi-band blending
+// @Param:      a       - 	-   	-   		The first image, it keeps its orientation
+// @Param:      b       - 	-   	-   		The second image
+// @Param:      method  - 	-   	"features"  How the images are matched: phase (phase correlation, finds shifts, needs a large overlap) or features (matched corners, finds shifts, rotation, scale and perspective)
+// @Param:      levels  - 	0..   	0   		The number of pyramid levels used for blending, 0 uses levels down to about 8 pixels
+// @Returns:    result  - 	-   	-   		The panorama, transparent where neither image covers it
+func stitch(a, b image.Image, method string, levels int) (*image.NRGBA, error) {
+	hm, err := estimateAlignment(a, b, method)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := hm.inverse()
+	if err != nil {
+		return nil, err
+	}
+	ab, bb := a.Bounds(), b.Bounds()
+	canvas := ab
+	for _, c := range []point{{float64(bb.Min.X), float64(bb.Min.Y)}, {float64(bb.Max.X), float64(bb.Min.Y)}, {float64(bb.Max.X), float64(bb.Max.Y)}, {float64(bb.Min.X), float64(bb.Max.Y)}} {
+		x, y := inv.apply(c.x, c.y)
+		if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+			return nil, fmt.Errorf("the images could not be aligned")
+		}
+		canvas = canvas.Union(image.Rect(int(math.Floor(x)), int(math.Floor(y)), int(math.Ceil(x)), int(math.Ceil(y))))
+	}
+	// a panorama of two images can't be much larger than both, otherwise the alignment went wrong
+	if area := canvas.Dx() * canvas.Dy(); area > 4*(ab.Dx()*ab.Dy()+bb.Dx()*bb.Dy()) {
+		return nil, fmt.Errorf("the images could not be aligned, the panorama would be %dx%d", canvas.Dx(), canvas.Dy())
+	}
+
+	onCanvas := image.NewNRGBA(canvas)
+	draw.Draw(onCanvas, ab, a, ab.Min, draw.Src)
+	warped, err := warp(toNRGBA(b), canvas, hm.apply)
+	if err != nil {
+		return nil, err
+	}
+	pa, levels, err := imagePyramid(onCanvas, levels)
+	if err != nil {
+		return nil, err
+	}
+	pb := newChannelPlanes(warped)
+
+	// where both images cover the canvas, the one whose border is farther away is used,
+	// which puts the seam in the middle of the overlap
+	weights := make([]float64, pa.w*pa.h)
+	for y := canvas.Min.Y; y < canvas.Max.Y; y++ {
+		for x := canvas.Min.X; x < canvas.Max.X; x++ {
+			i := (y-canvas.Min.Y)*pa.w + x - canvas.Min.X
+			inA, inB := pa.c[3][i] > 0, pb.c[3][i] > 0
+			switch {
+			case inA && inB:
+				cx, cy := float64(x)+0.5, float64(y)+0.5
+				bx, by := hm.apply(cx, cy)
+				if borderDistance(ab, cx, cy) >= borderDistance(bb, bx, by) {
+					weights[i] = 1
+				}
+			case inA:
+				weights[i] = 1
+			}
+		}
+	}
+	res := blendPlanes(pa, pb, weights, levels)
+	res.rect = image.Rect(0, 0, pa.w, pa.h)
+	return res.toNRGBA(), nil
+}
+
+// Helper function to estimate the homography that maps points of the reference image onto the other image
+func estimateAlignment(ref, img image.Image, method string) (homography, error) {
+	switch strings.ToLower(method) {
+	case "phase":
+		dx, dy, err := phaseCorrelation(ref, img)
+		if err != nil {
+			return homography{}, err
+		}
+		return homography{1, 0, dx, 0, 1, dy, 0, 0, 1}, nil
+	case "features":
+		return featureHomography(ref, img)
+	}
+	return homography{}, fmt.Errorf("unknown alignment method %q, use phase or features", method)
+}
+
+// Helper function to find the shift between two images with phase correlation: the normalized
+// cross-power spectrum of two shifted images is the spectrum of an impulse at the shift.
+// Returns the position in img of the origin of ref.
+func phaseCorrelation(ref, img image.Image) (dx, dy float64, err error) {
+	ga, aw, ah := grayPlane(ref)
+	gb, bw, bh := grayPlane(img)
+	if aw < 2 || ah < 2 || bw < 2 || bh < 2 {
+		return 0, 0, fmt.Errorf("images must be at least 2x2 pixels")
+	}
+	pw, ph := nextPow2(max(aw, bw)), nextPow2(max(ah, bh))
+	fa, fb := hannPadded(ga, aw, ah, pw, ph), hannPadded(gb, bw, bh, pw, ph)
+	fft2(fa, pw, ph, false)
+	fft2(fb, pw, ph, false)
+	for i := range fa {
+		c := fb[i] * cmplx.Conj(fa[i])
+		if m := cmplx.Abs(c); m > 1e-12 {
+			fa[i] = c / complex(m, 0)
+		} else {
+			fa[i] = 0
+		}
+	}
+	fft2(fa, pw, ph, true)
+
+	peak, best := 0, math.Inf(-1)
+	for i, v := range fa {
+		if real(v) > best {
+			peak, best = i, real(v)
+		}
+	}
+	px, py := peak%pw, peak/pw
+	at := func(x, y int) float64 { return real(fa[((y+ph)%ph)*pw+(x+pw)%pw]) }
+	// a parabola through the peak and its neighbors gives the sub-pixel position
+	refine := func(l, c, r float64) float64 {
+		if d := l - 2*c + r; d < 0 {
+			return math.Clamp(0.5*(l-r)/d, -0.5, 0.5)
+		}
+		return 0
+	}
+	dx = float64(px) + refine(at(px-1, py), best, at(px+1, py))
+	dy = float64(py) + refine(at(px, py-1), best, at(px, py+1))
+	if dx > float64(pw)/2 {
+		dx -= float64(pw)
+	}
+	if dy > float64(ph)/2 {
+		dy -= float64(ph)
+	}
+	ra, rb := ref.Bounds().Min, img.Bounds().Min
+	return dx + float64(rb.X-ra.X), dy + float64(rb.Y-ra.Y), nil
+}
+
+// Helper function to apply a Hann window to a plane and pad it with zeros to pw x ph,
+// the window hides the edges of the image, which would otherwise dominate the correlation
+func hannPadded(plane []float64, w, h, pw, ph int) []complex128 {
+	var mean float64
+	for _, v := range plane {
+		mean += v
+	}
+	mean /= float64(len(plane))
+	data := make([]complex128, pw*ph)
+	for y := 0; y < h; y++ {
+		wy := 0.5 - 0.5*math.Cos(2*math.Pi*float64(y)/float64(h-1))
+		for x := 0; x < w; x++ {
+			wx := 0.5 - 0.5*math.Cos(2*math.Pi*float64(x)/float64(w-1))
+			data[y*pw+x] = complex((plane[y*w+x]-mean)*wx*wy, 0)
+		}
+	}
+	return data
+}
+
+// Helper function to find the homography between two images from matched corners, outliers are
+// removed with RANSAC and the homography is refined with all inliers
+func featureHomography(ref, img image.Image) (homography, error) {
+	pa, da := cornerDescriptors(ref)
+	pb, db := cornerDescriptors(img)
+	var src, dst []point
+	for i, j := range matchDescriptors(da, db) {
+		if j >= 0 {
+			src, dst = append(src, pa[i]), append(dst, pb[j])
+		}
+	}
+	if len(src) < 4 {
+		return homography{}, fmt.Errorf("not enough matching features to align the images, found %d", len(src))
+	}
+
+	inliers := func(hm homography) []int {
+		var res []int
+		for i := range src {
+			x, y := hm.apply(src[i].x, src[i].y)
+			if math.Hypot(x-dst[i].x, y-dst[i].y) < ransacTolerance {
+				res = append(res, i)
+			}
+		}
+		return res
+	}
+	// a fixed seed makes the result reproducible
+	rng := rand.New(rand.NewSource(1))
+	var best []int
+	for it := 0; it < ransacIterations; it++ {
+		var s, d [4]point
+		idx := rng.Perm(len(src))[:4]
+		for k, i := range idx {
+			s[k], d[k] = src[i], dst[i]
+		}
+		hm, err := homographyFromPoints(s, d)
+		if err != nil {
+			continue
+		}
+		if in := inliers(hm); len(in) > len(best) {
+			best = in
+		}
+	}
+	if len(best) < 6 {
+		return homography{}, fmt.Errorf("not enough consistent features to align the images, found %d", len(best))
+	}
+	s, d := make([]point, len(best)), make([]point, len(best))
+	for k, i := range best {
+		s[k], d[k] = src[i], dst[i]
+	}
+	return fitHomography(s, d)
+}
+
+// Helper function to find corners and describe each by the normalized patch around it,
+// returns the corner positions (pixel centers in image coordinates) and the descriptors
+func cornerDescriptors(img image.Image) ([]point, [][]float64) {
+	corners, _ := harrisCorners(img, 0.04, 0.001)
+	gray, w, h := grayPlane(img)
+	gray = gaussianPlane(gray, w, h, 1)
+	b := img.Bounds()
+	var pts []point
+	var descs [][]float64
+	for _, c := range corners.items {
+		x, y := int(c.x)-b.Min.X, int(c.y)-b.Min.Y
+		if x < descriptorRadius || y < descriptorRadius || x >= w-descriptorRadius || y >= h-descriptorRadius {
+			continue
+		}
+		var d []float64
+		var mean float64
+		for j := -descriptorRadius; j <= descriptorRadius; j++ {
+			for i := -descriptorRadius; i <= descriptorRadius; i++ {
+				d = append(d, gray[(y+j)*w+x+i])
+				mean += gray[(y+j)*w+x+i]
+			}
+		}
+		mean /= float64(len(d))
+		var norm float64
+		for i := range d {
+			d[i] -= mean
+			norm += d[i] * d[i]
+		}
+		if norm < 1e-9 {
+			continue
+		}
+		norm = math.Sqrt(norm)
+		for i := range d {
+			d[i] /= norm
+		}
+		pts = append(pts, point{c.x + 0.5, c.y + 0.5})
+		descs = append(descs, d)
+	}
+	return pts, descs
+}
+
+// Helper function to match descriptors: for every descriptor in a the index of the matching one in b or -1.
+// A match must be mutual and clearly better than the second best candidate (Lowe's ratio test).
+func matchDescriptors(a, b [][]float64) []int {
+	dist := func(x, y []float64) float64 {
+		var dot float64
+		for i := range x {
+			dot += x[i] * y[i]
+		}
+		return math.Sqrt(max(2-2*dot, 0)) // both are unit vectors
+	}
+	bestB := make([]int, len(a))
+	bestA := make([]int, len(b))
+	bestADist := make([]float64, len(b))
+	for j := range b {
+		bestA[j], bestADist[j] = -1, math.Inf(1)
+	}
+	for i := range a {
+		bestB[i] = -1
+		d1, d2 := math.Inf(1), math.Inf(1)
+		for j := range b {
+			d := dist(a[i], b[j])
+			if d < d1 {
+				d1, d2, bestB[i] = d, d1, j
+			} else if d < d2 {
+				d2 = d
+			}
+			if d < bestADist[j] {
+				bestA[j], bestADist[j] = i, d
+			}
+		}
+		if d1 > 0.8*d2 {
+			bestB[i] = -1
+		}
+	}
+	for i, j := range bestB {
+		if j >= 0 && bestA[j] != i {
+			bestB[i] = -1
+		}
+	}
+	return bestB
+}
+
+// Helper function to fit a homography to point pairs with least squares, the points are centered
+// and scaled first, otherwise the system is badly conditioned for coordinates in the hundreds
+func fitHomography(src, dst []point) (homography, error) {
+	ns, ts := normalizePoints(src)
+	nd, td := normalizePoints(dst)
+	const n = 8
+	ata := make([]float64, n*n)
+	atb := make([]float64, n)
+	addRow := func(row [n]float64, b float64) {
+		for i := range row {
+			for j := range row {
+				ata[i*n+j] += row[i] * row[j]
+			}
+			atb[i] += row[i] * b
+		}
+	}
+	for i := range ns {
+		x, y, u, v := ns[i].x, ns[i].y, nd[i].x, nd[i].y
+		addRow([n]float64{x, y, 1, 0, 0, 0, -u * x, -u * y}, u)
+		addRow([n]float64{0, 0, 0, x, y, 1, -v * x, -v * y}, v)
+	}
+	var hn homography
+	copy(hn[:], solveCholesky(ata, atb, n))
+	hn[8] = 1
+	tdInv, err := td.inverse()
+	if err != nil {
+		return homography{}, err
+	}
+	hm := tdInv.mul(hn).mul(ts)
+	for _, v := range hm {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return homography{}, fmt.Errorf("points are degenerate")
+		}
+	}
+	return hm, nil
+}
+
+// Helper function to move the centroid of points to the origin and scale them to an average distance of sqrt(2),
+// returns the normalized points and the homography that normalizes them
+func normalizePoints(pts []point) ([]point, homography) {
+	var cx, cy float64
+	for _, p := range pts {
+		cx += p.x
+		cy += p.y
+	}
+	cx /= float64(len(pts))
+	cy /= float64(len(pts))
+	var d float64
+	for _, p := range pts {
+		d += math.Hypot(p.x-cx, p.y-cy)
+	}
+	s := 1.0
+	if d > 0 {
+		s = math.Sqrt2 * float64(len(pts)) / d
+	}
+	res := make([]point, len(pts))
+	for i, p := range pts {
+		res[i] = point{(p.x - cx) * s, (p.y - cy) * s}
+	}
+	return res, homography{s, 0, -s * cx, 0, s, -s * cy, 0, 0, 1}
+}
+
+// Helper function to get the distance of a point to the nearest border of a rectangle, negative outside
+func borderDistance(r image.Rectangle, x, y float64) float64 {
+	return min(x-float64(r.Min.X), float64(r.Max.X)-x, y-float64(r.Min.Y), float64(r.Max.Y)-y)
+}
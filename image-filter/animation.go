@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"sort"
+)
+
+var (
+	// @Name:  current-frame
+	// @Desc:  The frame that is currently processed by map-frames
+	// @Range: -
+	// @Unit:  -
+	currentFrame *image.NRGBA
+
+	// @Name:  gif-shared-palette
+	// @Desc:  Whether animated GIFs are saved with one palette shared by all frames instead of one palette per frame
+	// @Range: -
+	// @Unit:  -
+	gifSharedPalette = false
+)
+
+// animation is a sequence of fully composited frames, e.g. loaded from an animated GIF
+type animation struct {
+	frames    []*image.NRGBA
+	delays    []int  // delay of each frame in 100ths of a second
+	disposals []byte // disposal method of each frame as found in the source
+	loopCount int
+}
+
+// @Name: frames
+// @Desc: Returns all frames of a frame sequence
+// @Param:      anim    - -   -   The frame sequence
+// @Returns:    result  - -   -   The frames
+func frames(anim *animation) ([]*image.NRGBA, error) {
+	return anim.frames, nil
+}
+
+// @Name: frame
+// @Desc: Returns a single frame of a frame sequence
+// @Param:      anim    - -   	-   The frame sequence
+// @Param:      i       - 0..  	0   The index of the frame
+// @Returns:    result  - -   	-   The frame
+func frame(anim *animation, i int) (*image.NRGBA, error) {
+	if i < 0 || i >= len(anim.frames) {
+		return nil, fmt.Errorf("frame index %d out of range (0..%d)", i, len(anim.frames)-1)
+	}
+	return anim.frames[i], nil
+}
+
+// @Name: frame-count
+// @Desc: Returns the number of frames of a frame sequence
+// @Param:      anim    - -   -   The frame sequence
+// @Returns:    result  - -   0   The number of frames
+func frameCount(anim *animation) (int, error) {
+	return len(anim.frames), nil
+}
+
+// @Name: assemble
+// @Desc: Assembles images into a frame sequence
+// @Param:      frames  -  -   	-   The frames to assemble
+// @Param:      delay   ms 0..  100 The delay between frames
+// @Returns:    result  -  -   	-   The frame sequence
+func assemble(frames []*image.NRGBA, delay int) (*animation, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("cannot assemble a frame sequence without frames")
+	}
+	if delay < 0 {
+		return nil, fmt.Errorf("delay must not be negative")
+	}
+	anim := &animation{
+		frames:    make([]*image.NRGBA, len(frames)),
+		delays:    make([]int, len(frames)),
+		disposals: make([]byte, len(frames)),
+	}
+	bounds := frames[0].Bounds()
+	for i, f := range frames {
+		if !f.Bounds().Eq(bounds) {
+			return nil, fmt.Errorf("frame %d has bounds %v, expected %v", i, f.Bounds(), bounds)
+		}
+		anim.frames[i] = f
+		anim.delays[i] = delay / 10
+		anim.disposals[i] = gif.DisposalBackground
+	}
+	return anim, nil
+}
+
+// @Name: map-frames
+// @Desc: Evaluates an expression for every frame of a frame sequence, the frame is available as current-frame
+// @Param:      anim    - -   -   The frame sequence
+// @Param:      expr    - -   -   The expression to evaluate for each frame, e.g. "invert(current-frame)"
+// @Returns:    result  - -   -   The frame sequence with all frames replaced by the expression's results
+func mapFrames(anim *animation, expr string) (*animation, error) {
+	return anim.mapFrames(func(f *image.NRGBA) (*image.NRGBA, error) {
+		return evalImageExpr(expr, &currentFrame, f)
+	})
+}
+
+// Helper function to replace every frame by the result of fn, timing and looping are kept
+func (anim *animation) mapFrames(fn func(*image.NRGBA) (*image.NRGBA, error)) (*animation, error) {
+	mapped := &animation{
+		frames:    make([]*image.NRGBA, len(anim.frames)),
+		delays:    append([]int(nil), anim.delays...),
+		disposals: append([]byte(nil), anim.disposals...),
+		loopCount: anim.loopCount,
+	}
+	for i, f := range anim.frames {
+		img, err := fn(f)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+		mapped.frames[i] = img
+	}
+	return mapped, nil
+}
+
+// Helper function to evaluate a DSL expression with the given DSL variable set to the given image
+func evalImageExpr(expr string, variable **image.NRGBA, img *image.NRGBA) (*image.NRGBA, error) {
+	prev := *variable
+	*variable = img
+	defer func() { *variable = prev }()
+
+	r, err := dsl.run(expr, true)
+	if err != nil {
+		return nil, err
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	res, ok := r.value.(image.Image)
+	if !ok {
+		return nil, fmt.Errorf("expression must return an image, got %T", r.value)
+	}
+	return toNRGBA(res), nil
+}
+
+// Helper function to composite the frames of a decoded GIF onto full canvases,
+// honoring each frame's disposal method
+func animationFromGIF(g *gif.GIF) *animation {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() && len(g.Image) > 0 {
+		bounds = g.Image[0].Bounds()
+	}
+	anim := &animation{
+		frames:    make([]*image.NRGBA, len(g.Image)),
+		delays:    make([]int, len(g.Image)),
+		disposals: make([]byte, len(g.Image)),
+		loopCount: g.LoopCount,
+	}
+	canvas := image.NewNRGBA(bounds)
+	for i, p := range g.Image {
+		var previous *image.NRGBA
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, p.Bounds(), p, p.Bounds().Min, draw.Over)
+		f := image.NewNRGBA(bounds)
+		copy(f.Pix, canvas.Pix)
+		anim.frames[i] = f
+		if i < len(g.Delay) {
+			anim.delays[i] = g.Delay[i]
+		}
+		anim.disposals[i] = disposal
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, p.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return anim
+}
+
+// Helper function to convert the frame sequence into a GIF that can be encoded.
+// Frames are stored fully composited, hence every frame clears its area when
+// it is disposed of.
+func (anim *animation) toGIF(sharedPalette bool) *gif.GIF {
+	g := &gif.GIF{
+		Image:     make([]*image.Paletted, len(anim.frames)),
+		Delay:     make([]int, len(anim.frames)),
+		Disposal:  make([]byte, len(anim.frames)),
+		LoopCount: anim.loopCount,
+	}
+	var shared color.Palette
+	if sharedPalette {
+		shared = medianCutPalette(anim.frames, 256)
+	}
+	for i, f := range anim.frames {
+		pal := shared
+		if pal == nil {
+			pal = medianCutPalette([]*image.NRGBA{f}, 256)
+		}
+		p := image.NewPaletted(f.Bounds(), pal)
+		draw.Draw(p, p.Bounds(), f, f.Bounds().Min, draw.Src)
+		g.Image[i] = p
+		g.Delay[i] = anim.delays[i]
+		g.Disposal[i] = gif.DisposalBackground
+	}
+	return g
+}
+
+// colorBox is a box in RGB space used by the median cut quantizer
+type colorBox struct {
+	colors []color.NRGBA
+}
+
+// Helper function to get the channel with the widest range and that range
+func (b *colorBox) widestChannel() (channel int, width int) {
+	lo := [3]uint8{255, 255, 255}
+	hi := [3]uint8{}
+	for _, c := range b.colors {
+		v := [3]uint8{c.R, c.G, c.B}
+		for i := range v {
+			lo[i] = min(lo[i], v[i])
+			hi[i] = max(hi[i], v[i])
+		}
+	}
+	for i := range lo {
+		if w := int(hi[i]) - int(lo[i]); w > width {
+			channel, width = i, w
+		}
+	}
+	return channel, width
+}
+
+// Helper function to get the average color of the box
+func (b *colorBox) average() color.NRGBA {
+	var r, g, bl int
+	for _, c := range b.colors {
+		r += int(c.R)
+		g += int(c.G)
+		bl += int(c.B)
+	}
+	n := len(b.colors)
+	return color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: 255}
+}
+
+// Helper function to build a palette with at most n colors using median cut.
+// Pixels with less than 50% alpha are mapped to a transparent entry.
+func medianCutPalette(imgs []*image.NRGBA, n int) color.Palette {
+	var colors []color.NRGBA
+	transparent := false
+	for _, img := range imgs {
+		for i := 0; i < len(img.Pix); i += 4 {
+			if img.Pix[i+3] < 128 {
+				transparent = true
+				continue
+			}
+			colors = append(colors, color.NRGBA{R: img.Pix[i], G: img.Pix[i+1], B: img.Pix[i+2], A: 255})
+		}
+	}
+	if transparent {
+		n--
+	}
+
+	var pal color.Palette
+	if transparent {
+		pal = append(pal, color.NRGBA{})
+	}
+	if len(colors) == 0 {
+		if len(pal) == 0 {
+			pal = append(pal, color.NRGBA{A: 255})
+		}
+		return pal
+	}
+
+	boxes := []*colorBox{{colors: colors}}
+	for len(boxes) < n {
+		// split the box with the widest channel range
+		best, bestChannel, bestWidth := -1, 0, 0
+		for i, b := range boxes {
+			if len(b.colors) < 2 {
+				continue
+			}
+			if ch, w := b.widestChannel(); w > bestWidth {
+				best, bestChannel, bestWidth = i, ch, w
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b := boxes[best]
+		sort.Slice(b.colors, func(i, j int) bool {
+			ci, cj := b.colors[i], b.colors[j]
+			switch bestChannel {
+			case 0:
+				return ci.R < cj.R
+			case 1:
+				return ci.G < cj.G
+			default:
+				return ci.B < cj.B
+			}
+		})
+		mid := len(b.colors) / 2
+		boxes[best] = &colorBox{colors: b.colors[:mid]}
+		boxes = append(boxes, &colorBox{colors: b.colors[mid:]})
+	}
+	for _, b := range boxes {
+		pal = append(pal, b.average())
+	}
+	return pal
+}
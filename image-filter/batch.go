@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// @Name:  batch-workers
+	// @Desc:  Maximum number of files batch processes concurrently
+	// @Range: 1..
+	// @Unit:  -
+	batchWorkers = runtime.NumCPU()
+)
+
+// batchImageName is the name expressions of batch use for the image of the file being processed
+const batchImageName = "current-image"
+
+// batchEvals counts the evaluations of batch, so every evaluation keeps its image under its own name
+var batchEvals atomic.Int64
+
+// batchEvalMu serializes the evaluations of batch workers, the DSL's variables such as current-frame
+// are shared and the generated dsl.run is not known to be safe for concurrent use
+var batchEvalMu sync.Mutex
+
+// batchError is the error that occurred while processing a single file
+type batchError struct {
+	path string
+	err  error
+}
+
+// batchSummary is the outcome of a batch run
+type batchSummary struct {
+	total     int
+	succeeded int
+	errors    []batchError
+	duration  time.Duration
+}
+
+func (s *batchSummary) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Processed %d files in %s: %d succeeded, %d failed", s.total, s.duration.Round(time.Millisecond), s.succeeded, len(s.errors))
+	for _, e := range s.errors {
+		fmt.Fprintf(&sb, "\n  %s: %v", e.path, e.err)
+	}
+	return sb.String()
+}
+
+// @Name: batch
+// @Desc: Evaluates an expression for every file matching a glob and saves the results, the input image is available as current-image. Animated GIFs are processed frame by frame and saved as GIF.
+// @Param:      glob    	- -   -   Glob pattern of the files to process, e.g. "photos/*.png"
+// @Param:      out     	- -   -   Output path template, {name}, {ext} and {index} are replaced per file, e.g. "out/{name}-{index}.{ext}"
+// @Param:      expr    	- -   -   The expression to evaluate for each file, e.g. "sepia(current-image)"
+// @Returns:    result  	- -   -   Summary of the batch run including per-file errors
+func batch(glob string, out string, expr string) (string, error) {
+	summary, err := runBatch(glob, out, expr, batchWorkers)
+	if err != nil {
+		return "", err
+	}
+	return summary.String(), nil
+}
+
+// Helper function to evaluate an expression for all files matching the glob with a bounded number of workers
+func runBatch(glob, out, expr string, workers int) (*batchSummary, error) {
+	return batchFiles(glob, out, workers, func(img *image.NRGBA) (*image.NRGBA, error) {
+		return evalBatchImage(expr, img)
+	})
+}
+
+// Helper function to process all files matching the glob with a bounded number of workers.
+// Loading and saving run concurrently, eval is called for every image or frame.
+func batchFiles(glob, out string, workers int, eval func(*image.NRGBA) (*image.NRGBA, error)) (*batchSummary, error) {
+	paths, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files match %q", glob)
+	}
+	sort.Strings(paths)
+	workers = max(1, min(workers, len(paths)))
+
+	start := time.Now()
+	summary := &batchSummary{total: len(paths)}
+	errs := make([]error, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = processBatchFile(paths[i], batchOutputPath(out, paths[i], i), eval)
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			summary.errors = append(summary.errors, batchError{path: paths[i], err: err})
+			continue
+		}
+		summary.succeeded++
+	}
+	summary.duration = time.Since(start)
+	return summary, nil
+}
+
+// Helper function to load, process and save a single file of a batch run
+func processBatchFile(path, out string, eval func(*image.NRGBA) (*image.NRGBA, error)) error {
+	src, err := load(path)
+	if err != nil {
+		return err
+	}
+	var res any
+	switch v := src.(type) {
+	case *animation:
+		res, err = v.mapFrames(eval)
+	case image.Image:
+		res, err = eval(toNRGBA(v))
+	default:
+		return fmt.Errorf("cannot process %T", src)
+	}
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(out); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	_, err = save(res, out)
+	return err
+}
+
+// Helper function to evaluate the expression of batch for a single image. The image is not passed
+// through a shared DSL variable: it is stored under a name of its own and current-image in the
+// expression is replaced by recalling that name. Only one worker evaluates at a time.
+func evalBatchImage(expr string, img *image.NRGBA) (*image.NRGBA, error) {
+	name := fmt.Sprintf("batch:%d", batchEvals.Add(1))
+	storedImagesMu.Lock()
+	storedImages[name] = img
+	storedImagesMu.Unlock()
+	defer func() {
+		storedImagesMu.Lock()
+		delete(storedImages, name)
+		storedImagesMu.Unlock()
+	}()
+
+	batchEvalMu.Lock()
+	r, err := dsl.run(bindBatchImage(expr, fmt.Sprintf("recall(%q)", name)), true)
+	batchEvalMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	res, ok := r.value.(image.Image)
+	if !ok {
+		return nil, fmt.Errorf("expression must return an image, got %T", r.value)
+	}
+	return toNRGBA(res), nil
+}
+
+// Helper function to replace every current-image in an expression by ref,
+// names that merely contain it and quoted strings are left alone
+func bindBatchImage(expr, ref string) string {
+	var sb strings.Builder
+	quoted := false
+	for i := 0; i < len(expr); {
+		switch {
+		case expr[i] == '"' && (i == 0 || expr[i-1] != '\\'):
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(expr[i:], batchImageName) &&
+			(i == 0 || !isNameByte(expr[i-1])) &&
+			(i+len(batchImageName) == len(expr) || !isNameByte(expr[i+len(batchImageName)])):
+			sb.WriteString(ref)
+			i += len(batchImageName)
+			continue
+		}
+		sb.WriteByte(expr[i])
+		i++
+	}
+	return sb.String()
+}
+
+// Helper function to check whether a byte can be part of a DSL name
+func isNameByte(b byte) bool {
+	return b == '-' || b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// Helper function to substitute {name}, {ext} and {index} in an output path template
+func batchOutputPath(tmpl, path string, index int) string {
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	return strings.NewReplacer(
+		"{name}", strings.TrimSuffix(base, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{index}", strconv.Itoa(index),
+	).Replace(tmpl)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+// TestBatchWorkers processes still images and an animated GIF with several workers,
+// every output must be the inverted input of the same file, run it with -race
+func TestBatchWorkers(t *testing.T) {
+	dir := t.TempDir()
+	in := newTestInputs(24, 16)
+	inputs := map[string][]*image.NRGBA{}
+	for i := range 8 {
+		img := must(brightness(in.gradient, float64(i)/4)).(*image.NRGBA)
+		name := fmt.Sprintf("in-%d.png", i)
+		if _, err := save(img, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+		inputs[name] = []*image.NRGBA{img}
+	}
+	anim := must(assemble([]*image.NRGBA{in.shapes, in.binary}, 10))
+	if _, err := save(anim, filepath.Join(dir, "in-anim.gif")); err != nil {
+		t.Fatal(err)
+	}
+	inputs["in-anim.gif"] = must(load(filepath.Join(dir, "in-anim.gif"))).(*animation).frames
+
+	summary, err := batchFiles(filepath.Join(dir, "in-*"), filepath.Join(dir, "out", "{name}.{ext}"), 4, func(img *image.NRGBA) (*image.NRGBA, error) {
+		return toNRGBA(must(invert(img)).(image.Image)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.succeeded != len(inputs) || len(summary.errors) > 0 {
+		t.Fatalf("got %s, want %d succeeded files", summary, len(inputs))
+	}
+
+	for name, frames := range inputs {
+		res := must(load(filepath.Join(dir, "out", name)))
+		got := []*image.NRGBA{}
+		switch v := res.(type) {
+		case *animation:
+			got = v.frames
+		case image.Image:
+			got = append(got, toNRGBA(v))
+		}
+		if len(got) != len(frames) {
+			t.Fatalf("%s: got %d frames, want %d", name, len(got), len(frames))
+		}
+		for i, f := range frames {
+			want := toNRGBA(must(invert(f)).(image.Image))
+			// GIF frames are quantized to a palette again when saved
+			if d := maxChannelDiff(got[i], want); d > 8 && filepath.Ext(name) == ".png" || d > 64 {
+				t.Errorf("%s frame %d differs from the inverted input by %d", name, i, d)
+			}
+		}
+	}
+}
+
+func TestBindBatchImage(t *testing.T) {
+	for _, c := range []struct{ expr, want string }{
+		{"sepia(current-image)", "sepia(R)"},
+		{"blend-multiply(current-image current-image)", "blend-multiply(R R)"},
+		{"invert(current-image-2)", "invert(current-image-2)"},
+		{"invert(my-current-image)", "invert(my-current-image)"},
+		{`draw-text(current-image "current-image" 0 0 12 c "left")`, `draw-text(R "current-image" 0 0 12 c "left")`},
+		{`save(current-image "a\"current-image")`, `save(R "a\"current-image")`},
+	} {
+		if got := bindBatchImage(c.expr, "R"); got != c.want {
+			t.Errorf("bindBatchImage(%q) = %q, want %q", c.expr, got, c.want)
+		}
+	}
+}
+
+// Helper function to get the largest difference of a channel between two images of the same size
+func maxChannelDiff(a, b *image.NRGBA) int {
+	d := 0
+	for i := range a.Pix {
+		d = max(d, int(a.Pix[i])-int(b.Pix[i]), int(b.Pix[i])-int(a.Pix[i]))
+	}
+	return d
+}
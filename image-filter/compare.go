@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/toxyl/math"
+)
+
+// comparison holds the metrics computed by compare
+type comparison struct {
+	MSE         float64 // mean squared error over all channels (0..255 scale)
+	PSNR        float64 // peak signal-to-noise ratio in dB, +Inf for identical images
+	SSIM        float64 // structural similarity of the luminance, 1 for identical images
+	DeltaEMean  float64 // mean CIEDE2000 color difference
+	DeltaEMax   float64 // maximum CIEDE2000 color difference
+	DiffPixels  int     // number of pixels that differ in any channel
+	TotalPixels int
+}
+
+func (c comparison) String() string {
+	return fmt.Sprintf("MSE: %.4f, PSNR: %.2f dB, SSIM: %.4f, ΔE00 mean: %.4f, ΔE00 max: %.4f, differing pixels: %d/%d",
+		c.MSE, c.PSNR, c.SSIM, c.DeltaEMean, c.DeltaEMax, c.DiffPixels, c.TotalPixels)
+}
+
+// @Name: compare
+// @Desc: Compares two images of the same size
+// @Param:      imgA    - -   -   The first image
+// @Param:      imgB    - -   -   The second image
+// @Returns:    result  - -   -   MSE, PSNR, SSIM and the mean/max CIEDE2000 color difference
+func compare(imgA *image.NRGBA, imgB *image.NRGBA) (comparison, error) {
+	if err := checkSameSize(imgA, imgB); err != nil {
+		return comparison{}, err
+	}
+	return compareImages(imgA, imgB), nil
+}
+
+// @Name: diff-image
+// @Desc: Creates a heatmap of the pixels that differ between two images
+// @Param:      imgA     	 - -   	-   The first image
+// @Param:      imgB     	 - -   	-   The second image
+// @Param:      amplification - 1..  	1   Factor the differences are multiplied with to make small differences visible
+// @Returns:    result  	 - -   	-   The heatmap, black where the images are identical
+func diffImage(imgA *image.NRGBA, imgB *image.NRGBA, amplification float64) (*image.NRGBA, error) {
+	if err := checkSameSize(imgA, imgB); err != nil {
+		return nil, err
+	}
+	if amplification <= 0 {
+		return nil, fmt.Errorf("amplification must be greater than 0")
+	}
+
+	boundsA := imgA.Bounds()
+	boundsB := imgB.Bounds()
+	result := image.NewNRGBA(boundsA)
+
+	for y := 0; y < boundsA.Dy(); y++ {
+		for x := 0; x < boundsA.Dx(); x++ {
+			c1 := imgA.NRGBAAt(boundsA.Min.X+x, boundsA.Min.Y+y)
+			c2 := imgB.NRGBAAt(boundsB.Min.X+x, boundsB.Min.Y+y)
+
+			d := math.Max(
+				math.Max(absDiff(c1.R, c2.R), absDiff(c1.G, c2.G)),
+				math.Max(absDiff(c1.B, c2.B), absDiff(c1.A, c2.A)),
+			) / 255.0
+
+			result.SetNRGBA(boundsA.Min.X+x, boundsA.Min.Y+y, heatmapColor(math.Min(d*amplification, 1)))
+		}
+	}
+	return result, nil
+}
+
+// Helper function to ensure two images can be compared pixel by pixel
+func checkSameSize(imgA, imgB image.Image) error {
+	if imgA.Bounds().Size() != imgB.Bounds().Size() {
+		return fmt.Errorf("images differ in size: %v vs %v", imgA.Bounds().Size(), imgB.Bounds().Size())
+	}
+	return nil
+}
+
+// Helper function to compute all comparison metrics of two images of the same size
+func compareImages(imgA, imgB *image.NRGBA) comparison {
+	boundsA := imgA.Bounds()
+	boundsB := imgB.Bounds()
+	w, h := boundsA.Dx(), boundsA.Dy()
+	res := comparison{TotalPixels: w * h}
+	if res.TotalPixels == 0 {
+		return res
+	}
+
+	lumA := make([]float64, w*h)
+	lumB := make([]float64, w*h)
+	var sqErr, deltaE float64
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c1 := imgA.NRGBAAt(boundsA.Min.X+x, boundsA.Min.Y+y)
+			c2 := imgB.NRGBAAt(boundsB.Min.X+x, boundsB.Min.Y+y)
+
+			if c1 != c2 {
+				res.DiffPixels++
+			}
+			for _, d := range []float64{absDiff(c1.R, c2.R), absDiff(c1.G, c2.G), absDiff(c1.B, c2.B), absDiff(c1.A, c2.A)} {
+				sqErr += d * d
+			}
+
+			l1, a1, b1 := rgbToLab(c1)
+			l2, a2, b2 := rgbToLab(c2)
+			de := ciede2000(l1, a1, b1, l2, a2, b2)
+			deltaE += de
+			res.DeltaEMax = math.Max(res.DeltaEMax, de)
+
+			lumA[y*w+x] = luminance(c1)
+			lumB[y*w+x] = luminance(c2)
+		}
+	}
+
+	res.MSE = sqErr / float64(res.TotalPixels*4)
+	res.PSNR = math.Inf(1)
+	if res.MSE > 0 {
+		res.PSNR = 10 * math.Log10(255*255/res.MSE)
+	}
+	res.DeltaEMean = deltaE / float64(res.TotalPixels)
+	res.SSIM = ssim(lumA, lumB, w, h)
+	return res
+}
+
+// Helper function to get the absolute difference of two channel values
+func absDiff(a, b uint8) float64 {
+	return math.Abs(float64(a) - float64(b))
+}
+
+// Helper function to get the luminance of a color on a 0..255 scale
+func luminance(c color.NRGBA) float64 {
+	return 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+}
+
+// Helper function to compute the mean SSIM of two luminance planes using 8x8 sliding windows
+func ssim(a, b []float64, w, h int) float64 {
+	const (
+		win = 8
+		c1  = (0.01 * 255) * (0.01 * 255)
+		c2  = (0.03 * 255) * (0.03 * 255)
+	)
+	ws := min(win, w, h)
+
+	// summed-area tables make every window O(1)
+	stride := w + 1
+	sa := make([]float64, stride*(h+1))
+	sb := make([]float64, stride*(h+1))
+	saa := make([]float64, stride*(h+1))
+	sbb := make([]float64, stride*(h+1))
+	sab := make([]float64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			va, vb := a[y*w+x], b[y*w+x]
+			i := (y+1)*stride + x + 1
+			up, left, diag := i-stride, i-1, i-stride-1
+			sa[i] = va + sa[up] + sa[left] - sa[diag]
+			sb[i] = vb + sb[up] + sb[left] - sb[diag]
+			saa[i] = va*va + saa[up] + saa[left] - saa[diag]
+			sbb[i] = vb*vb + sbb[up] + sbb[left] - sbb[diag]
+			sab[i] = va*vb + sab[up] + sab[left] - sab[diag]
+		}
+	}
+	sum := func(t []float64, x, y int) float64 {
+		x1, y1 := x+ws, y+ws
+		return t[y1*stride+x1] - t[y*stride+x1] - t[y1*stride+x] + t[y*stride+x]
+	}
+
+	n := float64(ws * ws)
+	var total float64
+	var count int
+	for y := 0; y+ws <= h; y++ {
+		for x := 0; x+ws <= w; x++ {
+			muA := sum(sa, x, y) / n
+			muB := sum(sb, x, y) / n
+			varA := sum(saa, x, y)/n - muA*muA
+			varB := sum(sbb, x, y)/n - muB*muB
+			cov := sum(sab, x, y)/n - muA*muB
+			total += ((2*muA*muB + c1) * (2*cov + c2)) / ((muA*muA + muB*muB + c1) * (varA + varB + c2))
+			count++
+		}
+	}
+	return total / float64(count)
+}
+
+// Helper function to convert an sRGB color to CIE L*a*b* (D65)
+func rgbToLab(c color.NRGBA) (l, a, b float64) {
+	linear := func(v uint8) float64 {
+		return srgbToLinear(float64(v) / 255.0)
+	}
+	r, g, bl := linear(c.R), linear(c.G), linear(c.B)
+
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*bl) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*bl
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*bl) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389.0 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27.0*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// Helper function to compute the CIEDE2000 color difference of two L*a*b* colors
+func ciede2000(l1, a1, b1, l2, a2, b2 float64) float64 {
+	const (
+		deg      = math.Pi / 180
+		pow25to7 = 6103515625.0 // 25^7
+	)
+
+	c1 := math.Hypot(a1, b1)
+	c2 := math.Hypot(a2, b2)
+	cMean := (c1 + c2) / 2
+	g := 0.5 * (1 - math.Sqrt(math.Pow(cMean, 7)/(math.Pow(cMean, 7)+pow25to7)))
+
+	a1p := (1 + g) * a1
+	a2p := (1 + g) * a2
+	c1p := math.Hypot(a1p, b1)
+	c2p := math.Hypot(a2p, b2)
+
+	hue := func(b, ap float64) float64 {
+		if b == 0 && ap == 0 {
+			return 0
+		}
+		h := math.Atan2(b, ap) / deg
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+	h1p := hue(b1, a1p)
+	h2p := hue(b2, a2p)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+	var dhp float64
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(dhp/2*deg)
+
+	lpMean := (l1 + l2) / 2
+	cpMean := (c1p + c2p) / 2
+	hpMean := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) > 180 {
+			if hpMean < 360 {
+				hpMean += 360
+			} else {
+				hpMean -= 360
+			}
+		}
+		hpMean /= 2
+	}
+
+	t := 1 - 0.17*math.Cos((hpMean-30)*deg) + 0.24*math.Cos(2*hpMean*deg) +
+		0.32*math.Cos((3*hpMean+6)*deg) - 0.20*math.Cos((4*hpMean-63)*deg)
+	dTheta := 30 * math.Exp(-math.Pow((hpMean-275)/25, 2))
+	rc := 2 * math.Sqrt(math.Pow(cpMean, 7)/(math.Pow(cpMean, 7)+pow25to7))
+	sl := 1 + (0.015*math.Pow(lpMean-50, 2))/math.Sqrt(20+math.Pow(lpMean-50, 2))
+	sc := 1 + 0.045*cpMean
+	sh := 1 + 0.015*cpMean*t
+	rt := -math.Sin(2*dTheta*deg) * rc
+
+	dl := dLp / sl
+	dc := dCp / sc
+	dh := dHp / sh
+	return math.Sqrt(dl*dl + dc*dc + dh*dh + rt*dc*dh)
+}
+
+// Helper function to map a value in 0..1 to a black-red-yellow-white heatmap color
+func heatmapColor(t float64) color.NRGBA {
+	r := math.Min(t*3, 1)
+	g := math.Min(math.Max(t*3-1, 0), 1)
+	b := math.Min(math.Max(t*3-2, 0), 1)
+	return color.NRGBA{R: uint8(r * 255), G: uint8(g * 255), B: uint8(b * 255), A: 255}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+
+	"github.com/toxyl/math"
+)
+
+// region holds the statistics of a connected component
+type region struct {
+	label  int
+	area   int
+	bounds image.Rectangle
+	cx, cy float64 // the centroid
+}
+
+// labelMap assigns every pixel the label of its connected component, 0 is the background
+type labelMap struct {
+	rect    image.Rectangle
+	labels  []int32 // row-major
+	regions []region
+}
+
+func (m *labelMap) String() string {
+	type regionStats struct {
+		Label int     `json:"label"`
+		Area  int     `json:"area"`
+		X     int     `json:"x"`
+		Y     int     `json:"y"`
+		W     int     `json:"w"`
+		H     int     `json:"h"`
+		CX    float64 `json:"cx"`
+		CY    float64 `json:"cy"`
+	}
+	stats := make([]regionStats, len(m.regions))
+	for i, r := range m.regions {
+		stats[i] = regionStats{
+			Label: r.label, Area: r.area,
+			X: r.bounds.Min.X, Y: r.bounds.Min.Y, W: r.bounds.Dx(), H: r.bounds.Dy(),
+			CX: math.Round(r.cx*100) / 100, CY: math.Round(r.cy*100) / 100,
+		}
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// contour is the outline of a region or of a hole in it, along the pixel edges
+type contour struct {
+	label  int
+	hole   bool
+	points []point // the corners of the outline, clockwise for regions and counterclockwise for holes
+}
+
+// contourList is the result of find-contours
+type contourList struct {
+	items []contour
+}
+
+func (c *contourList) String() string {
+	type outline struct {
+		Label  int          `json:"label"`
+		Hole   bool         `json:"hole"`
+		Area   float64      `json:"area"`
+		Points [][2]float64 `json:"points"`
+	}
+	outlines := make([]outline, len(c.items))
+	for i, ct := range c.items {
+		pts := make([][2]float64, len(ct.points))
+		for j, p := range ct.points {
+			pts[j] = [2]float64{p.x, p.y}
+		}
+		outlines[i] = outline{Label: ct.label, Hole: ct.hole, Area: math.Abs(polygonArea(ct.points)), Points: pts}
+	}
+	data, err := json.Marshal(outlines)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// @Name: threshold
+// @Desc: Converts an image to a binary mask, pixels at least as bright as the level become white, all others black
+// @Param:      img     - 	-   		-   	The image to convert
+// @Param:      level   "%" 0.0..1.0   	0.5   	The luminance threshold
+// @Returns:    result  - 	-   		-   	The binary mask
+func threshold(img image.Image, level float64) (*image.NRGBA, error) {
+	if level < 0 || level > 1 {
+		return nil, fmt.Errorf("level must be between 0 and 1")
+	}
+	src := toNRGBA(img)
+	b := src.Bounds()
+	values := make([]float64, 0, b.Dx()*b.Dy())
+	forEachNRGBA(src, func(c color.NRGBA) {
+		if luminance(c)*float64(c.A)/255 >= level*255 {
+			values = append(values, 1)
+		} else {
+			values = append(values, 0)
+		}
+	})
+	return maskImage(b, values), nil
+}
+
+// @Name: label-components
+// @Desc: Labels the connected regions of white pixels in a binary image, e.g. to count objects, returns the area, bounding box and centroid of each region as JSON
+// @Param:      img             - 	-   	-   	The binary image, pixels brighter than 50% are foreground
+// @Param:      connectivity    - 	4|8   	8   	Whether diagonal neighbors (8) are connected or only horizontal and vertical ones (4)
+// @Returns:    result          - 	-   	-   	The label map
+func labelComponents(img image.Image, connectivity int) (*labelMap, error) {
+	if connectivity != 4 && connectivity != 8 {
+		return nil, fmt.Errorf("connectivity must be 4 or 8")
+	}
+	fg := foregroundPixels(img)
+	b := img.Bounds()
+	labels, n := labelPixels(fg, b.Dx(), b.Dy(), connectivity)
+	m := &labelMap{rect: b, labels: labels, regions: make([]region, n)}
+	for i := range m.regions {
+		m.regions[i].label = i + 1
+	}
+	w := b.Dx()
+	for i, l := range labels {
+		if l == 0 {
+			continue
+		}
+		r := &m.regions[l-1]
+		x, y := i%w+b.Min.X, i/w+b.Min.Y
+		px := image.Rect(x, y, x+1, y+1)
+		if r.area == 0 {
+			r.bounds = px
+		} else {
+			r.bounds = r.bounds.Union(px)
+		}
+		r.area++
+		r.cx += float64(x)
+		r.cy += float64(y)
+	}
+	for i := range m.regions {
+		r := &m.regions[i]
+		r.cx /= float64(r.area)
+		r.cy /= float64(r.area)
+	}
+	return m, nil
+}
+
+// @Name: component-count
+// @Desc: Returns the number of labeled regions
+// @Param:      labels  - 	-   	-   The label map
+// @Returns:    result  - 	-   	-   The number of regions
+func componentCount(labels *labelMap) (int, error) {
+	return len(labels.regions), nil
+}
+
+// @Name: label-image
+// @Desc: Shows a label map as image, each region gets its own color and the background is black
+// @Param:      labels  - 	-   	-   The label map
+// @Returns:    result  - 	-   	-   The colored regions
+func labelImage(labels *labelMap) (*image.NRGBA, error) {
+	img := image.NewNRGBA(labels.rect)
+	colors := make([]color.NRGBA, len(labels.regions)+1)
+	colors[0] = color.NRGBA{A: 255}
+	for i := 1; i < len(colors); i++ {
+		// the golden angle spreads the hues of neighboring labels
+		r, g, b := hslToRGB(math.Mod(float64(i)*137.508, 360)/360, 0.7, 0.55)
+		colors[i] = color.NRGBA{R: uint8(math.Round(r * 255)), G: uint8(math.Round(g * 255)), B: uint8(math.Round(b * 255)), A: 255}
+	}
+	w := labels.rect.Dx()
+	for i, l := range labels.labels {
+		img.SetNRGBA(labels.rect.Min.X+i%w, labels.rect.Min.Y+i/w, colors[l])
+	}
+	return img, nil
+}
+
+// @Name: component-mask
+// @Desc: Returns the mask of a single region, e.g. to cut it out with apply-mask
+// @Param:      labels  - 	-   	-   The label map
+// @Param:      label   - 	1..   	1   The label of the region
+// @Returns:    result  - 	-   	-   The mask, white inside the region
+func componentMask(labels *labelMap, label int) (*image.NRGBA, error) {
+	if label < 1 || label > len(labels.regions) {
+		return nil, fmt.Errorf("label must be between 1 and %d", len(labels.regions))
+	}
+	values := make([]float64, len(labels.labels))
+	for i, l := range labels.labels {
+		if int(l) == label {
+			values[i] = 1
+		}
+	}
+	return maskImage(labels.rect, values), nil
+}
+
+// @Name: find-contours
+// @Desc: Finds the outlines of the regions of white pixels and of the holes in them, returns them as JSON polygons along the pixel edges, e.g. [{"label":1,"hole":false,"area":12,"points":[[2,3],[6,3],[6,6],[2,6]]}, ...]
+// @Param:      img             - 	-   	-   	The binary image, pixels brighter than 50% are foreground
+// @Param:      connectivity    - 	4|8   	8   	Whether diagonal neighbors (8) are connected or only horizontal and vertical ones (4)
+// @Returns:    result          - 	-   	-   	The outlines
+func findContours(img image.Image, connectivity int) (*contourList, error) {
+	m, err := labelComponents(img, connectivity)
+	if err != nil {
+		return nil, err
+	}
+	res := &contourList{}
+	for _, r := range m.regions {
+		res.items = append(res.items, traceRegion(m, r, connectivity == 8)...)
+	}
+	return res, nil
+}
+
+// @Name: draw-contours
+// @Desc: Draws outlines found by find-contours onto a copy of the image
+// @Param:      img       - 	-   	-   The image to draw on
+// @Param:      contours  - 	-   	-   The outlines
+// @Param:      width     px 	0..   	1   The stroke width
+// @Param:      col       - 	-   	-   The stroke color
+// @Returns:    result    - 	-   	-   The image with the outlines drawn
+func drawContours(img *image.NRGBA, contours *contourList, width float64, col color.RGBA64) (*image.NRGBA, error) {
+	paths := make([]subpath, len(contours.items))
+	for i, c := range contours.items {
+		paths[i] = subpath{points: c.points, closed: true}
+	}
+	return drawShape(img, paths, width, col, color.RGBA64{})
+}
+
+// @Name: fill-holes
+// @Desc: Fills the holes in the regions of white pixels of a binary image, i.e. all black areas that don't touch the image border
+// @Param:      img             - 	-   	-   	The binary image, pixels brighter than 50% are foreground
+// @Param:      connectivity    - 	4|8   	8   	The connectivity of the regions, holes use the other one so they can't leak through diagonal gaps
+// @Returns:    result          - 	-   	-   	The binary mask with filled holes
+func fillHoles(img image.Image, connectivity int) (*image.NRGBA, error) {
+	if connectivity != 4 && connectivity != 8 {
+		return nil, fmt.Errorf("connectivity must be 4 or 8")
+	}
+	fg := foregroundPixels(img)
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	bg := make([]bool, len(fg))
+	for i, v := range fg {
+		bg[i] = !v
+	}
+	labels, n := labelPixels(bg, w, h, 12-connectivity)
+	touchesBorder := make([]bool, n+1)
+	for i, l := range labels {
+		if x, y := i%w, i/w; x == 0 || y == 0 || x == w-1 || y == h-1 {
+			touchesBorder[l] = true
+		}
+	}
+	values := make([]float64, len(fg))
+	for i, l := range labels {
+		if fg[i] || !touchesBorder[l] {
+			values[i] = 1
+		}
+	}
+	return maskImage(b, values), nil
+}
+
+// Helper function to get the foreground pixels of a binary image: opaque pixels brighter than 50%
+func foregroundPixels(img image.Image) []bool {
+	src := toNRGBA(img)
+	b := src.Bounds()
+	fg := make([]bool, 0, b.Dx()*b.Dy())
+	forEachNRGBA(src, func(c color.NRGBA) {
+		fg = append(fg, c.A >= 128 && luminance(c) >= 127.5)
+	})
+	return fg
+}
+
+// Helper function to label the connected regions of set pixels with union-find,
+// labels start at 1 and are numbered in the order their first pixel appears row by row
+func labelPixels(set []bool, w, h, connectivity int) ([]int32, int) {
+	parent := make([]int32, len(set))
+	var find func(i int32) int32
+	find = func(i int32) int32 {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	union := func(a, b int32) {
+		ra, rb := find(a), find(b)
+		if ra < rb {
+			parent[rb] = ra
+		} else if rb < ra {
+			parent[ra] = rb
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := int32(y*w + x)
+			parent[i] = i
+			if !set[i] {
+				continue
+			}
+			// only neighbors that were already visited need to be joined
+			if x > 0 && set[i-1] {
+				union(i, i-1)
+			}
+			if y > 0 && set[i-int32(w)] {
+				union(i, i-int32(w))
+			}
+			if connectivity == 8 && y > 0 {
+				if x > 0 && set[i-int32(w)-1] {
+					union(i, i-int32(w)-1)
+				}
+				if x < w-1 && set[i-int32(w)+1] {
+					union(i, i-int32(w)+1)
+				}
+			}
+		}
+	}
+	labels := make([]int32, len(set))
+	ids := map[int32]int32{}
+	for i := range set {
+		if !set[i] {
+			continue
+		}
+		root := find(int32(i))
+		id, ok := ids[root]
+		if !ok {
+			id = int32(len(ids) + 1)
+			ids[root] = id
+		}
+		labels[i] = id
+	}
+	return labels, len(ids)
+}
+
+// Helper function to trace the outlines of a region along the pixel edges. Every edge between a pixel of the
+// region and another pixel is directed so the region is on its right, then the edges are linked into loops.
+// Where two pixels of the region only touch diagonally, the loop turns left to join them if joinDiagonal is set.
+func traceRegion(m *labelMap, r region, joinDiagonal bool) []contour {
+	const (
+		east = 1 << iota
+		south
+		west
+		north
+	)
+	w := m.rect.Dx()
+	b := r.bounds.Sub(m.rect.Min)
+	gw := b.Dx() + 1 // vertices are the pixel corners of the bounding box
+	out := make([]uint8, gw*(b.Dy()+1))
+	in := func(x, y int) bool {
+		return x >= b.Min.X && y >= b.Min.Y && x < b.Max.X && y < b.Max.Y && int(m.labels[y*w+x]) == r.label
+	}
+	vertex := func(x, y int) int { return (y-b.Min.Y)*gw + x - b.Min.X }
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !in(x, y) {
+				continue
+			}
+			if !in(x, y-1) {
+				out[vertex(x, y)] |= east
+			}
+			if !in(x+1, y) {
+				out[vertex(x+1, y)] |= south
+			}
+			if !in(x, y+1) {
+				out[vertex(x+1, y+1)] |= west
+			}
+			if !in(x-1, y) {
+				out[vertex(x, y+1)] |= north
+			}
+		}
+	}
+
+	steps := map[uint8]image.Point{east: {1, 0}, south: {0, 1}, west: {-1, 0}, north: {0, -1}}
+	right := map[uint8]uint8{east: south, south: west, west: north, north: east}
+	left := map[uint8]uint8{east: north, north: west, west: south, south: east}
+	var res []contour
+	for v := range out {
+		// loops start at a vertex with a single outgoing edge, every loop has one at its top-left corner
+		for bits.OnesCount8(out[v]) == 1 {
+			x, y := v%gw+b.Min.X, v/gw+b.Min.Y
+			dir := out[v]
+			var pts []point
+			for {
+				cur := vertex(x, y)
+				if bits.OnesCount8(out[cur]) == 2 {
+					// two pixels of the region touch diagonally at this vertex
+					if joinDiagonal {
+						dir = left[dir]
+					} else {
+						dir = right[dir]
+					}
+				} else {
+					dir = out[cur]
+				}
+				pts = append(pts, point{float64(x), float64(y)})
+				out[cur] &^= dir
+				x, y = x+steps[dir].X, y+steps[dir].Y
+				if vertex(x, y) == v {
+					break
+				}
+			}
+			pts = simplifyOutline(pts)
+			for i := range pts {
+				pts[i].x += float64(m.rect.Min.X)
+				pts[i].y += float64(m.rect.Min.Y)
+			}
+			// in image coordinates (y down) loops around a region have a positive area
+			res = append(res, contour{label: r.label, hole: polygonArea(pts) < 0, points: pts})
+		}
+	}
+	return res
+}
+
+// Helper function to remove the points of an outline that lie on a straight segment
+func simplifyOutline(pts []point) []point {
+	var res []point
+	n := len(pts)
+	for i, p := range pts {
+		prev, next := pts[(i+n-1)%n], pts[(i+1)%n]
+		if (prev.x-p.x)*(next.y-p.y)-(prev.y-p.y)*(next.x-p.x) != 0 {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Helper function to compute the signed area of a polygon with the shoelace formula
+func polygonArea(pts []point) float64 {
+	var a float64
+	for i, p := range pts {
+		q := pts[(i+1)%len(pts)]
+		a += p.x*q.y - q.x*p.y
+	}
+	return a / 2
+}
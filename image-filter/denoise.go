@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/toxyl/math"
+)
+
+// channelPlanes holds the premultiplied red, green, blue and alpha channels of an image
+// as separate planes of values in 0..1, so filters work the same for 8 and 16 bit images
+type channelPlanes struct {
+	rect image.Rectangle
+	w, h int
+	c    [4][]float64
+}
+
+// @Name: bilateral
+// @Desc: Smooths an image while preserving edges, pixels only contribute if they are close in space and color
+// @Param:      img     	- 	-   	-   The image to smooth, 16 bit images stay 16 bit
+// @Param:      sigma-space px 	0..  	3   The spatial standard deviation
+// @Param:      sigma-color - 	0..255  25  The color standard deviation on a 0..255 scale
+// @Returns:    result  	- 	-   	-   The smoothed image
+func bilateral(img image.Image, sigmaSpace float64, sigmaColor float64) (image.Image, error) {
+	if sigmaSpace <= 0 || sigmaColor <= 0 {
+		return nil, fmt.Errorf("sigma-space and sigma-color must be greater than 0")
+	}
+	return bilateralPlanes(newChannelPlanes(img), sigmaSpace, sigmaColor/255).toImageLike(img), nil
+}
+
+// @Name: guided-filter
+// @Desc: Smooths an image while preserving edges using the image's own luminance as guide
+// @Param:      img     - 	-   	-   	The image to smooth, 16 bit images stay 16 bit
+// @Param:      radius  px 	1..  	4   	The radius of the filter window
+// @Param:      eps     - 	0..1  	0.01   	The regularization, larger values smooth more
+// @Returns:    result  - 	-   	-   	The smoothed image
+func guidedFilter(img image.Image, radius int, eps float64) (image.Image, error) {
+	if radius < 1 {
+		return nil, fmt.Errorf("radius must be at least 1")
+	}
+	if eps <= 0 {
+		return nil, fmt.Errorf("eps must be greater than 0")
+	}
+	p := newChannelPlanes(img)
+	guide := make([]float64, p.w*p.h)
+	for i := range guide {
+		guide[i] = 0.2126*p.c[0][i] + 0.7152*p.c[1][i] + 0.0722*p.c[2][i]
+	}
+
+	meanI := boxFilter(guide, p.w, p.h, radius)
+	corrI := boxFilter(multiplyPlanes(guide, guide), p.w, p.h, radius)
+	result := &channelPlanes{rect: p.rect, w: p.w, h: p.h}
+	for ch, plane := range p.c {
+		meanP := boxFilter(plane, p.w, p.h, radius)
+		corrIP := boxFilter(multiplyPlanes(guide, plane), p.w, p.h, radius)
+		a := make([]float64, len(plane))
+		b := make([]float64, len(plane))
+		for i := range plane {
+			varI := corrI[i] - meanI[i]*meanI[i]
+			covIP := corrIP[i] - meanI[i]*meanP[i]
+			a[i] = covIP / (varI + eps)
+			b[i] = meanP[i] - a[i]*meanI[i]
+		}
+		meanA := boxFilter(a, p.w, p.h, radius)
+		meanB := boxFilter(b, p.w, p.h, radius)
+		out := make([]float64, len(plane))
+		for i := range out {
+			out[i] = meanA[i]*guide[i] + meanB[i]
+		}
+		result.c[ch] = out
+	}
+	return result.toImageLike(img), nil
+}
+
+// @Name: nl-means
+// @Desc: Removes noise by averaging pixels whose surrounding patches look alike (non-local means)
+// @Param:      img     - 	-   	-   The image to denoise, 16 bit images stay 16 bit
+// @Param:      h       - 	0..255  10  The filter strength on a 0..255 scale, should be close to the noise level
+// @Returns:    result  - 	-   	-   The denoised image
+func nlMeans(img image.Image, h float64) (image.Image, error) {
+	const (
+		patchRadius  = 2
+		searchRadius = 7
+	)
+	if h <= 0 {
+		return nil, fmt.Errorf("h must be greater than 0")
+	}
+	p := newChannelPlanes(img)
+	w, ht := p.w, p.h
+	h2 := (h / 255) * (h / 255)
+
+	var acc [4][]float64
+	for ch := range acc {
+		acc[ch] = make([]float64, w*ht)
+	}
+	wsum := make([]float64, w*ht)
+	diff := make([]float64, w*ht)
+	clampX := func(x int) int { return min(max(x, 0), w-1) }
+	clampY := func(y int) int { return min(max(y, 0), ht-1) }
+
+	// Instead of comparing every patch pair, the squared differences for one offset are
+	// computed once for the whole image and summed per patch with a box filter, which makes
+	// the cost independent of the patch size.
+	for dy := -searchRadius; dy <= searchRadius; dy++ {
+		for dx := -searchRadius; dx <= searchRadius; dx++ {
+			for y := 0; y < ht; y++ {
+				oy := clampY(y + dy)
+				for x := 0; x < w; x++ {
+					i, o := y*w+x, oy*w+clampX(x+dx)
+					var d float64
+					for ch := 0; ch < 3; ch++ {
+						v := p.c[ch][i] - p.c[ch][o]
+						d += v * v
+					}
+					diff[i] = d / 3
+				}
+			}
+			dist := boxFilter(diff, w, ht, patchRadius)
+			for y := 0; y < ht; y++ {
+				oy := clampY(y + dy)
+				for x := 0; x < w; x++ {
+					i, o := y*w+x, oy*w+clampX(x+dx)
+					weight := math.Exp(-dist[i] / h2)
+					for ch := range acc {
+						acc[ch][i] += p.c[ch][o] * weight
+					}
+					wsum[i] += weight
+				}
+			}
+		}
+	}
+
+	result := &channelPlanes{rect: p.rect, w: w, h: ht}
+	for ch := range acc {
+		for i := range acc[ch] {
+			acc[ch][i] /= wsum[i]
+		}
+		result.c[ch] = acc[ch]
+	}
+	return result.toImageLike(img), nil
+}
+
+// Helper function to split an image into channel planes
+func newChannelPlanes(img image.Image) *channelPlanes {
+	b := img.Bounds()
+	p := &channelPlanes{rect: b, w: b.Dx(), h: b.Dy()}
+	for ch := range p.c {
+		p.c[ch] = make([]float64, p.w*p.h)
+	}
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			p.c[0][i] = float64(r) / 0xffff
+			p.c[1][i] = float64(g) / 0xffff
+			p.c[2][i] = float64(bl) / 0xffff
+			p.c[3][i] = float64(a) / 0xffff
+			i++
+		}
+	}
+	return p
+}
+
+// Helper function to convert the planes back to an image of the same kind as the
+// source: 16 bit images become RGBA64, everything else becomes NRGBA
+func (p *channelPlanes) toImageLike(src image.Image) image.Image {
+	if isDeepImage(src) {
+		return p.toRGBA64()
+	}
+	return p.toNRGBA()
+}
+
+// Helper function to convert the planes to an RGBA64 image
+func (p *channelPlanes) toRGBA64() *image.RGBA64 {
+	img := image.NewRGBA64(p.rect)
+	to16 := func(v float64) uint16 { return uint16(math.Round(math.Clamp(v, 0, 1) * 0xffff)) }
+	i := 0
+	for y := p.rect.Min.Y; y < p.rect.Max.Y; y++ {
+		for x := p.rect.Min.X; x < p.rect.Max.X; x++ {
+			a := to16(p.c[3][i])
+			img.SetRGBA64(x, y, color.RGBA64{
+				R: min(to16(p.c[0][i]), a),
+				G: min(to16(p.c[1][i]), a),
+				B: min(to16(p.c[2][i]), a),
+				A: a,
+			})
+			i++
+		}
+	}
+	return img
+}
+
+// Helper function to convert the planes to an NRGBA image
+func (p *channelPlanes) toNRGBA() *image.NRGBA {
+	img := image.NewNRGBA(p.rect)
+	i := 0
+	for y := p.rect.Min.Y; y < p.rect.Max.Y; y++ {
+		for x := p.rect.Min.X; x < p.rect.Max.X; x++ {
+			a := math.Clamp(p.c[3][i], 0, 1)
+			var c color.NRGBA
+			if a > 0 {
+				to8 := func(v float64) uint8 { return uint8(math.Round(math.Clamp(v/a, 0, 1) * 255)) }
+				c = color.NRGBA{R: to8(p.c[0][i]), G: to8(p.c[1][i]), B: to8(p.c[2][i]), A: uint8(math.Round(a * 255))}
+			}
+			img.SetNRGBA(x, y, c)
+			i++
+		}
+	}
+	return img
+}
+
+// Helper function to apply a bilateral filter to channel planes, sigmaColor is on a 0..1 scale
+func bilateralPlanes(p *channelPlanes, sigmaSpace, sigmaColor float64) *channelPlanes {
+	radius := int(math.Ceil(sigmaSpace * 2))
+	size := 2*radius + 1
+	spatial := make([]float64, size*size)
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			spatial[(dy+radius)*size+dx+radius] = math.Exp(-float64(dx*dx+dy*dy) / (2 * sigmaSpace * sigmaSpace))
+		}
+	}
+
+	// the range weight is looked up from a table indexed by the squared color distance,
+	// distances beyond 3 sigma per channel contribute (almost) nothing
+	const lutSize = 4096
+	maxDist := 27 * sigmaColor * sigmaColor
+	var rangeLUT [lutSize + 1]float64
+	for i := range rangeLUT {
+		rangeLUT[i] = math.Exp(-float64(i) / lutSize * maxDist / (2 * sigmaColor * sigmaColor))
+	}
+
+	result := &channelPlanes{rect: p.rect, w: p.w, h: p.h}
+	for ch := range result.c {
+		result.c[ch] = make([]float64, p.w*p.h)
+	}
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			i := y*p.w + x
+			var sum [4]float64
+			var wsum float64
+			for ny := max(y-radius, 0); ny <= min(y+radius, p.h-1); ny++ {
+				for nx := max(x-radius, 0); nx <= min(x+radius, p.w-1); nx++ {
+					n := ny*p.w + nx
+					dr, dg, db := p.c[0][n]-p.c[0][i], p.c[1][n]-p.c[1][i], p.c[2][n]-p.c[2][i]
+					d := dr*dr + dg*dg + db*db
+					if d >= maxDist {
+						continue
+					}
+					w := spatial[(ny-y+radius)*size+nx-x+radius] * rangeLUT[int(d/maxDist*lutSize)]
+					for ch := range sum {
+						sum[ch] += p.c[ch][n] * w
+					}
+					wsum += w
+				}
+			}
+			for ch := range sum {
+				result.c[ch][i] = sum[ch] / wsum
+			}
+		}
+	}
+	return result
+}
+
+// Helper function to compute the mean of every (2r+1)x(2r+1) window of a plane
+// using a summed-area table, windows are cropped at the borders
+func boxFilter(plane []float64, w, h, r int) []float64 {
+	stride := w + 1
+	sat := make([]float64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := (y+1)*stride + x + 1
+			sat[i] = plane[y*w+x] + sat[i-1] + sat[i-stride] - sat[i-stride-1]
+		}
+	}
+	res := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-r, 0), min(y+r+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-r, 0), min(x+r+1, w)
+			sum := sat[y1*stride+x1] - sat[y0*stride+x1] - sat[y1*stride+x0] + sat[y0*stride+x0]
+			res[y*w+x] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+	return res
+}
+
+// Helper function to multiply two planes element-wise
+func multiplyPlanes(a, b []float64) []float64 {
+	res := make([]float64, len(a))
+	for i := range a {
+		res[i] = a[i] * b[i]
+	}
+	return res
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/toxyl/math"
+	"golang.org/x/image/vector"
+)
+
+var (
+	// @Name:  stroke-dash
+	// @Desc:  Length of the dashes of strokes drawn by the draw-* functions, 0 draws solid strokes
+	// @Range: 0..
+	// @Unit:  px
+	strokeDash = 0.0
+
+	// @Name:  stroke-gap
+	// @Desc:  Length of the gaps between the dashes of strokes drawn by the draw-* functions
+	// @Range: 0..
+	// @Unit:  px
+	strokeGap = 0.0
+)
+
+// point is a point in image coordinates
+type point struct {
+	x, y float64
+}
+
+// subpath is a polyline that is either open or closed
+type subpath struct {
+	points []point
+	closed bool
+}
+
+// @Name: draw-line
+// @Desc: Draws an anti-aliased line onto a copy of the image
+// @Param:      img     - -   	-   The image to draw on
+// @Param:      x1      px -   	0   X coordinate of the start point
+// @Param:      y1      px -   	0   Y coordinate of the start point
+// @Param:      x2      px -   	0   X coordinate of the end point
+// @Param:      y2      px -   	0   Y coordinate of the end point
+// @Param:      width   px 0..  	1   The stroke width
+// @Param:      col     - -   	-   The stroke color
+// @Returns:    result  - -   	-   The image with the line drawn
+func drawLine(img *image.NRGBA, x1, y1, x2, y2, width float64, col color.RGBA64) (*image.NRGBA, error) {
+	return drawShape(img, []subpath{{points: []point{{x1, y1}, {x2, y2}}}}, width, col, color.RGBA64{})
+}
+
+// @Name: draw-rect
+// @Desc: Draws an anti-aliased rectangle onto a copy of the image
+// @Param:      img     - -   	-   The image to draw on
+// @Param:      x       px -   	0   X coordinate of the top-left corner
+// @Param:      y       px -   	0   Y coordinate of the top-left corner
+// @Param:      w       px 0..  	0   The width of the rectangle
+// @Param:      h       px 0..  	0   The height of the rectangle
+// @Param:      width   px 0..  	1   The stroke width, 0 draws no stroke
+// @Param:      stroke  - -   	-   The stroke color
+// @Param:      fill    - -   	-   The fill color, use a transparent color for no fill
+// @Returns:    result  - -   	-   The image with the rectangle drawn
+func drawRect(img *image.NRGBA, x, y, w, h, width float64, stroke color.RGBA64, fill color.RGBA64) (*image.NRGBA, error) {
+	pts := []point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}
+	return drawShape(img, []subpath{{points: pts, closed: true}}, width, stroke, fill)
+}
+
+// @Name: draw-circle
+// @Desc: Draws an anti-aliased circle onto a copy of the image
+// @Param:      img     - -   	-   The image to draw on
+// @Param:      cx      px -   	0   X coordinate of the center
+// @Param:      cy      px -   	0   Y coordinate of the center
+// @Param:      r       px 0..  	0   The radius
+// @Param:      width   px 0..  	1   The stroke width, 0 draws no stroke
+// @Param:      stroke  - -   	-   The stroke color
+// @Param:      fill    - -   	-   The fill color, use a transparent color for no fill
+// @Returns:    result  - -   	-   The image with the circle drawn
+func drawCircle(img *image.NRGBA, cx, cy, r, width float64, stroke color.RGBA64, fill color.RGBA64) (*image.NRGBA, error) {
+	return drawEllipse(img, cx, cy, r, r, width, stroke, fill)
+}
+
+// @Name: draw-ellipse
+// @Desc: Draws an anti-aliased ellipse onto a copy of the image
+// @Param:      img     - -   	-   The image to draw on
+// @Param:      cx      px -   	0   X coordinate of the center
+// @Param:      cy      px -   	0   Y coordinate of the center
+// @Param:      rx      px 0..  	0   The horizontal radius
+// @Param:      ry      px 0..  	0   The vertical radius
+// @Param:      width   px 0..  	1   The stroke width, 0 draws no stroke
+// @Param:      stroke  - -   	-   The stroke color
+// @Param:      fill    - -   	-   The fill color, use a transparent color for no fill
+// @Returns:    result  - -   	-   The image with the ellipse drawn
+func drawEllipse(img *image.NRGBA, cx, cy, rx, ry, width float64, stroke color.RGBA64, fill color.RGBA64) (*image.NRGBA, error) {
+	if rx < 0 || ry < 0 {
+		return nil, fmt.Errorf("radius must not be negative")
+	}
+	return drawShape(img, []subpath{{points: ellipsePoints(cx, cy, rx, ry), closed: true}}, width, stroke, fill)
+}
+
+// @Name: draw-polygon
+// @Desc: Draws an anti-aliased polygon onto a copy of the image
+// @Param:      img     - -   	-   The image to draw on
+// @Param:      points  - -   	-   The corners of the polygon as in SVG, e.g. "10,10 50,10 30,40"
+// @Param:      width   px 0..  	1   The stroke width, 0 draws no stroke
+// @Param:      stroke  - -   	-   The stroke color
+// @Param:      fill    - -   	-   The fill color, use a transparent color for no fill
+// @Returns:    result  - -   	-   The image with the polygon drawn
+func drawPolygon(img *image.NRGBA, points string, width float64, stroke color.RGBA64, fill color.RGBA64) (*image.NRGBA, error) {
+	nums, err := parseNumbers(points)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) < 6 || len(nums)%2 != 0 {
+		return nil, fmt.Errorf("a polygon needs at least three x,y pairs")
+	}
+	pts := make([]point, len(nums)/2)
+	for i := range pts {
+		pts[i] = point{nums[i*2], nums[i*2+1]}
+	}
+	return drawShape(img, []subpath{{points: pts, closed: true}}, width, stroke, fill)
+}
+
+// @Name: draw-path
+// @Desc: Draws an anti-aliased SVG path onto a copy of the image, supports the M, L, H, V, C, S, Q, T and Z commands
+// @Param:      img     - -   	-   The image to draw on
+// @Param:      d       - -   	-   The path data as in SVG, e.g. "M 10 10 L 50 10 Q 60 30 30 40 Z"
+// @Param:      width   px 0..  	1   The stroke width, 0 draws no stroke
+// @Param:      stroke  - -   	-   The stroke color
+// @Param:      fill    - -   	-   The fill color, use a transparent color for no fill
+// @Returns:    result  - -   	-   The image with the path drawn
+func drawPath(img *image.NRGBA, d string, width float64, stroke color.RGBA64, fill color.RGBA64) (*image.NRGBA, error) {
+	paths, err := parseSVGPath(d)
+	if err != nil {
+		return nil, err
+	}
+	return drawShape(img, paths, width, stroke, fill)
+}
+
+// Helper function to fill and stroke subpaths on a copy of the image
+func drawShape(img *image.NRGBA, paths []subpath, width float64, stroke, fill color.RGBA64) (*image.NRGBA, error) {
+	if width < 0 {
+		return nil, fmt.Errorf("stroke width must not be negative")
+	}
+	result := cloneNRGBA(img)
+
+	if fill.A > 0 {
+		z := newRasterizer(result)
+		for _, p := range paths {
+			addPolygon(z, result.Bounds().Min, p.points)
+		}
+		blendMask(result, rasterize(z), rgba64ToNRGBA(fill))
+	}
+	if width > 0 && stroke.A > 0 {
+		z := newRasterizer(result)
+		for _, p := range paths {
+			parts, err := dashSubpath(p, strokeDash, strokeGap)
+			if err != nil {
+				return nil, err
+			}
+			for _, part := range parts {
+				strokeSubpath(z, result.Bounds().Min, part, width/2)
+			}
+		}
+		blendMask(result, rasterize(z), rgba64ToNRGBA(stroke))
+	}
+	return result, nil
+}
+
+// Helper function to create a rasterizer covering the image
+func newRasterizer(img image.Image) *vector.Rasterizer {
+	return vector.NewRasterizer(img.Bounds().Dx(), img.Bounds().Dy())
+}
+
+// Helper function to rasterize the coverage of all shapes added to the rasterizer
+func rasterize(z *vector.Rasterizer) *image.Alpha {
+	mask := image.NewAlpha(z.Bounds())
+	z.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+	return mask
+}
+
+// Helper function to add a closed polygon to the rasterizer, origin is the image's bounds origin
+func addPolygon(z *vector.Rasterizer, origin image.Point, pts []point) {
+	ox, oy := float64(origin.X), float64(origin.Y)
+	size := z.Bounds().Size()
+	pts = clipPolygon(pts, ox-1, oy-1, ox+float64(size.X)+1, oy+float64(size.Y)+1)
+	if len(pts) < 3 {
+		return
+	}
+	z.MoveTo(float32(pts[0].x-ox), float32(pts[0].y-oy))
+	for _, p := range pts[1:] {
+		z.LineTo(float32(p.x-ox), float32(p.y-oy))
+	}
+	z.ClosePath()
+}
+
+// Helper function to clip a polygon to a rectangle (Sutherland-Hodgman), the rasterizer's fixed-point
+// math overflows for coordinates far outside of the canvas. The coverage inside the rectangle doesn't
+// change, edges the clipping adds along the rectangle's sides cancel each other out.
+func clipPolygon(pts []point, x0, y0, x1, y1 float64) []point {
+	for _, p := range pts {
+		if math.IsNaN(p.x) || math.IsNaN(p.y) {
+			return nil
+		}
+	}
+	clip := func(pts []point, inside func(point) bool, cross func(a, b point) point) []point {
+		var res []point
+		for i, b := range pts {
+			a := pts[(i+len(pts)-1)%len(pts)]
+			switch {
+			case inside(b):
+				if !inside(a) {
+					res = append(res, cross(a, b))
+				}
+				res = append(res, b)
+			case inside(a):
+				res = append(res, cross(a, b))
+			}
+		}
+		return res
+	}
+	atX := func(x float64) func(a, b point) point {
+		return func(a, b point) point { return point{x, a.y + (b.y-a.y)*(x-a.x)/(b.x-a.x)} }
+	}
+	atY := func(y float64) func(a, b point) point {
+		return func(a, b point) point { return point{a.x + (b.x-a.x)*(y-a.y)/(b.y-a.y), y} }
+	}
+	// infinite coordinates would turn intersections into NaN
+	const far = 1e300
+	res := make([]point, len(pts))
+	for i, p := range pts {
+		res[i] = point{math.Max(-far, math.Min(far, p.x)), math.Max(-far, math.Min(far, p.y))}
+	}
+	res = clip(res, func(p point) bool { return p.x >= x0 }, atX(x0))
+	res = clip(res, func(p point) bool { return p.x <= x1 }, atX(x1))
+	res = clip(res, func(p point) bool { return p.y >= y0 }, atY(y0))
+	return clip(res, func(p point) bool { return p.y <= y1 }, atY(y1))
+}
+
+// Helper function to add a polygon in counter-clockwise winding, so that
+// overlapping polygons add up instead of cancelling each other out
+func addPolygonCCW(z *vector.Rasterizer, origin image.Point, pts []point) {
+	var area float64
+	for i := range pts {
+		j := (i + 1) % len(pts)
+		area += pts[i].x*pts[j].y - pts[j].x*pts[i].y
+	}
+	if area < 0 {
+		rev := make([]point, len(pts))
+		for i, p := range pts {
+			rev[len(pts)-1-i] = p
+		}
+		pts = rev
+	}
+	addPolygon(z, origin, pts)
+}
+
+// Helper function to add the outline of a subpath with round joins and butt caps to the rasterizer
+func strokeSubpath(z *vector.Rasterizer, origin image.Point, p subpath, hw float64) {
+	pts := p.points
+	if p.closed && len(pts) > 2 {
+		pts = append(append([]point(nil), pts...), pts[0])
+	}
+	for i := 0; i+1 < len(pts); i++ {
+		a, b := pts[i], pts[i+1]
+		l := math.Hypot(b.x-a.x, b.y-a.y)
+		if l == 0 {
+			continue
+		}
+		nx, ny := -(b.y-a.y)/l*hw, (b.x-a.x)/l*hw
+		addPolygonCCW(z, origin, []point{{a.x + nx, a.y + ny}, {b.x + nx, b.y + ny}, {b.x - nx, b.y - ny}, {a.x - nx, a.y - ny}})
+
+		// round joins between segments
+		if i+2 < len(pts) || (p.closed && len(pts) > 3) {
+			addPolygonCCW(z, origin, ellipsePoints(b.x, b.y, hw, hw))
+		}
+	}
+}
+
+// Helper function to split a subpath into dashes, returns the subpath as-is if dash is 0
+func dashSubpath(p subpath, dash, gap float64) ([]subpath, error) {
+	if dash <= 0 || gap <= 0 {
+		return []subpath{p}, nil
+	}
+	pts := p.points
+	if p.closed && len(pts) > 2 {
+		pts = append(append([]point(nil), pts...), pts[0])
+	}
+	var length float64
+	for i := 0; i+1 < len(pts); i++ {
+		length += math.Hypot(pts[i+1].x-pts[i].x, pts[i+1].y-pts[i].y)
+	}
+	if n := 2 * length / (dash + gap); !(n <= maxCurveSegments) { // also catches NaN
+		return nil, fmt.Errorf("stroke-dash and stroke-gap are too short for a stroke of %.0f px, it would have more than %d dashes", length, maxCurveSegments/2)
+	}
+
+	var res []subpath
+	var cur []point
+	on, remaining := true, dash
+	for i := 0; i+1 < len(pts); i++ {
+		a, b := pts[i], pts[i+1]
+		l := math.Hypot(b.x-a.x, b.y-a.y)
+		pos := 0.0
+		if on && len(cur) == 0 {
+			cur = append(cur, a)
+		}
+		for l-pos > remaining {
+			pos += remaining
+			q := point{a.x + (b.x-a.x)*pos/l, a.y + (b.y-a.y)*pos/l}
+			if on {
+				res = append(res, subpath{points: append(cur, q)})
+				cur = nil
+				remaining = gap
+			} else {
+				cur = []point{q}
+				remaining = dash
+			}
+			on = !on
+		}
+		remaining -= l - pos
+		if on {
+			cur = append(cur, b)
+		}
+	}
+	if on && len(cur) > 1 {
+		res = append(res, subpath{points: cur})
+	}
+	return res, nil
+}
+
+// maxCurveSegments is the largest number of line segments a curve or ellipse is approximated with and
+// a dashed stroke is split into. It keeps huge radii and tiny dashes from allocating unbounded memory.
+const maxCurveSegments = 1 << 16
+
+// Helper function to get the number of line segments for a curve of the given length, about one per 2 pixels
+func curveSegments(length float64, minSegments int) int {
+	n := math.Ceil(length / 2)
+	if !(n < maxCurveSegments) { // also catches NaN
+		return maxCurveSegments
+	}
+	return max(minSegments, int(n))
+}
+
+// Helper function to approximate an ellipse with a polygon
+func ellipsePoints(cx, cy, rx, ry float64) []point {
+	n := curveSegments(math.Max(rx, ry)*2*math.Pi, 16)
+	pts := make([]point, n)
+	for i := range pts {
+		a := float64(i) / float64(n) * 2 * math.Pi
+		pts[i] = point{cx + rx*math.Cos(a), cy + ry*math.Sin(a)}
+	}
+	return pts
+}
+
+// Helper function to blend a color onto an image through a coverage mask using source-over compositing
+func blendMask(dst *image.NRGBA, mask *image.Alpha, col color.NRGBA) {
+	b := dst.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			m := mask.AlphaAt(x, y).A
+			if m == 0 {
+				continue
+			}
+			sa := float64(col.A) / 255.0 * float64(m) / 255.0
+			d := dst.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+			da := float64(d.A) / 255.0
+			oa := sa + da*(1-sa)
+			if oa == 0 {
+				continue
+			}
+			blend := func(s, d uint8) uint8 {
+				return uint8(math.Round((float64(s)*sa + float64(d)*da*(1-sa)) / oa))
+			}
+			dst.SetNRGBA(b.Min.X+x, b.Min.Y+y, color.NRGBA{
+				R: blend(col.R, d.R),
+				G: blend(col.G, d.G),
+				B: blend(col.B, d.B),
+				A: uint8(math.Round(oa * 255)),
+			})
+		}
+	}
+}
+
+// Helper function to convert a color created by hsla to NRGBA
+func rgba64ToNRGBA(col color.RGBA64) color.NRGBA {
+	return color.NRGBA{
+		R: uint8(col.R >> 8),
+		G: uint8(col.G >> 8),
+		B: uint8(col.B >> 8),
+		A: uint8(col.A >> 8),
+	}
+}
+
+// Helper function to parse a list of numbers separated by whitespace and/or commas
+func parseNumbers(s string) ([]float64, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	nums := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", f)
+		}
+		nums[i] = v
+	}
+	return nums, nil
+}
+
+// pathNumberRe matches the numbers of SVG path data, which may not be separated if the sign or a dot separates them, e.g. "10-5.5.5"
+var pathNumberRe = regexp.MustCompile(`[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?`)
+
+// Helper function to parse SVG path data into flattened subpaths
+func parseSVGPath(d string) ([]subpath, error) {
+	// split into commands and their numbers
+	type command struct {
+		op   byte
+		args []float64
+	}
+	var cmds []command
+	start := -1
+	for i := 0; i <= len(d); i++ {
+		if i < len(d) && !strings.ContainsRune("MmLlHhVvCcSsQqTtZz", rune(d[i])) {
+			if unicode.IsLetter(rune(d[i])) && d[i] != 'e' && d[i] != 'E' {
+				return nil, fmt.Errorf("unsupported path command %q", d[i])
+			}
+			continue
+		}
+		if start >= 0 {
+			var args []float64
+			for _, f := range pathNumberRe.FindAllString(d[start+1:i], -1) {
+				v, err := strconv.ParseFloat(f, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid number %q", f)
+				}
+				args = append(args, v)
+			}
+			cmds = append(cmds, command{op: d[start], args: args})
+		}
+		start = i
+	}
+	if len(cmds) == 0 {
+		return nil, fmt.Errorf("path is empty")
+	}
+
+	argCount := map[byte]int{'M': 2, 'L': 2, 'H': 1, 'V': 1, 'C': 6, 'S': 4, 'Q': 4, 'T': 2, 'Z': 0}
+	var paths []subpath
+	var cur subpath
+	var pos, startPos, ctrl point
+	var prevOp byte
+	flush := func() {
+		if len(cur.points) > 1 {
+			paths = append(paths, cur)
+		}
+		cur = subpath{}
+	}
+
+	for _, c := range cmds {
+		op := byte(unicode.ToUpper(rune(c.op)))
+		rel := c.op != op
+		n := argCount[op]
+		if n == 0 {
+			if len(c.args) > 0 {
+				return nil, fmt.Errorf("command %c takes no arguments", c.op)
+			}
+			cur.closed = true
+			flush()
+			pos = startPos
+			cur.points = []point{pos}
+			prevOp = op
+			continue
+		}
+		if len(c.args) == 0 || len(c.args)%n != 0 {
+			return nil, fmt.Errorf("command %c needs a multiple of %d arguments", c.op, n)
+		}
+		for i := 0; i < len(c.args); i += n {
+			a := c.args[i : i+n]
+			abs := func(x, y float64) point {
+				if rel {
+					return point{pos.x + x, pos.y + y}
+				}
+				return point{x, y}
+			}
+			switch op {
+			case 'M':
+				if i == 0 {
+					flush()
+					pos = abs(a[0], a[1])
+					startPos = pos
+					cur.points = []point{pos}
+				} else {
+					pos = abs(a[0], a[1]) // subsequent pairs are implicit line-tos
+					cur.points = append(cur.points, pos)
+				}
+			case 'L':
+				pos = abs(a[0], a[1])
+				cur.points = append(cur.points, pos)
+			case 'H':
+				if rel {
+					pos.x += a[0]
+				} else {
+					pos.x = a[0]
+				}
+				cur.points = append(cur.points, pos)
+			case 'V':
+				if rel {
+					pos.y += a[0]
+				} else {
+					pos.y = a[0]
+				}
+				cur.points = append(cur.points, pos)
+			case 'C', 'S':
+				c1 := point{}
+				var c2, end point
+				if op == 'C' {
+					c1, c2, end = abs(a[0], a[1]), abs(a[2], a[3]), abs(a[4], a[5])
+				} else {
+					c1 = pos
+					if prevOp == 'C' || prevOp == 'S' {
+						c1 = point{2*pos.x - ctrl.x, 2*pos.y - ctrl.y}
+					}
+					c2, end = abs(a[0], a[1]), abs(a[2], a[3])
+				}
+				cur.points = append(cur.points, flattenCubic(pos, c1, c2, end)...)
+				pos, ctrl = end, c2
+			case 'Q', 'T':
+				var c1, end point
+				if op == 'Q' {
+					c1, end = abs(a[0], a[1]), abs(a[2], a[3])
+				} else {
+					c1 = pos
+					if prevOp == 'Q' || prevOp == 'T' {
+						c1 = point{2*pos.x - ctrl.x, 2*pos.y - ctrl.y}
+					}
+					end = abs(a[0], a[1])
+				}
+				// elevate the quadratic curve to a cubic one
+				cp1 := point{pos.x + 2.0/3.0*(c1.x-pos.x), pos.y + 2.0/3.0*(c1.y-pos.y)}
+				cp2 := point{end.x + 2.0/3.0*(c1.x-end.x), end.y + 2.0/3.0*(c1.y-end.y)}
+				cur.points = append(cur.points, flattenCubic(pos, cp1, cp2, end)...)
+				pos, ctrl = end, c1
+			}
+			prevOp = op
+		}
+	}
+	flush()
+	return paths, nil
+}
+
+// Helper function to approximate a cubic Bézier curve with line segments, excludes the start point
+func flattenCubic(p0, p1, p2, p3 point) []point {
+	l := math.Hypot(p1.x-p0.x, p1.y-p0.y) + math.Hypot(p2.x-p1.x, p2.y-p1.y) + math.Hypot(p3.x-p2.x, p3.y-p2.y)
+	n := curveSegments(l, 4)
+	pts := make([]point, n)
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		pts[i-1] = point{
+			u*u*u*p0.x + 3*u*u*t*p1.x + 3*u*t*t*p2.x + t*t*t*p3.x,
+			u*u*u*p0.y + 3*u*u*t*p1.y + 3*u*t*t*p2.y + t*t*t*p3.y,
+		}
+	}
+	return pts
+}
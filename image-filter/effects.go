@@ -12,76 +12,37 @@ import (
 // @Desc: Inverts an image
 // @Param:      img     - -   -   The image to invert
 // @Returns:    result  - -   -   The inverted image
-func invert(img *image.NRGBA) (any, error) {
-	bounds := img.Bounds()
-	inverted := image.NewNRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := img.NRGBAAt(x, y)
-			inverted.Set(x, y, color.NRGBA{
-				R: 255 - c.R,
-				G: 255 - c.G,
-				B: 255 - c.B,
-				A: c.A, // Keep original alpha
-			})
-		}
-	}
-	return inverted, nil
+func invert(img image.Image) (any, error) {
+	return applyPixelOp(img, pixelOp{"invert", func(c rgb) rgb {
+		return rgb{255 - c[0], 255 - c[1], 255 - c[2]}
+	}})
 }
 
 // @Name: grayscale
 // @Desc: Grayscales an image
 // @Param:      img     - -   -   The image to grayscale
 // @Returns:    result  - -   -   The grayscaled image
-func grayscale(img *image.NRGBA) (any, error) {
-	bounds := img.Bounds()
-	grayscaled := image.NewNRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := img.NRGBAAt(x, y)
-			// Using luminosity method: 0.21 R + 0.72 G + 0.07 B
-			gray := uint8(float64(c.R)*0.21 + float64(c.G)*0.72 + float64(c.B)*0.07)
-			grayscaled.Set(x, y, color.NRGBA{
-				R: gray,
-				G: gray,
-				B: gray,
-				A: c.A,
-			})
-		}
-	}
-	return grayscaled, nil
+func grayscale(img image.Image) (any, error) {
+	return applyPixelOp(img, pixelOp{"grayscale", func(c rgb) rgb {
+		// Using luminosity method: 0.21 R + 0.72 G + 0.07 B
+		gray := c[0]*0.21 + c[1]*0.72 + c[2]*0.07
+		return rgb{gray, gray, gray}
+	}})
 }
 
 // @Name: sepia
 // @Desc: Changes the tone of an image to sepia
 // @Param:      img     - -   -   The image to change to sepia tone
 // @Returns:    result  - -   -   The sepia-toned image
-func sepia(img *image.NRGBA) (any, error) {
-	bounds := img.Bounds()
-	sepia := image.NewNRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := img.NRGBAAt(x, y)
-			r := float64(c.R)
-			g := float64(c.G)
-			b := float64(c.B)
-
-			newR := uint8(math.Min((r*0.393)+(g*0.769)+(b*0.189), 255))
-			newG := uint8(math.Min((r*0.349)+(g*0.686)+(b*0.168), 255))
-			newB := uint8(math.Min((r*0.272)+(g*0.534)+(b*0.131), 255))
-
-			sepia.Set(x, y, color.NRGBA{
-				R: newR,
-				G: newG,
-				B: newB,
-				A: c.A,
-			})
+func sepia(img image.Image) (any, error) {
+	return applyPixelOp(img, pixelOp{"sepia", func(c rgb) rgb {
+		r, g, b := c[0], c[1], c[2]
+		return rgb{
+			math.Min((r*0.393)+(g*0.769)+(b*0.189), 255),
+			math.Min((r*0.349)+(g*0.686)+(b*0.168), 255),
+			math.Min((r*0.272)+(g*0.534)+(b*0.131), 255),
 		}
-	}
-	return sepia, nil
+	}})
 }
 
 // @Name: brightness
@@ -89,26 +50,17 @@ func sepia(img *image.NRGBA) (any, error) {
 // @Param:      img     - -   	-   The image to change brightness of
 // @Param:      factor  - 0..2  0   The change factor
 // @Returns:    result  - -   	-   The image with brightness changed
-func brightness(img *image.NRGBA, factor float64) (any, error) {
+func brightness(img image.Image, factor float64) (any, error) {
 	if factor < 0.0 || factor > 2.0 {
 		return nil, fmt.Errorf("brightness factor must be between 0.0 and 2.0")
 	}
-
-	bounds := img.Bounds()
-	adjusted := image.NewNRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := img.NRGBAAt(x, y)
-			adjusted.Set(x, y, color.NRGBA{
-				R: uint8(math.Min(float64(c.R)*factor, 255)),
-				G: uint8(math.Min(float64(c.G)*factor, 255)),
-				B: uint8(math.Min(float64(c.B)*factor, 255)),
-				A: c.A,
-			})
+	return applyPixelOp(img, pixelOp{fmt.Sprintf("brightness(%g)", factor), func(c rgb) rgb {
+		return rgb{
+			math.Min(c[0]*factor, 255),
+			math.Min(c[1]*factor, 255),
+			math.Min(c[2]*factor, 255),
 		}
-	}
-	return adjusted, nil
+	}})
 }
 
 // @Name: fill
@@ -143,9 +95,6 @@ func fill(img *image.NRGBA, col color.RGBA64) (*image.NRGBA, error) {
 // @Param:      col  	- - -   The color that determines the hue to use for colorization
 // @Returns:    result  - - -	The colorized image
 func colorize(img *image.NRGBA, col color.RGBA64) (*image.NRGBA, error) {
-	bounds := img.Bounds()
-	colorized := image.NewNRGBA(bounds)
-
 	// Convert target color to normalized RGB and get alpha
 	targetR := float64(col.R) / 65535.0
 	targetG := float64(col.G) / 65535.0
@@ -155,49 +104,40 @@ func colorize(img *image.NRGBA, col color.RGBA64) (*image.NRGBA, error) {
 	// Convert target color to HSL to get hue and saturation
 	targetH, targetS, targetL := rgbToHsl(targetR, targetG, targetB)
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := img.NRGBAAt(x, y)
-
-			// Convert pixel to normalized RGB
-			r := float64(c.R) / 255.0
-			g := float64(c.G) / 255.0
-			b := float64(c.B) / 255.0
+	desc := fmt.Sprintf("colorize(%d,%d,%d,%d)", col.R, col.G, col.B, col.A)
+	return pixelPass(img, []pixelOp{{desc, func(c rgb) rgb {
+		// Convert pixel to normalized RGB
+		r := c[0] / 255.0
+		g := c[1] / 255.0
+		b := c[2] / 255.0
 
-			// Convert original pixel to HSL
-			_, _, originalL := rgbToHsl(r, g, b)
+		// Convert original pixel to HSL
+		_, _, originalL := rgbToHsl(r, g, b)
 
-			// Calculate new luminance by blending original and target luminance
-			// This preserves the image's contrast while allowing some influence from target luminance
-			newL := originalL*(1-alpha*0.5) + targetL*(alpha*0.5)
+		// Calculate new luminance by blending original and target luminance
+		// This preserves the image's contrast while allowing some influence from target luminance
+		newL := originalL*(1-alpha*0.5) + targetL*(alpha*0.5)
 
-			// Calculate new saturation by blending original and target saturation
-			// Original saturation is calculated from the RGB values
-			originalS := calculateSaturation(r, g, b)
-			newS := originalS*(1-alpha) + targetS*alpha
+		// Calculate new saturation by blending original and target saturation
+		// Original saturation is calculated from the RGB values
+		originalS := calculateSaturation(r, g, b)
+		newS := originalS*(1-alpha) + targetS*alpha
 
-			// Convert back to RGB using the new HSL values
-			newR, newG, newB := hslToRgb(targetH, newS, newL)
+		// Convert back to RGB using the new HSL values
+		newR, newG, newB := hslToRgb(targetH, newS, newL)
 
-			// Blend with original color based on alpha
-			finalR := r*(1-alpha) + newR*alpha
-			finalG := g*(1-alpha) + newG*alpha
-			finalB := b*(1-alpha) + newB*alpha
+		// Blend with original color based on alpha
+		finalR := r*(1-alpha) + newR*alpha
+		finalG := g*(1-alpha) + newG*alpha
+		finalB := b*(1-alpha) + newB*alpha
 
-			// Ensure values are in valid range
-			finalR = math.Min(math.Max(finalR, 0), 1)
-			finalG = math.Min(math.Max(finalG, 0), 1)
-			finalB = math.Min(math.Max(finalB, 0), 1)
+		// Ensure values are in valid range
+		finalR = math.Min(math.Max(finalR, 0), 1)
+		finalG = math.Min(math.Max(finalG, 0), 1)
+		finalB = math.Min(math.Max(finalB, 0), 1)
 
-			colorized.Set(x, y, color.NRGBA{
-				R: uint8(finalR * 255),
-				G: uint8(finalG * 255),
-				B: uint8(finalB * 255),
-				A: c.A,
-			})
-		}
-	}
-	return colorized, nil
+		return rgb{finalR * 255, finalG * 255, finalB * 255}
+	}}}), nil
 }
 
 // Helper function to calculate saturation from RGB
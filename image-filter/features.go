@@ -0,0 +1,650 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+
+	"github.com/toxyl/math"
+)
+
+var (
+	// @Name:  feature-limit
+	// @Desc:  Maximum number of features returned by the detection functions, the strongest are kept, 0 returns all
+	// @Range: 0..
+	// @Unit:  -
+	featureLimit = 500
+
+	// @Name:  match-threshold
+	// @Desc:  Minimum score of a match returned by match-template, 1 is a perfect match
+	// @Range: 0..1
+	// @Unit:  -
+	matchThreshold = 0.9
+)
+
+const (
+	// harrisSigma is the standard deviation of the window over which harris-corners sums gradients
+	harrisSigma = 1.0
+
+	// nmsRadius is the radius of the neighborhood a corner must be the strongest in
+	nmsRadius = 2
+
+	// dogStep is the ratio between the scales of the Difference of Gaussians used by blob-detect
+	dogStep = 1.6
+
+	// maxEdgeRatio is the largest ratio of the principal curvatures of a blob, elongated responses along edges are dropped
+	maxEdgeRatio = 10.0
+)
+
+// fastCircle are the offsets of the 16 pixels on the circle of radius 3 tested by fast-corners
+var fastCircle = [16]image.Point{
+	{0, -3}, {1, -3}, {2, -2}, {3, -1}, {3, 0}, {3, 1}, {2, 2}, {1, 3},
+	{0, 3}, {-1, 3}, {-2, 2}, {-3, 1}, {-3, 0}, {-3, -1}, {-2, -2}, {-1, -3},
+}
+
+// feature is a detected corner, blob, line or template match in image coordinates
+type feature struct {
+	x, y       float64 // the corner, the center of a blob, the top-left corner of a match or the start of a line
+	x2, y2     float64 // the end of a line
+	r          float64 // the radius of a blob
+	w, h       float64 // the size of a match
+	rho, theta float64 // a line in normal form, theta in degrees
+	score      float64 // the strength of the feature, votes for lines
+}
+
+// featureList is the result of a detection function, the features are sorted by decreasing score
+type featureList struct {
+	kind  string // corners, blobs, lines or matches
+	items []feature
+}
+
+func (f *featureList) String() string {
+	round := func(v float64) float64 { return math.Round(v*1e4) / 1e4 }
+	items := make([]any, len(f.items))
+	for i, it := range f.items {
+		switch f.kind {
+		case "blobs":
+			items[i] = struct {
+				X     float64 `json:"x"`
+				Y     float64 `json:"y"`
+				R     float64 `json:"r"`
+				Score float64 `json:"score"`
+			}{round(it.x), round(it.y), round(it.r), round(it.score)}
+		case "lines":
+			items[i] = struct {
+				X1    float64 `json:"x1"`
+				Y1    float64 `json:"y1"`
+				X2    float64 `json:"x2"`
+				Y2    float64 `json:"y2"`
+				Rho   float64 `json:"rho"`
+				Theta float64 `json:"theta"`
+				Votes int     `json:"votes"`
+			}{round(it.x), round(it.y), round(it.x2), round(it.y2), round(it.rho), round(it.theta), int(it.score)}
+		case "matches":
+			items[i] = struct {
+				X     float64 `json:"x"`
+				Y     float64 `json:"y"`
+				W     float64 `json:"w"`
+				H     float64 `json:"h"`
+				Score float64 `json:"score"`
+			}{it.x, it.y, it.w, it.h, round(it.score)}
+		default:
+			items[i] = struct {
+				X     float64 `json:"x"`
+				Y     float64 `json:"y"`
+				Score float64 `json:"score"`
+			}{it.x, it.y, round(it.score)}
+		}
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// @Name: harris-corners
+// @Desc: Detects corners with the Harris detector, returns their coordinates as JSON, e.g. [{"x":10,"y":12,"score":0.5}, ...]
+// @Param:      img       - 	-   		-   	The image to search
+// @Param:      k         - 	0.0..0.25   0.04   	Sensitivity, smaller values detect more corners along edges
+// @Param:      threshold "%" 	0.0..1.0   	0.01   	Minimum response relative to the strongest corner
+// @Returns:    result    - 	-   		-   	The corners
+func harrisCorners(img image.Image, k, threshold float64) (*featureList, error) {
+	if k < 0 || k > 0.25 {
+		return nil, fmt.Errorf("k must be between 0 and 0.25")
+	}
+	if threshold < 0 || threshold > 1 {
+		return nil, fmt.Errorf("threshold must be between 0 and 1")
+	}
+	gray, w, h := grayPlane(img)
+	gx, gy := sobelGradients(gray, w, h)
+	ixx, iyy, ixy := make([]float64, w*h), make([]float64, w*h), make([]float64, w*h)
+	for i := range gx {
+		ixx[i], iyy[i], ixy[i] = gx[i]*gx[i], gy[i]*gy[i], gx[i]*gy[i]
+	}
+	ixx, iyy, ixy = gaussianPlane(ixx, w, h, harrisSigma), gaussianPlane(iyy, w, h, harrisSigma), gaussianPlane(ixy, w, h, harrisSigma)
+	response := make([]float64, w*h)
+	var peak float64
+	for i := range response {
+		tr := ixx[i] + iyy[i]
+		response[i] = ixx[i]*iyy[i] - ixy[i]*ixy[i] - k*tr*tr
+		peak = max(peak, response[i])
+	}
+	if peak <= 0 {
+		return &featureList{kind: "corners"}, nil
+	}
+	return pointFeatures(img.Bounds(), response, w, h, max(threshold*peak, 1e-12), peak), nil
+}
+
+// @Name: fast-corners
+// @Desc: Detects corners with the FAST-9 detector, a pixel is a corner if 9 contiguous pixels on a circle around it are all brighter or all darker, returns their coordinates as JSON
+// @Param:      img       - 	-   	-   	The image to search
+// @Param:      threshold - 	1..255  20   	How much brighter or darker the circle pixels must be
+// @Returns:    result    - 	-   	-   	The corners
+func fastCorners(img image.Image, threshold int) (*featureList, error) {
+	if threshold < 1 || threshold > 255 {
+		return nil, fmt.Errorf("threshold must be between 1 and 255")
+	}
+	gray, w, h := grayPlane(img)
+	t := float64(threshold) / 255
+	score := make([]float64, w*h)
+	var peak float64
+	var diff [16]float64
+	for y := 3; y < h-3; y++ {
+		for x := 3; x < w-3; x++ {
+			c := gray[y*w+x]
+			for i, o := range fastCircle {
+				diff[i] = gray[(y+o.Y)*w+x+o.X] - c
+			}
+			if s := fastScore(diff, t); s > 0 {
+				score[y*w+x] = s
+				peak = max(peak, s)
+			}
+		}
+	}
+	return pointFeatures(img.Bounds(), score, w, h, 1e-12, max(peak, 1e-12)), nil
+}
+
+// @Name: blob-detect
+// @Desc: Detects bright and dark blobs with a Difference of Gaussians (an approximation of the Laplacian of Gaussian), returns their centers and radii as JSON
+// @Param:      img       - 	-   		-   	The image to search
+// @Param:      min-sigma px 	0..   		2   	Scale of the smallest blobs, the radius is about 1.4 times the scale
+// @Param:      max-sigma px 	0..   		16   	Scale of the largest blobs
+// @Param:      threshold - 	0.0..1.0   	0.05   	Minimum contrast of a blob
+// @Returns:    result    - 	-   		-   	The blobs
+func blobDetect(img image.Image, minSigma, maxSigma, threshold float64) (*featureList, error) {
+	if minSigma <= 0 || maxSigma < minSigma {
+		return nil, fmt.Errorf("sigmas must be greater than 0 and min-sigma must not exceed max-sigma")
+	}
+	gray, w, h := grayPlane(img)
+	// the scales extend one step beyond the range on both ends, so every scale in the range has neighbors
+	var sigmas []float64
+	for s := minSigma / dogStep; s <= maxSigma*dogStep*dogStep; s *= dogStep {
+		sigmas = append(sigmas, s)
+	}
+	blurred := make([][]float64, len(sigmas))
+	for i, s := range sigmas {
+		blurred[i] = gaussianPlane(gray, w, h, s)
+	}
+	dog := make([][]float64, len(sigmas)-1)
+	for i := range dog {
+		dog[i] = make([]float64, w*h)
+		for j := range dog[i] {
+			dog[i][j] = (blurred[i+1][j] - blurred[i][j]) / (dogStep - 1)
+		}
+	}
+
+	var blobs []feature
+	for s := 1; s < len(dog)-1; s++ {
+		sigma := sigmas[s] * math.Sqrt(dogStep)
+		if sigma < minSigma*0.999 || sigma > maxSigma*1.001 {
+			continue
+		}
+		for y := 1; y < h-1; y++ {
+			for x := 1; x < w-1; x++ {
+				v := dog[s][y*w+x]
+				if math.Abs(v) < threshold || !isScaleExtremum(dog, s, x, y, w) || isEdgeResponse(dog[s], x, y, w) {
+					continue
+				}
+				blobs = append(blobs, feature{
+					x:     float64(x + img.Bounds().Min.X),
+					y:     float64(y + img.Bounds().Min.Y),
+					r:     sigma * math.Sqrt2,
+					score: math.Abs(v),
+				})
+			}
+		}
+	}
+	sortFeatures(blobs)
+	// blobs found at several scales are kept at the strongest one
+	var kept []feature
+	for _, b := range blobs {
+		overlaps := false
+		for _, k := range kept {
+			if math.Hypot(b.x-k.x, b.y-k.y) < max(b.r, k.r) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, b)
+		}
+	}
+	return &featureList{kind: "blobs", items: limitFeatures(kept)}, nil
+}
+
+// @Name: hough-lines
+// @Desc: Detects straight lines with the Hough transform of the edges, returns their end points at the image border and normal form (rho, theta in degrees) as JSON
+// @Param:      img       	   - 	-   		-   	The image to search
+// @Param:      edge-threshold "%" 	0.0..1.0   	0.3   	Minimum gradient of an edge pixel relative to the strongest gradient
+// @Param:      min-votes      - 	1..   		50   	Minimum number of edge pixels on a line
+// @Returns:    result    	   - 	-   		-   	The lines
+func houghLines(img image.Image, edgeThreshold float64, minVotes int) (*featureList, error) {
+	if edgeThreshold < 0 || edgeThreshold > 1 {
+		return nil, fmt.Errorf("edge-threshold must be between 0 and 1")
+	}
+	if minVotes < 1 {
+		return nil, fmt.Errorf("min-votes must be at least 1")
+	}
+	gray, w, h := grayPlane(img)
+	mag := sobelMagnitude(gray, w, h)
+	var peak float64
+	for _, v := range mag {
+		peak = max(peak, v)
+	}
+	const thetas = 180
+	var sin, cos [thetas]float64
+	for t := range thetas {
+		sin[t], cos[t] = math.Sin(float64(t)*math.Pi/thetas), math.Cos(float64(t)*math.Pi/thetas)
+	}
+	diag := int(math.Ceil(math.Hypot(float64(w), float64(h))))
+	rhos := 2*diag + 1
+	acc := make([]float64, thetas*rhos)
+	if peak > 0 {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if mag[y*w+x] < max(edgeThreshold*peak, 1e-12) {
+					continue
+				}
+				for t := range thetas {
+					r := int(math.Round(float64(x)*cos[t]+float64(y)*sin[t])) + diag
+					acc[r*thetas+t]++
+				}
+			}
+		}
+	}
+
+	var lines []feature
+	for _, i := range localMaxima(acc, thetas, rhos, nmsRadius, float64(minVotes)) {
+		t, rho := i%thetas, float64(i/thetas-diag)
+		x1, y1, x2, y2, ok := clipLine(rho, cos[t], sin[t], w, h)
+		if !ok {
+			continue
+		}
+		o := img.Bounds().Min
+		lines = append(lines, feature{
+			x: x1 + float64(o.X), y: y1 + float64(o.Y), x2: x2 + float64(o.X), y2: y2 + float64(o.Y),
+			rho: rho, theta: float64(t), score: acc[i],
+		})
+	}
+	sortFeatures(lines)
+	return &featureList{kind: "lines", items: limitFeatures(lines)}, nil
+}
+
+// @Name: match-template
+// @Desc: Finds the places where a template occurs in an image, e.g. a logo or button in a screenshot, returns the matches with a score of at least match-threshold as JSON
+// @Param:      img     - 	-   	-   	The image to search
+// @Param:      tmpl    - 	-   	-   	The template to find
+// @Param:      method  - 	-   	"ncc"   The score: ncc (normalized cross-correlation, robust against brightness and contrast changes) or ssd (1 minus the root mean square difference)
+// @Returns:    result  - 	-   	-   	The matches
+func matchTemplate(img image.Image, tmpl image.Image, method string) (*featureList, error) {
+	method = strings.ToLower(method)
+	if method != "ncc" && method != "ssd" {
+		return nil, fmt.Errorf("unknown method %q, use ncc or ssd", method)
+	}
+	ip, tp := newChannelPlanes(img), newChannelPlanes(tmpl)
+	w, h, tw, th := ip.w, ip.h, tp.w, tp.h
+	if tw == 0 || th == 0 || tw > w || th > h {
+		return nil, fmt.Errorf("template (%dx%d) must not be empty or larger than the image (%dx%d)", tw, th, w, h)
+	}
+	n := float64(tw * th)
+	ow, oh := w-tw+1, h-th+1 // the top-left corners at which the template fits
+
+	// windowed sums over all channels: squared sums divided by n, sums of squares and image times template
+	sqSum, sumSq, cross := make([]float64, ow*oh), make([]float64, ow*oh), make([]float64, ow*oh)
+	var tmplSq, tmplVar float64
+	for ch := range ip.c {
+		t := tp.c[ch]
+		var tmean float64
+		for _, v := range t {
+			tmean += v
+		}
+		tmean /= n
+		k := make([]float64, len(t))
+		for i, v := range t {
+			k[i] = v
+			if method == "ncc" {
+				k[i] = v - tmean
+			}
+			tmplSq += v * v
+			tmplVar += (v - tmean) * (v - tmean)
+		}
+		corr := planeConvolver(w, h, k, tw, th)(ip.c[ch])
+		s := windowSums(ip.c[ch], w, h, tw, th)
+		s2 := windowSums(multiplyPlanes(ip.c[ch], ip.c[ch]), w, h, tw, th)
+		for y := 0; y < oh; y++ {
+			for x := 0; x < ow; x++ {
+				i := y*ow + x
+				cross[i] += corr[(y+th/2)*w+x+tw/2]
+				if method == "ncc" {
+					// the variance of every channel is summed, so flat channels (e.g. alpha) don't matter
+					sqSum[i] += s[i] * s[i] / n
+				}
+				sumSq[i] += s2[i]
+			}
+		}
+	}
+	if method == "ncc" && tmplVar < 1e-12 {
+		return nil, fmt.Errorf("template has no contrast, use the ssd method")
+	}
+
+	score := make([]float64, ow*oh)
+	for i := range score {
+		if method == "ncc" {
+			if v := sumSq[i] - sqSum[i]; v > 1e-12 {
+				score[i] = cross[i] / math.Sqrt(v*tmplVar)
+			}
+		} else {
+			ssd := max(sumSq[i]-2*cross[i]+tmplSq, 0)
+			score[i] = 1 - math.Sqrt(ssd/(n*float64(len(ip.c))))
+		}
+	}
+
+	var matches []feature
+	o := img.Bounds().Min
+	for _, i := range localMaxima(score, ow, oh, 1, matchThreshold) {
+		matches = append(matches, feature{
+			x: float64(i%ow + o.X), y: float64(i/ow + o.Y), w: float64(tw), h: float64(th), score: score[i],
+		})
+	}
+	sortFeatures(matches)
+	// overlapping matches are the same occurrence
+	var kept []feature
+	for _, m := range matches {
+		overlaps := false
+		for _, k := range kept {
+			if math.Abs(m.x-k.x) < m.w/2 && math.Abs(m.y-k.y) < m.h/2 {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, m)
+		}
+	}
+	return &featureList{kind: "matches", items: limitFeatures(kept)}, nil
+}
+
+// @Name: feature-count
+// @Desc: Returns the number of detected features
+// @Param:      features  - 	-   	-   The features
+// @Returns:    result    - 	-   	-   The number of features
+func featureCount(features *featureList) (int, error) {
+	return len(features.items), nil
+}
+
+// @Name: draw-features
+// @Desc: Draws detected features onto a copy of the image for debugging: corners as small circles, blobs as circles of their radius, lines and match rectangles
+// @Param:      img       - 	-   	-   The image to draw on
+// @Param:      features  - 	-   	-   The features
+// @Param:      width     px 	0..   	1   The stroke width
+// @Param:      col       - 	-   	-   The stroke color
+// @Returns:    result    - 	-   	-   The image with the features drawn
+func drawFeatures(img *image.NRGBA, features *featureList, width float64, col color.RGBA64) (*image.NRGBA, error) {
+	var paths []subpath
+	for _, f := range features.items {
+		switch features.kind {
+		case "blobs":
+			paths = append(paths, subpath{points: ellipsePoints(f.x+0.5, f.y+0.5, f.r, f.r), closed: true})
+		case "lines":
+			paths = append(paths, subpath{points: []point{{f.x + 0.5, f.y + 0.5}, {f.x2 + 0.5, f.y2 + 0.5}}})
+		case "matches":
+			paths = append(paths, subpath{points: []point{{f.x, f.y}, {f.x + f.w, f.y}, {f.x + f.w, f.y + f.h}, {f.x, f.y + f.h}}, closed: true})
+		default:
+			paths = append(paths, subpath{points: ellipsePoints(f.x+0.5, f.y+0.5, 3, 3), closed: true})
+		}
+	}
+	return drawShape(img, paths, width, col, color.RGBA64{})
+}
+
+// Helper function to get the luminance of an image on a 0..1 scale, row by row
+func grayPlane(img image.Image) ([]float64, int, int) {
+	plane := luminancePlane(toNRGBA(img))
+	for i := range plane {
+		plane[i] /= 255
+	}
+	return plane, img.Bounds().Dx(), img.Bounds().Dy()
+}
+
+// Helper function to compute the horizontal and vertical Sobel gradients of a plane, edges are clamped
+func sobelGradients(plane []float64, w, h int) ([]float64, []float64) {
+	at := func(x, y int) float64 {
+		return plane[min(max(y, 0), h-1)*w+min(max(x, 0), w-1)]
+	}
+	gx, gy := make([]float64, w*h), make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx[y*w+x] = at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy[y*w+x] = at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+		}
+	}
+	return gx, gy
+}
+
+// Helper function to blur a plane with a separable Gaussian kernel, edges are mirrored
+func gaussianPlane(plane []float64, w, h int, sigma float64) []float64 {
+	r := int(math.Ceil(sigma * 3))
+	k := make([]float64, 2*r+1)
+	var sum float64
+	for i := range k {
+		d := float64(i - r)
+		k[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	tmp := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var s float64
+			for i, kv := range k {
+				s += kv * plane[y*w+mirrorIndex(x+i-r, w)]
+			}
+			tmp[y*w+x] = s
+		}
+	}
+	res := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var s float64
+			for i, kv := range k {
+				s += kv * tmp[mirrorIndex(y+i-r, h)*w+x]
+			}
+			res[y*w+x] = s
+		}
+	}
+	return res
+}
+
+// Helper function to compute the sums of all tw x th windows that fit into a plane,
+// indexed by the top-left corner of the window
+func windowSums(plane []float64, w, h, tw, th int) []float64 {
+	stride := w + 1
+	sat := make([]float64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := (y+1)*stride + x + 1
+			sat[i] = plane[y*w+x] + sat[i-1] + sat[i-stride] - sat[i-stride-1]
+		}
+	}
+	ow, oh := w-tw+1, h-th+1
+	res := make([]float64, ow*oh)
+	for y := 0; y < oh; y++ {
+		for x := 0; x < ow; x++ {
+			res[y*ow+x] = sat[(y+th)*stride+x+tw] - sat[y*stride+x+tw] - sat[(y+th)*stride+x] + sat[y*stride+x]
+		}
+	}
+	return res
+}
+
+// Helper function to find the indices of values that are at least min and the largest in their
+// (2r+1) x (2r+1) neighborhood, of equal values only the first one in row-major order is kept
+func localMaxima(values []float64, w, h, r int, threshold float64) []int {
+	var res []int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := values[y*w+x]
+			if v < threshold {
+				continue
+			}
+			isMax := true
+			for dy := -r; dy <= r && isMax; dy++ {
+				for dx := -r; dx <= r; dx++ {
+					nx, ny := x+dx, y+dy
+					if (dx == 0 && dy == 0) || nx < 0 || ny < 0 || nx >= w || ny >= h {
+						continue
+					}
+					n := values[ny*w+nx]
+					if n > v || (n == v && ny*w+nx < y*w+x) {
+						isMax = false
+						break
+					}
+				}
+			}
+			if isMax {
+				res = append(res, y*w+x)
+			}
+		}
+	}
+	return res
+}
+
+// Helper function to turn a corner response into corners, scores are relative to the peak response
+func pointFeatures(b image.Rectangle, response []float64, w, h int, threshold, peak float64) *featureList {
+	var corners []feature
+	for _, i := range localMaxima(response, w, h, nmsRadius, threshold) {
+		corners = append(corners, feature{
+			x: float64(i%w + b.Min.X), y: float64(i/w + b.Min.Y), score: response[i] / peak,
+		})
+	}
+	sortFeatures(corners)
+	return &featureList{kind: "corners", items: limitFeatures(corners)}
+}
+
+// Helper function to score a FAST candidate from the differences of the circle pixels to the center,
+// returns the summed excess contrast of the longest brighter or darker arc if it has at least 9 pixels, otherwise 0
+func fastScore(diff [16]float64, t float64) float64 {
+	var best float64
+	for _, sign := range []float64{1, -1} {
+		run := 0
+		var sum float64
+		// the circle is walked twice so arcs that wrap around are found
+		for i := 0; i < 32 && run < 16; i++ {
+			d := sign * diff[i%16]
+			if d > t {
+				run++
+				sum += d - t
+				if run >= 9 {
+					best = max(best, sum)
+				}
+				continue
+			}
+			if i >= 16 {
+				break
+			}
+			run, sum = 0, 0
+		}
+	}
+	return best
+}
+
+// Helper function to report whether a DoG value is larger or smaller than all 26 neighbors in space and scale
+func isScaleExtremum(dog [][]float64, s, x, y, w int) bool {
+	v := dog[s][y*w+x]
+	for ds := -1; ds <= 1; ds++ {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if ds == 0 && dy == 0 && dx == 0 {
+					continue
+				}
+				n := dog[s+ds][(y+dy)*w+x+dx]
+				if (v > 0 && n >= v) || (v < 0 && n <= v) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Helper function to report whether a DoG extremum lies on an edge rather than a blob,
+// i.e. it curves much more across than along (Lowe's test on the Hessian)
+func isEdgeResponse(dog []float64, x, y, w int) bool {
+	v := dog[y*w+x]
+	dxx := dog[y*w+x+1] + dog[y*w+x-1] - 2*v
+	dyy := dog[(y+1)*w+x] + dog[(y-1)*w+x] - 2*v
+	dxy := (dog[(y+1)*w+x+1] - dog[(y+1)*w+x-1] - dog[(y-1)*w+x+1] + dog[(y-1)*w+x-1]) / 4
+	tr, det := dxx+dyy, dxx*dyy-dxy*dxy
+	return det <= 0 || tr*tr/det >= (maxEdgeRatio+1)*(maxEdgeRatio+1)/maxEdgeRatio
+}
+
+// Helper function to clip the line x*cos + y*sin = rho to a w x h image,
+// returns false if the line doesn't cross the image
+func clipLine(rho, cos, sin float64, w, h int) (x1, y1, x2, y2 float64, ok bool) {
+	maxX, maxY := float64(w-1), float64(h-1)
+	var pts []point
+	if math.Abs(sin) > 1e-9 {
+		for _, x := range []float64{0, maxX} {
+			if y := (rho - x*cos) / sin; y >= 0 && y <= maxY {
+				pts = append(pts, point{x, y})
+			}
+		}
+	}
+	if math.Abs(cos) > 1e-9 {
+		for _, y := range []float64{0, maxY} {
+			if x := (rho - y*sin) / cos; x >= 0 && x <= maxX {
+				pts = append(pts, point{x, y})
+			}
+		}
+	}
+	if len(pts) < 2 {
+		return 0, 0, 0, 0, false
+	}
+	// of the intersections with the borders, the two farthest apart are the ends
+	a, b := pts[0], pts[1]
+	for i := range pts {
+		for j := i + 1; j < len(pts); j++ {
+			if math.Hypot(pts[i].x-pts[j].x, pts[i].y-pts[j].y) > math.Hypot(a.x-b.x, a.y-b.y) {
+				a, b = pts[i], pts[j]
+			}
+		}
+	}
+	return a.x, a.y, b.x, b.y, true
+}
+
+// Helper function to sort features by decreasing score, ties are ordered by position
+func sortFeatures(f []feature) {
+	sort.SliceStable(f, func(i, j int) bool { return f[i].score > f[j].score })
+}
+
+// Helper function to keep the strongest features up to feature-limit
+func limitFeatures(f []feature) []feature {
+	if featureLimit > 0 && len(f) > featureLimit {
+		return f[:featureLimit]
+	}
+	return f
+}
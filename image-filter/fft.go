@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"math/cmplx"
+	"strings"
+
+	"github.com/toxyl/math"
+)
+
+var (
+	// @Name:  fft-kernel-size
+	// @Desc:  Kernel width or height from which convolve and gaussian-blur switch from direct convolution to the FFT
+	// @Range: 1..
+	// @Unit:  px
+	fftKernelSize = 15
+)
+
+// spectrum is the 2D Fourier transform of the premultiplied channels of an image.
+// The image is mirrored at its edges to a power of two size before the transform.
+type spectrum struct {
+	src    image.Image // the transformed image, ifft returns an image of the same kind
+	pw, ph int         // size of the transform
+	c      [4][]complex128
+}
+
+func (s *spectrum) String() string {
+	b := s.src.Bounds()
+	return fmt.Sprintf("%dx%d spectrum of a %dx%d image", s.pw, s.ph, b.Dx(), b.Dy())
+}
+
+// @Name: fft
+// @Desc: Transforms an image into the frequency domain, use fft-magnitude and fft-phase to view the result and ifft to transform it back
+// @Param:      img     - 	-   	-   The image to transform
+// @Returns:    result  - 	-   	-   The spectrum
+func fft(img image.Image) (*spectrum, error) {
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("image is empty")
+	}
+	p := newChannelPlanes(img)
+	s := &spectrum{src: img, pw: nextPow2(p.w), ph: nextPow2(p.h)}
+	for ch, plane := range p.c {
+		s.c[ch] = padPlane(plane, p.w, p.h, s.pw, s.ph)
+		fft2(s.c[ch], s.pw, s.ph, false)
+	}
+	return s, nil
+}
+
+// @Name: ifft
+// @Desc: Transforms a spectrum back into an image
+// @Param:      spec    - 	-   	-   The spectrum to transform
+// @Returns:    result  - 	-   	-   The image
+func ifft(spec *spectrum) (image.Image, error) {
+	p := newChannelPlanes(image.NewNRGBA(spec.src.Bounds()))
+	for ch, data := range spec.c {
+		data = append([]complex128(nil), data...)
+		fft2(data, spec.pw, spec.ph, true)
+		p.c[ch] = cropPlane(data, spec.pw, p.w, p.h)
+	}
+	return p.toImageLike(spec.src), nil
+}
+
+// @Name: fft-magnitude
+// @Desc: Returns the log-scaled magnitude of each color channel of a spectrum, the lowest frequencies are in the center
+// @Param:      spec    - 	-   	-   The spectrum
+// @Returns:    result  - 	-   	-   The magnitude image
+func fftMagnitude(spec *spectrum) (*image.NRGBA, error) {
+	var peak float64
+	for ch := 0; ch < 3; ch++ {
+		for _, v := range spec.c[ch] {
+			peak = max(peak, math.Log1p(cmplx.Abs(v)))
+		}
+	}
+	if peak == 0 {
+		peak = 1
+	}
+	return spec.toCenteredImage(func(v complex128) float64 { return math.Log1p(cmplx.Abs(v)) / peak }), nil
+}
+
+// @Name: fft-phase
+// @Desc: Returns the phase of each color channel of a spectrum, the lowest frequencies are in the center
+// @Param:      spec    - 	-   	-   The spectrum
+// @Returns:    result  - 	-   	-   The phase image
+func fftPhase(spec *spectrum) (*image.NRGBA, error) {
+	return spec.toCenteredImage(func(v complex128) float64 { return (cmplx.Phase(v) + math.Pi) / (2 * math.Pi) }), nil
+}
+
+// @Name: low-pass
+// @Desc: Removes high frequencies (fine detail and noise) from an image with a Butterworth filter in the frequency domain
+// @Param:      img     - 	-   	 	-   	The image to filter
+// @Param:      cutoff  "%" 0.0..1.0   	0.2   	The cutoff frequency as fraction of the highest frequency
+// @Returns:    result  - 	-   		-   	The filtered image
+func lowPass(img image.Image, cutoff float64) (image.Image, error) {
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("image is empty")
+	}
+	if cutoff <= 0 {
+		return nil, fmt.Errorf("cutoff must be greater than 0")
+	}
+	return filterFrequencies(img, func(d float64) float64 { return butterworth(d, cutoff) }, 0), nil
+}
+
+// @Name: high-pass
+// @Desc: Removes low frequencies (smooth areas) from an image with a Butterworth filter in the frequency domain, the result is centered on 50% gray
+// @Param:      img     - 	-   	 	-   	The image to filter
+// @Param:      cutoff  "%" 0.0..1.0   	0.05   	The cutoff frequency as fraction of the highest frequency
+// @Returns:    result  - 	-   		-   	The filtered image
+func highPass(img image.Image, cutoff float64) (image.Image, error) {
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("image is empty")
+	}
+	if cutoff <= 0 {
+		return nil, fmt.Errorf("cutoff must be greater than 0")
+	}
+	return filterFrequencies(img, func(d float64) float64 { return 1 - butterworth(d, cutoff) }, 0.5), nil
+}
+
+// @Name: band-reject
+// @Desc: Removes a band of frequencies from an image, e.g. the periodic pattern of halftone prints or moiré
+// @Param:      img     - 	-   	 	-   	The image to filter
+// @Param:      low     "%" 0.0..1.0   	0.3   	The lowest frequency of the band as fraction of the highest frequency
+// @Param:      high    "%" 0.0..1.0   	0.5   	The highest frequency of the band as fraction of the highest frequency
+// @Returns:    result  - 	-   		-   	The filtered image
+func bandReject(img image.Image, low, high float64) (image.Image, error) {
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("image is empty")
+	}
+	if low <= 0 || high <= low {
+		return nil, fmt.Errorf("low must be greater than 0 and high must be greater than low")
+	}
+	center, width := (low+high)/2, high-low
+	return filterFrequencies(img, func(d float64) float64 {
+		// second order Butterworth band-reject filter, 0 at the center of the band
+		r := d * width / (d*d - center*center)
+		return 1 / (1 + r*r*r*r)
+	}, 0), nil
+}
+
+// @Name: convolve
+// @Desc: Convolves an image with a kernel, large kernels (see fft-kernel-size) are applied in the frequency domain
+// @Param:      img     - 	-   	-   The image to convolve
+// @Param:      kernel  - 	-   	-   The kernel weights, rows are separated by semicolons, e.g. "0 -1 0; -1 5 -1; 0 -1 0"
+// @Returns:    result  - 	-   	-   The convolved image, weights are normalized unless they sum to 0
+func convolve(img image.Image, kernel string) (image.Image, error) {
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("image is empty")
+	}
+	k, kw, kh, err := parseKernel(kernel)
+	if err != nil {
+		return nil, err
+	}
+	return convolvePlanes(newChannelPlanes(img), k, kw, kh).toImageLike(img), nil
+}
+
+// @Name: gaussian-blur
+// @Desc: Blurs an image with a Gaussian kernel, large kernels (see fft-kernel-size) are applied in the frequency domain
+// @Param:      img     - 	-   	-   The image to blur
+// @Param:      sigma   px 	0..   	2   The standard deviation of the kernel
+// @Returns:    result  - 	-   	-   The blurred image
+func gaussianBlur(img image.Image, sigma float64) (image.Image, error) {
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("image is empty")
+	}
+	if sigma <= 0 {
+		return nil, fmt.Errorf("sigma must be greater than 0")
+	}
+	r := int(math.Ceil(sigma * 3))
+	size := 2*r + 1
+	k := make([]float64, size*size)
+	var sum float64
+	for y := -r; y <= r; y++ {
+		for x := -r; x <= r; x++ {
+			v := math.Exp(-float64(x*x+y*y) / (2 * sigma * sigma))
+			k[(y+r)*size+x+r] = v
+			sum += v
+		}
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return convolvePlanes(newChannelPlanes(img), k, size, size).toImageLike(img), nil
+}
+
+// Helper function to parse a kernel from rows separated by semicolons,
+// the weights are normalized to sum 1 unless they sum to 0
+func parseKernel(s string) (k []float64, w, h int, err error) {
+	for _, row := range strings.Split(strings.TrimSpace(s), ";") {
+		nums, err := parseNumbers(row)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if h > 0 && len(nums) != w {
+			return nil, 0, 0, fmt.Errorf("kernel row %d has %d weights, expected %d", h+1, len(nums), w)
+		}
+		w = len(nums)
+		k = append(k, nums...)
+		h++
+	}
+	if w%2 == 0 || h%2 == 0 {
+		return nil, 0, 0, fmt.Errorf("kernel must have an odd number of rows and columns, got %dx%d", w, h)
+	}
+	var sum float64
+	for _, v := range k {
+		sum += v
+	}
+	if math.Abs(sum) > 1e-9 {
+		for i := range k {
+			k[i] /= sum
+		}
+	}
+	return k, w, h, nil
+}
+
+// Helper function to convolve channel planes with a kernel, see planeConvolver
+func convolvePlanes(p *channelPlanes, k []float64, kw, kh int) *channelPlanes {
+	result := &channelPlanes{rect: p.rect, w: p.w, h: p.h}
+	if p.w == 0 || p.h == 0 {
+		return result
+	}
+	conv := planeConvolver(p.w, p.h, k, kw, kh)
+	for ch, plane := range p.c {
+		result.c[ch] = conv(plane)
+	}
+	return result
+}
+
+// Helper function to create a function that convolves w x h planes with a kernel, directly for small
+// kernels and by multiplication in the frequency domain for large ones. Both mirror the plane at the edges.
+func planeConvolver(w, h int, k []float64, kw, kh int) func(plane []float64) []float64 {
+	rx, ry := kw/2, kh/2
+
+	if kw < fftKernelSize && kh < fftKernelSize {
+		return func(plane []float64) []float64 {
+			out := make([]float64, len(plane))
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					var sum float64
+					for j := 0; j < kh; j++ {
+						row := mirrorIndex(y+j-ry, h) * w
+						for i := 0; i < kw; i++ {
+							sum += k[j*kw+i] * plane[row+mirrorIndex(x+i-rx, w)]
+						}
+					}
+					out[y*w+x] = sum
+				}
+			}
+			return out
+		}
+	}
+
+	// the padding must be at least as large as the kernel so the
+	// circular convolution only wraps into mirrored pixels
+	pw, ph := nextPow2(w+kw), nextPow2(h+kh)
+	kf := make([]complex128, pw*ph)
+	for j := 0; j < kh; j++ {
+		for i := 0; i < kw; i++ {
+			// the kernel is flipped so the result matches the direct path
+			x, y := (rx-i+pw)%pw, (ry-j+ph)%ph
+			kf[y*pw+x] = complex(k[j*kw+i], 0)
+		}
+	}
+	fft2(kf, pw, ph, false)
+	return func(plane []float64) []float64 {
+		data := padPlane(plane, w, h, pw, ph)
+		fft2(data, pw, ph, false)
+		for i := range data {
+			data[i] *= kf[i]
+		}
+		fft2(data, pw, ph, true)
+		return cropPlane(data, pw, w, h)
+	}
+}
+
+// Helper function to multiply the spectrum of an image with a filter that depends on the
+// distance d of a frequency to the origin, where 1 is the highest frequency along an axis.
+// offset is added to the color channels afterwards, scaled by alpha.
+func filterFrequencies(img image.Image, filter func(d float64) float64, offset float64) image.Image {
+	p := newChannelPlanes(img)
+	pw, ph := nextPow2(p.w), nextPow2(p.h)
+	h := make([]float64, pw*ph)
+	for v := 0; v < ph; v++ {
+		fv := float64(min(v, ph-v)) / float64(ph/2)
+		for u := 0; u < pw; u++ {
+			fu := float64(min(u, pw-u)) / float64(pw/2)
+			h[v*pw+u] = filter(math.Hypot(fu, fv))
+		}
+	}
+
+	result := &channelPlanes{rect: p.rect, w: p.w, h: p.h}
+	for ch, plane := range p.c {
+		if ch == 3 {
+			// filtering alpha would make the image partially transparent
+			result.c[ch] = plane
+			continue
+		}
+		data := padPlane(plane, p.w, p.h, pw, ph)
+		fft2(data, pw, ph, false)
+		for i := range data {
+			data[i] *= complex(h[i], 0)
+		}
+		fft2(data, pw, ph, true)
+		result.c[ch] = cropPlane(data, pw, p.w, p.h)
+		for i := range result.c[ch] {
+			result.c[ch][i] += offset * p.c[3][i]
+		}
+	}
+	return result.toImageLike(img)
+}
+
+// Helper function to get the gain of a second order Butterworth low-pass filter
+func butterworth(d, cutoff float64) float64 {
+	r := d / cutoff
+	return 1 / (1 + r*r*r*r)
+}
+
+// Helper function to convert each color channel of a spectrum to an image with
+// the zero frequency in the center, fn maps a coefficient to 0..1
+func (s *spectrum) toCenteredImage(fn func(v complex128) float64) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, s.pw, s.ph))
+	to8 := func(v float64) uint8 { return uint8(math.Round(math.Clamp(v, 0, 1) * 255)) }
+	for y := 0; y < s.ph; y++ {
+		for x := 0; x < s.pw; x++ {
+			i := ((y+s.ph/2)%s.ph)*s.pw + (x+s.pw/2)%s.pw
+			img.SetNRGBA(x, y, color.NRGBA{R: to8(fn(s.c[0][i])), G: to8(fn(s.c[1][i])), B: to8(fn(s.c[2][i])), A: 255})
+		}
+	}
+	return img
+}
+
+// Helper function to transform a row-major 2D array in place, the size must be a power of two in both directions
+func fft2(data []complex128, w, h int, inverse bool) {
+	for y := 0; y < h; y++ {
+		fft1(data[y*w:(y+1)*w], inverse)
+	}
+	col := make([]complex128, h)
+	for x := 0; x < w; x++ {
+		for y := range col {
+			col[y] = data[y*w+x]
+		}
+		fft1(col, inverse)
+		for y, v := range col {
+			data[y*w+x] = v
+		}
+	}
+}
+
+// Helper function to transform an array in place with the iterative radix-2 Cooley-Tukey algorithm,
+// the inverse transform is scaled by 1/n
+func fft1(a []complex128, inverse bool) {
+	n := len(a)
+	if n < 2 {
+		return
+	}
+	shift := 64 - bits.Len(uint(n-1))
+	for i := range a {
+		if j := int(bits.Reverse64(uint64(i)) >> shift); i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	twiddle := make([]complex128, n/2)
+	for k := range twiddle {
+		twiddle[k] = cmplx.Rect(1, sign*2*math.Pi*float64(k)/float64(n))
+	}
+	for size := 2; size <= n; size <<= 1 {
+		half, step := size/2, n/size
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				u, v := a[start+k], a[start+k+half]*twiddle[k*step]
+				a[start+k], a[start+k+half] = u+v, u-v
+			}
+		}
+	}
+	if inverse {
+		scale := complex(1/float64(n), 0)
+		for i := range a {
+			a[i] *= scale
+		}
+	}
+}
+
+// Helper function to copy a plane into a larger complex array, the border is filled by mirroring
+// the plane such that the array also continues smoothly where it wraps around
+func padPlane(plane []float64, w, h, pw, ph int) []complex128 {
+	data := make([]complex128, pw*ph)
+	for y := 0; y < ph; y++ {
+		row := wrapMirrorIndex(y, h, ph) * w
+		for x := 0; x < pw; x++ {
+			data[y*pw+x] = complex(plane[row+wrapMirrorIndex(x, w, pw)], 0)
+		}
+	}
+	return data
+}
+
+// Helper function to copy the real part of the top left w x h area of a complex array into a plane
+func cropPlane(data []complex128, pw, w, h int) []float64 {
+	plane := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			plane[y*w+x] = real(data[y*pw+x])
+		}
+	}
+	return plane
+}
+
+// Helper function to map an index of the padded size n to the source size w: the first half of the
+// padding mirrors the end of the source, the second half mirrors its start
+func wrapMirrorIndex(i, w, n int) int {
+	if i < w {
+		return i
+	}
+	if i-w < n-i {
+		return mirrorIndex(i, w)
+	}
+	return mirrorIndex(i-n, w)
+}
+
+// Helper function to mirror an index at the edges of 0..w-1, repeating the edge pixel.
+// There is nothing to mirror at if w is 0, callers must not index empty planes.
+func mirrorIndex(i, w int) int {
+	if w <= 0 {
+		return 0
+	}
+	for i < 0 || i >= w {
+		if i < 0 {
+			i = -i - 1
+		} else {
+			i = 2*w - i - 1
+		}
+	}
+	return i
+}
+
+// Helper function to get the smallest power of two that is at least n
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	stdmath "math"
+	"strconv"
+	"strings"
+
+	"github.com/toxyl/math"
+)
+
+// maxImagePixels is the largest number of pixels a decoded image may have, it protects against
+// headers that claim huge sizes
+const maxImagePixels = 400_000_000
+
+// Helper function to report whether an image has 16 bits per channel or more
+func isDeepImage(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16, *floatImage:
+		return true
+	}
+	return false
+}
+
+// Helper function to decode a Netpbm image (PBM, PGM, PPM in plain or raw form, and PAM).
+// Images with a maximum value above 255 are decoded as NRGBA64, all others as NRGBA.
+func decodeNetpbm(data []byte) (image.Image, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	magic, err := netpbmToken(r)
+	if err != nil {
+		return nil, err
+	}
+	var w, h, depth, maxval int
+	plain := false
+	switch magic {
+	case "P1", "P2", "P3":
+		plain = true
+		fallthrough
+	case "P4", "P5", "P6":
+		depth = map[string]int{"P1": 1, "P2": 1, "P3": 3, "P4": 1, "P5": 1, "P6": 3}[magic]
+		fields := []*int{&w, &h, &maxval}
+		if magic == "P1" || magic == "P4" {
+			fields, maxval = fields[:2], 1
+		}
+		for _, f := range fields {
+			if *f, err = netpbmInt(r); err != nil {
+				return nil, err
+			}
+		}
+	case "P7":
+		if w, h, depth, maxval, err = readPAMHeader(r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown Netpbm format %q", magic)
+	}
+	if w <= 0 || h <= 0 || depth < 1 || depth > 4 || maxval < 1 || maxval > 65535 || uint64(w)*uint64(h) > maxImagePixels {
+		return nil, fmt.Errorf("invalid Netpbm header: %dx%d, depth %d, maxval %d", w, h, depth, maxval)
+	}
+	// plain samples need at least one byte each, raw samples one or two, raw PBM packs 8 pixels into a byte
+	minSize := w * h * depth
+	switch {
+	case magic == "P4":
+		minSize = (w + 7) / 8 * h
+	case !plain && maxval > 255:
+		minSize *= 2
+	}
+	if len(data) < minSize {
+		return nil, fmt.Errorf("truncated Netpbm raster: %dx%d needs at least %d bytes", w, h, minSize)
+	}
+
+	samples := make([]int, w*h*depth)
+	switch {
+	case magic == "P4":
+		stride := (w + 7) / 8
+		row := make([]byte, stride)
+		for y := 0; y < h; y++ {
+			if _, err := io.ReadFull(r, row); err != nil {
+				return nil, err
+			}
+			for x := 0; x < w; x++ {
+				samples[y*w+x] = int(row[x/8]>>(7-x%8)) & 1
+			}
+		}
+	case plain:
+		for i := range samples {
+			if magic == "P1" {
+				// plain PBM samples don't need to be separated
+				b, err := netpbmSkipSpace(r)
+				if err != nil {
+					return nil, err
+				}
+				samples[i] = int(b - '0')
+				continue
+			}
+			if samples[i], err = netpbmInt(r); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		size := 1
+		if maxval > 255 {
+			size = 2
+		}
+		raster := make([]byte, len(samples)*size)
+		if _, err := io.ReadFull(r, raster); err != nil {
+			return nil, fmt.Errorf("truncated Netpbm raster: %w", err)
+		}
+		for i := range samples {
+			if size == 2 {
+				samples[i] = int(binary.BigEndian.Uint16(raster[i*2:]))
+			} else {
+				samples[i] = int(raster[i])
+			}
+		}
+	}
+	if magic == "P1" || magic == "P4" {
+		// in PBM images 1 is black
+		for i := range samples {
+			samples[i] = 1 - samples[i]
+		}
+	}
+
+	if maxval > 255 {
+		img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+		scale := func(v int) uint16 { return uint16(math.Round(float64(min(v, maxval)) * 65535 / float64(maxval))) }
+		for i := 0; i < w*h; i++ {
+			c := netpbmColor(samples[i*depth:(i+1)*depth], maxval)
+			img.SetNRGBA64(i%w, i/w, color.NRGBA64{R: scale(c[0]), G: scale(c[1]), B: scale(c[2]), A: scale(c[3])})
+		}
+		return img, nil
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	scale := func(v int) uint8 { return uint8(math.Round(float64(min(v, maxval)) * 255 / float64(maxval))) }
+	for i := 0; i < w*h; i++ {
+		c := netpbmColor(samples[i*depth:(i+1)*depth], maxval)
+		img.SetNRGBA(i%w, i/w, color.NRGBA{R: scale(c[0]), G: scale(c[1]), B: scale(c[2]), A: scale(c[3])})
+	}
+	return img, nil
+}
+
+// Helper function to expand a tuple of 1 to 4 samples (gray, gray + alpha, RGB, RGB + alpha) to RGBA
+func netpbmColor(t []int, maxval int) [4]int {
+	switch len(t) {
+	case 1:
+		return [4]int{t[0], t[0], t[0], maxval}
+	case 2:
+		return [4]int{t[0], t[0], t[0], t[1]}
+	case 3:
+		return [4]int{t[0], t[1], t[2], maxval}
+	}
+	return [4]int{t[0], t[1], t[2], t[3]}
+}
+
+// Helper function to read the header of a PAM image, the magic number has already been read
+func readPAMHeader(r *bufio.Reader) (w, h, depth, maxval int, err error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("truncated PAM header: %w", err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			return w, h, depth, maxval, nil
+		}
+		if fields[0] == "TUPLTYPE" || len(fields) < 2 {
+			// the tuple type is implied by the depth
+			continue
+		}
+		v, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("invalid PAM header line %q", strings.TrimSpace(line))
+		}
+		switch fields[0] {
+		case "WIDTH":
+			w = v
+		case "HEIGHT":
+			h = v
+		case "DEPTH":
+			depth = v
+		case "MAXVAL":
+			maxval = v
+		}
+	}
+}
+
+// Helper function to skip whitespace and comments in a Netpbm header, returns the first other byte
+func netpbmSkipSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case b == '#':
+			if _, err := r.ReadString('\n'); err != nil {
+				return 0, err
+			}
+		case b != ' ' && b != '\t' && b != '\n' && b != '\r' && b != '\v' && b != '\f':
+			return b, nil
+		}
+	}
+}
+
+// Helper function to read the next whitespace separated token of a Netpbm header
+func netpbmToken(r *bufio.Reader) (string, error) {
+	b, err := netpbmSkipSpace(r)
+	if err != nil {
+		return "", err
+	}
+	tok := []byte{b}
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return string(tok), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f' {
+			// the separator is consumed, so a raster can follow directly
+			return string(tok), nil
+		}
+		tok = append(tok, b)
+	}
+}
+
+// Helper function to read the next integer of a Netpbm header or plain raster
+func netpbmInt(r *bufio.Reader) (int, error) {
+	tok, err := netpbmToken(r)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(tok)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Netpbm number %q", tok)
+	}
+	return v, nil
+}
+
+// Helper function to encode an image as raw PBM (black and white), PGM (gray), PPM (RGB) or PAM (RGB + alpha).
+// 16 bit images are written with 16 bits per sample.
+func encodeNetpbm(w io.Writer, img image.Image, format string) error {
+	b := img.Bounds()
+	maxval := 255
+	if isDeepImage(img) {
+		maxval = 65535
+	}
+	bw := bufio.NewWriter(w)
+	if format == "pbm" {
+		// pixels darker than middle gray are black
+		fmt.Fprintf(bw, "P4\n%d %d\n", b.Dx(), b.Dy())
+		row := make([]byte, (b.Dx()+7)/8)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			clear(row)
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y < 0x8000 {
+					i := x - b.Min.X
+					row[i/8] |= 0x80 >> (i % 8)
+				}
+			}
+			bw.Write(row)
+		}
+		return bw.Flush()
+	}
+	switch format {
+	case "pgm":
+		fmt.Fprintf(bw, "P5\n%d %d\n%d\n", b.Dx(), b.Dy(), maxval)
+	case "pam":
+		fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\nMAXVAL %d\nTUPLTYPE RGB_ALPHA\nENDHDR\n", b.Dx(), b.Dy(), maxval)
+	default:
+		fmt.Fprintf(bw, "P6\n%d %d\n%d\n", b.Dx(), b.Dy(), maxval)
+	}
+	sample := func(v uint16) {
+		bw.WriteByte(byte(v >> 8))
+		if maxval > 255 {
+			bw.WriteByte(byte(v))
+		}
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			switch format {
+			case "pgm":
+				sample(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)
+			case "pam":
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				sample(c.R)
+				sample(c.G)
+				sample(c.B)
+				sample(c.A)
+			default:
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				sample(c.R)
+				sample(c.G)
+				sample(c.B)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+const (
+	qoiOpIndex = 0x00
+	qoiOpDiff  = 0x40
+	qoiOpLuma  = 0x80
+	qoiOpRun   = 0xc0
+	qoiOpRGB   = 0xfe
+	qoiOpRGBA  = 0xff
+	qoiMask2   = 0xc0
+)
+
+var qoiEnd = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+// Helper function to get the position of a color in the QOI color index
+func qoiHash(c color.NRGBA) int {
+	return (int(c.R)*3 + int(c.G)*5 + int(c.B)*7 + int(c.A)*11) % 64
+}
+
+// Helper function to decode a QOI image
+func decodeQOI(data []byte) (*image.NRGBA, error) {
+	if len(data) < 14+len(qoiEnd) || string(data[:4]) != "qoif" {
+		return nil, fmt.Errorf("not a QOI image")
+	}
+	w, h := int(binary.BigEndian.Uint32(data[4:])), int(binary.BigEndian.Uint32(data[8:]))
+	if w == 0 || h == 0 || uint64(w)*uint64(h) > maxImagePixels {
+		return nil, fmt.Errorf("invalid QOI image size %dx%d", w, h)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	var index [64]color.NRGBA
+	px := color.NRGBA{A: 255}
+	p, end := 14, len(data)-len(qoiEnd)
+	run := 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		if run > 0 {
+			run--
+		} else if p < end {
+			b := data[p]
+			p++
+			switch {
+			case b == qoiOpRGB:
+				if p+3 > end {
+					return nil, fmt.Errorf("truncated QOI data")
+				}
+				px.R, px.G, px.B = data[p], data[p+1], data[p+2]
+				p += 3
+			case b == qoiOpRGBA:
+				if p+4 > end {
+					return nil, fmt.Errorf("truncated QOI data")
+				}
+				px = color.NRGBA{R: data[p], G: data[p+1], B: data[p+2], A: data[p+3]}
+				p += 4
+			case b&qoiMask2 == qoiOpIndex:
+				px = index[b]
+			case b&qoiMask2 == qoiOpDiff:
+				px.R += (b>>4)&3 - 2
+				px.G += (b>>2)&3 - 2
+				px.B += b&3 - 2
+			case b&qoiMask2 == qoiOpLuma:
+				if p >= end {
+					return nil, fmt.Errorf("truncated QOI data")
+				}
+				b2 := data[p]
+				p++
+				dg := b&0x3f - 32
+				px.R += dg + (b2>>4)&0x0f - 8
+				px.G += dg
+				px.B += dg + b2&0x0f - 8
+			case b&qoiMask2 == qoiOpRun:
+				run = int(b & 0x3f)
+			}
+			index[qoiHash(px)] = px
+		}
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = px.R, px.G, px.B, px.A
+	}
+	return img, nil
+}
+
+// Helper function to encode an image as QOI with 4 channels
+func encodeQOI(w io.Writer, img *image.NRGBA) error {
+	b := img.Bounds()
+	out := make([]byte, 14, 14+b.Dx()*b.Dy()+len(qoiEnd))
+	copy(out, "qoif")
+	binary.BigEndian.PutUint32(out[4:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(out[8:], uint32(b.Dy()))
+	out[12], out[13] = 4, 0 // RGBA, sRGB with linear alpha
+
+	var index [64]color.NRGBA
+	prev := color.NRGBA{A: 255}
+	run := 0
+	forEachNRGBA(img, func(px color.NRGBA) {
+		if px == prev {
+			run++
+			if run == 62 {
+				out = append(out, qoiOpRun|byte(run-1))
+				run = 0
+			}
+			return
+		}
+		if run > 0 {
+			out = append(out, qoiOpRun|byte(run-1))
+			run = 0
+		}
+		h := qoiHash(px)
+		switch {
+		case index[h] == px:
+			out = append(out, qoiOpIndex|byte(h))
+		case px.A == prev.A:
+			dr, dg, db := int8(px.R-prev.R), int8(px.G-prev.G), int8(px.B-prev.B)
+			drdg, dbdg := dr-dg, db-dg
+			switch {
+			case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+				out = append(out, qoiOpDiff|byte(dr+2)<<4|byte(dg+2)<<2|byte(db+2))
+			case dg >= -32 && dg <= 31 && drdg >= -8 && drdg <= 7 && dbdg >= -8 && dbdg <= 7:
+				out = append(out, qoiOpLuma|byte(dg+32), byte(drdg+8)<<4|byte(dbdg+8))
+			default:
+				out = append(out, qoiOpRGB, px.R, px.G, px.B)
+			}
+		default:
+			out = append(out, qoiOpRGBA, px.R, px.G, px.B, px.A)
+		}
+		index[h] = px
+		prev = px
+	})
+	if run > 0 {
+		out = append(out, qoiOpRun|byte(run-1))
+	}
+	out = append(out, qoiEnd...)
+	_, err := w.Write(out)
+	return err
+}
+
+// float32Magic starts the header of raw float images: "F32\n<width> <height> <channels>\n",
+// followed by little endian float32 samples of linear light, interleaved and not premultiplied
+const float32Magic = "F32"
+
+// Helper function to decode a raw float image with 1 (gray), 3 (RGB) or 4 (RGBA) channels of linear light
+// into a float image, values are kept as they are, including values above white
+func decodeFloat32(data []byte) (*floatImage, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	var magic string
+	var w, h, channels int
+	if _, err := fmt.Fscanf(r, "%s\n%d %d %d\n", &magic, &w, &h, &channels); err != nil || magic != float32Magic {
+		return nil, fmt.Errorf("not a raw float image")
+	}
+	if w <= 0 || h <= 0 || (channels != 1 && channels != 3 && channels != 4) || uint64(w)*uint64(h) > maxImagePixels {
+		return nil, fmt.Errorf("invalid raw float image: %dx%d with %d channels", w, h, channels)
+	}
+	size := w * h * channels * 4
+	if len(data) < size {
+		return nil, fmt.Errorf("truncated raw float data: %dx%d with %d channels needs %d bytes", w, h, channels, size)
+	}
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("truncated raw float data: %w", err)
+	}
+	sample := func(i int) float32 { return stdmath.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:])) }
+	img := newFloatImage(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		s, p := i*channels, img.Pix[i*4:i*4+4]
+		switch channels {
+		case 1:
+			p[0], p[1], p[2], p[3] = sample(s), sample(s), sample(s), 1
+		case 3:
+			p[0], p[1], p[2], p[3] = sample(s), sample(s+1), sample(s+2), 1
+		default:
+			p[0], p[1], p[2], p[3] = sample(s), sample(s+1), sample(s+2), sample(s+3)
+		}
+	}
+	return img, nil
+}
+
+// Helper function to encode an image as raw float with 4 channels of linear light,
+// float images are written unmodified, other images are converted from sRGB
+func encodeFloat32(w io.Writer, img image.Image) error {
+	f := toFloatImage(img)
+	b := f.Rect
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s\n%d %d %d\n", float32Magic, b.Dx(), b.Dy(), 4)
+	var buf [4]byte
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for _, v := range f.Pix[f.PixOffset(b.Min.X, y):f.PixOffset(b.Max.X, y)] {
+			binary.LittleEndian.PutUint32(buf[:], stdmath.Float32bits(v))
+			bw.Write(buf[:])
+		}
+	}
+	return bw.Flush()
+}
+
+// Helper function to decode a Radiance RGBE image (.hdr) into a float image with linear values.
+// Flat, old and new run-length encoded scanlines are supported.
+func decodeRadiance(data []byte) (*floatImage, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	line, err := r.ReadString('\n')
+	if err != nil || (!strings.HasPrefix(line, "#?RADIANCE") && !strings.HasPrefix(line, "#?RGBE")) {
+		return nil, fmt.Errorf("not a Radiance image")
+	}
+	for {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, fmt.Errorf("truncated Radiance header: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if format, ok := strings.CutPrefix(line, "FORMAT="); ok && format != "32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("unsupported Radiance pixel format %q", format)
+		}
+	}
+	if line, err = r.ReadString('\n'); err != nil {
+		return nil, fmt.Errorf("missing Radiance resolution: %w", err)
+	}
+	var ySign, xSign string
+	var w, h int
+	if _, err := fmt.Sscanf(line, "%s %d %s %d", &ySign, &h, &xSign, &w); err != nil {
+		return nil, fmt.Errorf("invalid Radiance resolution %q", strings.TrimSpace(line))
+	}
+	if (ySign != "-Y" && ySign != "+Y") || xSign != "+X" || w <= 0 || h <= 0 || uint64(w)*uint64(h) > maxImagePixels {
+		return nil, fmt.Errorf("unsupported Radiance resolution %q", strings.TrimSpace(line))
+	}
+
+	img := newFloatImage(image.Rect(0, 0, w, h))
+	scanline := make([]byte, w*4)
+	for i := 0; i < h; i++ {
+		if err := readRGBEScanline(r, scanline); err != nil {
+			return nil, err
+		}
+		y := i
+		if ySign == "+Y" {
+			y = h - 1 - i // rows are stored bottom to top
+		}
+		for x := 0; x < w; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			p[0], p[1], p[2] = rgbeToFloat(scanline[x*4:])
+			p[3] = 1
+		}
+	}
+	return img, nil
+}
+
+// Helper function to read one scanline of RGBE pixels
+func readRGBEScanline(r *bufio.Reader, scanline []byte) error {
+	w := len(scanline) / 4
+	head, err := r.Peek(4)
+	if err != nil {
+		return fmt.Errorf("truncated Radiance data: %w", err)
+	}
+	if w < 8 || w > 0x7fff || head[0] != 2 || head[1] != 2 || head[2]&0x80 != 0 {
+		return readFlatRGBEScanline(r, scanline)
+	}
+	if int(head[2])<<8|int(head[3]) != w {
+		return fmt.Errorf("Radiance scanline width mismatch")
+	}
+	r.Discard(4)
+	// new run-length encoding: the channels are stored one after another, each as runs and literals
+	for ch := 0; ch < 4; ch++ {
+		for x := 0; x < w; {
+			n, err := r.ReadByte()
+			if err != nil {
+				return fmt.Errorf("truncated Radiance data: %w", err)
+			}
+			run := n > 128
+			if run {
+				n -= 128
+			}
+			if n == 0 || x+int(n) > w {
+				return fmt.Errorf("invalid Radiance run length")
+			}
+			v, err := r.ReadByte()
+			for i := 0; i < int(n) && err == nil; i++ {
+				scanline[(x+i)*4+ch] = v
+				if !run && i+1 < int(n) {
+					v, err = r.ReadByte()
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("truncated Radiance data: %w", err)
+			}
+			x += int(n)
+		}
+	}
+	return nil
+}
+
+// Helper function to read a scanline of flat RGBE pixels, which may contain old style runs
+// where a pixel of 1, 1, 1, n repeats the previous pixel
+func readFlatRGBEScanline(r *bufio.Reader, scanline []byte) error {
+	w := len(scanline) / 4
+	shift := 0
+	for x := 0; x < w; {
+		p := scanline[x*4 : x*4+4]
+		if _, err := io.ReadFull(r, p); err != nil {
+			return fmt.Errorf("truncated Radiance data: %w", err)
+		}
+		if p[0] == 1 && p[1] == 1 && p[2] == 1 && x > 0 {
+			n := int(p[3]) << shift
+			if x+n > w {
+				return fmt.Errorf("invalid Radiance run length")
+			}
+			for i := 0; i < n; i++ {
+				copy(scanline[(x+i)*4:], scanline[(x-1)*4:x*4])
+			}
+			x += n
+			shift += 8
+			continue
+		}
+		x++
+		shift = 0
+	}
+	return nil
+}
+
+// Helper function to convert an RGBE pixel to linear float values
+func rgbeToFloat(p []byte) (r, g, b float32) {
+	if p[3] == 0 {
+		return 0, 0, 0
+	}
+	f := stdmath.Ldexp(1, int(p[3])-(128+8))
+	return float32((float64(p[0]) + 0.5) * f), float32((float64(p[1]) + 0.5) * f), float32((float64(p[2]) + 0.5) * f)
+}
+
+// Helper function to convert linear float values to an RGBE pixel
+func floatToRGBE(r, g, b float32) [4]byte {
+	v := float64(max(r, g, b))
+	if v < 1e-32 {
+		return [4]byte{}
+	}
+	m, e := stdmath.Frexp(v)
+	scale := m * 256 / v
+	to8 := func(c float32) byte { return byte(math.Clamp(float64(c)*scale, 0, 255)) }
+	return [4]byte{to8(r), to8(g), to8(b), byte(e + 128)}
+}
+
+// Helper function to encode an image as Radiance RGBE with run-length encoded scanlines,
+// images that aren't float images are converted from sRGB to linear values
+func encodeRadiance(w io.Writer, img image.Image) error {
+	f := toFloatImage(img)
+	b := f.Rect
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", b.Dy(), b.Dx())
+	width := b.Dx()
+	channels := make([][]byte, 4)
+	for ch := range channels {
+		channels[ch] = make([]byte, width)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		pixels := make([][4]byte, width)
+		for x := range pixels {
+			p := f.Pix[f.PixOffset(b.Min.X+x, y):]
+			pixels[x] = floatToRGBE(p[0], p[1], p[2])
+		}
+		if width < 8 || width > 0x7fff {
+			for _, p := range pixels {
+				bw.Write(p[:])
+			}
+			continue
+		}
+		bw.Write([]byte{2, 2, byte(width >> 8), byte(width)})
+		for ch := range channels {
+			for x, p := range pixels {
+				channels[ch][x] = p[ch]
+			}
+			writeRGBERuns(bw, channels[ch])
+		}
+	}
+	return bw.Flush()
+}
+
+// Helper function to write a channel of a scanline as runs of at least 4 equal bytes and literals
+func writeRGBERuns(w *bufio.Writer, data []byte) {
+	const minRun = 4
+	for i := 0; i < len(data); {
+		// find the next run
+		start := i
+		run := 0
+		for start < len(data) {
+			run = 1
+			for start+run < len(data) && run < 127 && data[start+run] == data[start] {
+				run++
+			}
+			if run >= minRun {
+				break
+			}
+			start += run
+		}
+		if run < minRun {
+			start = len(data)
+		}
+		// literals up to the run
+		for i < start {
+			n := min(start-i, 128)
+			w.WriteByte(byte(n))
+			w.Write(data[i : i+n])
+			i += n
+		}
+		if start < len(data) {
+			w.WriteByte(byte(128 + run))
+			w.WriteByte(data[start])
+			i = start + run
+		}
+	}
+}
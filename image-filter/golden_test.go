@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden files in testdata/golden")
+
+const (
+	goldenDir = "testdata/golden"
+
+	// goldenTolerance is the maximum difference per 8 bit channel between a result and its golden image,
+	// it absorbs floating point differences between platforms (e.g. fused multiply-add on arm64)
+	goldenTolerance = 2
+)
+
+// untestedFuncs are the functions without a golden case and the reason why
+var untestedFuncs = map[string]string{
+	"batch":       "works on files matching a glob and needs the DSL runtime",
+	"map-frames":  "needs the DSL runtime to evaluate its expression",
+	"clear-cache": "only affects performance",
+}
+
+// testInputs are synthetic images shared by all cases
+type testInputs struct {
+	w, h      int
+	gradient  *image.NRGBA   // red increases to the right, green to the bottom, blue is constant
+	solid     *image.NRGBA   // a single color
+	alphaRamp *image.NRGBA   // the gradient with alpha increasing to the right
+	shapes    *image.NRGBA   // a circle and a square on a light background
+	binary    *image.NRGBA   // a white ring, a white square with a hole and a diagonal pixel pair on black
+	deep      *image.RGBA64  // the gradient with 16 bits per channel
+	deepAlpha *image.RGBA64  // the alpha ramp with 16 bits per channel
+	hdr       *floatImage    // linear light from 1/64 to 64 increasing to the right, with a bright circle
+	brackets  []*image.NRGBA // the HDR image exposed for 1/16, 1 and 16, see bracketTimes
+	dir       string         // a temporary directory for files written by the cases
+}
+
+// bracketTimes are the exposure times of the brackets in the test inputs
+const bracketTimes = "1/16 1 16"
+
+// funcCase runs a function on the test inputs, image results are compared to golden PNGs,
+// all other results to golden text files
+type funcCase struct {
+	name string
+	run  func(in *testInputs) (any, error)
+}
+
+var funcCases = []funcCase{
+	// effects.go
+	{"invert", func(in *testInputs) (any, error) { return invert(in.alphaRamp) }},
+	{"grayscale", func(in *testInputs) (any, error) { return grayscale(in.gradient) }},
+	{"sepia", func(in *testInputs) (any, error) { return sepia(in.gradient) }},
+	{"brightness", func(in *testInputs) (any, error) { return brightness(in.gradient, 1.4) }},
+	{"fill", func(in *testInputs) (any, error) { return fill(in.gradient, must(hsla(30, 0.8, 0.5, 0.5))) }},
+	{"colorize", func(in *testInputs) (any, error) { return colorize(in.gradient, must(hsla(200, 0.7, 0.5, 0.8))) }},
+	{"hsla", func(in *testInputs) (any, error) { return hsla(120, 0.5, 0.25, 1) }},
+
+	// blendmodes.go
+	{"blend-multiply", func(in *testInputs) (any, error) { return blendMultiply(in.deep, in.deepAlpha) }},
+	{"blend-screen", func(in *testInputs) (any, error) { return blendScreen(in.deep, in.deepAlpha) }},
+	{"blend-exclusion", func(in *testInputs) (any, error) { return blendExclusion(in.deep, in.deepAlpha) }},
+
+	// animation.go
+	{"assemble", func(in *testInputs) (any, error) {
+		return assemble([]*image.NRGBA{in.gradient, in.shapes}, 100)
+	}},
+	{"frames", func(in *testInputs) (any, error) {
+		return concatH(must(frames(must(assemble([]*image.NRGBA{in.gradient, in.shapes}, 100)))))
+	}},
+	{"frame", func(in *testInputs) (any, error) {
+		return frame(must(assemble([]*image.NRGBA{in.gradient, in.shapes}, 100)), 1)
+	}},
+	{"frame-count", func(in *testInputs) (any, error) {
+		return frameCount(must(assemble([]*image.NRGBA{in.gradient, in.shapes}, 100)))
+	}},
+
+	// images.go and stream.go
+	{"load", func(in *testInputs) (any, error) {
+		path := filepath.Join(in.dir, "load.png")
+		if _, err := save(in.alphaRamp, path); err != nil {
+			return nil, err
+		}
+		return load(path)
+	}},
+	{"save", func(in *testInputs) (any, error) {
+		path := filepath.Join(in.dir, "save.qoi")
+		if _, err := save(in.shapes, path); err != nil {
+			return nil, err
+		}
+		return load(path)
+	}},
+	{"to-base64", func(in *testInputs) (any, error) { return fromBase64(must(toBase64(in.alphaRamp, "qoi"))) }},
+	{"from-base64", func(in *testInputs) (any, error) { return fromBase64(must(toBase64(in.deep, "pam"))) }},
+	{"data-url", func(in *testInputs) (any, error) { return fromBase64(must(dataURL(in.shapes, "ppm"))) }},
+	{"store", func(in *testInputs) (any, error) { return store("golden", in.shapes) }},
+	{"recall", func(in *testInputs) (any, error) {
+		must(store("golden", in.gradient))
+		return recall("golden")
+	}},
+
+	// metadata.go
+	{"metadata", func(in *testInputs) (any, error) {
+		return metadata(must(writeTestFile(in, "metadata.png", in.gradient, testEXIF(6, true), testICC("Display P3"))))
+	}},
+	{"metadata-jpeg", func(in *testInputs) (any, error) {
+		return metadata(must(writeTestFile(in, "metadata.jpg", in.gradient, testEXIF(3, false), testICC("sRGB IEC61966-2.1"))))
+	}},
+	{"metadata-malformed-exif", func(in *testInputs) (any, error) {
+		_, err := metadata(must(writeTestFile(in, "malformed.png", in.gradient, []byte("not EXIF"), nil)))
+		return fmt.Sprint(err), nil
+	}},
+	{"metadata-malformed-ifd", func(in *testInputs) (any, error) {
+		// the camera make has an unknown type and claims to be far longer than the data
+		exif := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x0f\x01\x63\x00\xfa\x03\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+		return metadata(must(writeTestFile(in, "malformed-ifd.jpg", in.gradient, exif, nil)))
+	}},
+	{"load-orientation", func(in *testInputs) (any, error) {
+		return load(must(writeTestFile(in, "orientation.png", in.gradient, testEXIF(6, false), nil)))
+	}},
+	{"load-display-p3", func(in *testInputs) (any, error) {
+		return load(must(writeTestFile(in, "p3.png", in.gradient, nil, testICC("Display P3"))))
+	}},
+	{"load-malformed-exif", func(in *testInputs) (any, error) {
+		return load(must(writeTestFile(in, "malformed.jpg", in.shapes, []byte("MM\x00*broken"), nil)))
+	}},
+	{"save-with-metadata", func(in *testInputs) (any, error) {
+		// load orients and converts the pixels, so the copy must reset the orientation and drop the P3 profile
+		source := must(writeTestFile(in, "source.jpg", in.gradient, testEXIF(6, true), testICC("Display P3")))
+		img := must(load(source)).(image.Image)
+		path := filepath.Join(in.dir, "with-metadata.png")
+		if _, err := saveWithMetadata(toNRGBA(img), path, source); err != nil {
+			return nil, err
+		}
+		return metadata(path)
+	}},
+
+	// compare.go and stats.go
+	{"compare", func(in *testInputs) (any, error) { return compare(in.gradient, in.shapes) }},
+	{"diff-image", func(in *testInputs) (any, error) { return diffImage(in.gradient, in.shapes, 2) }},
+	{"info", func(in *testInputs) (any, error) { return info(in.deep) }},
+	{"mean-color", func(in *testInputs) (any, error) { return meanColor(in.gradient) }},
+	{"mean-luminance", func(in *testInputs) (any, error) { return meanLuminance(in.gradient) }},
+	{"std-dev", func(in *testInputs) (any, error) { return stdDev(in.gradient) }},
+	{"min-luminance", func(in *testInputs) (any, error) { return minLuminance(in.shapes) }},
+	{"max-luminance", func(in *testInputs) (any, error) { return maxLuminance(in.shapes) }},
+	{"is-grayscale", func(in *testInputs) (any, error) { return isGrayscale(in.gradient, 2) }},
+	{"dominant-hue", func(in *testInputs) (any, error) { return dominantHue(in.shapes) }},
+	{"sharpness-score", func(in *testInputs) (any, error) { return sharpnessScore(in.shapes) }},
+
+	// draw.go and text.go
+	{"draw-line", func(in *testInputs) (any, error) {
+		return drawLine(in.solid, 2, 2, float64(in.w-3), float64(in.h-3), 3, must(hsla(0, 1, 0.5, 1)))
+	}},
+	{"draw-rect", func(in *testInputs) (any, error) {
+		return drawRect(in.solid, 4, 4, float64(in.w/2), float64(in.h/2), 2, must(hsla(0, 0, 0, 1)), must(hsla(60, 1, 0.5, 0.7)))
+	}},
+	{"draw-circle", func(in *testInputs) (any, error) {
+		return drawCircle(in.solid, float64(in.w/2), float64(in.h/2), float64(in.h/3), 2, must(hsla(0, 0, 1, 1)), must(hsla(300, 1, 0.5, 1)))
+	}},
+	{"draw-ellipse", func(in *testInputs) (any, error) {
+		return drawEllipse(in.solid, float64(in.w/2), float64(in.h/2), float64(in.w/3), float64(in.h/4), 1.5, must(hsla(0, 0, 0, 1)), must(hsla(120, 1, 0.5, 1)))
+	}},
+	{"draw-polygon", func(in *testInputs) (any, error) {
+		pts := fmt.Sprintf("%d,2 %d,%d 2,%d", in.w/2, in.w-3, in.h-3, in.h-3)
+		return drawPolygon(in.solid, pts, 2, must(hsla(0, 0, 0, 1)), must(hsla(40, 1, 0.5, 1)))
+	}},
+	{"draw-path", func(in *testInputs) (any, error) {
+		d := fmt.Sprintf("M2 %d C%d 0 %d %d %d %d Z", in.h-2, in.w/3, 2*in.w/3, in.h, in.w-2, in.h/4)
+		return drawPath(in.solid, d, 2, must(hsla(0, 0, 1, 1)), must(hsla(200, 1, 0.3, 0.6)))
+	}},
+	{"draw-text", func(in *testInputs) (any, error) {
+		return drawText(in.solid, "Ab", float64(in.w/2), 2, float64(in.h)*0.6, must(hsla(0, 0, 1, 1)), "center")
+	}},
+	{"measure-text", func(in *testInputs) (any, error) { return measureText(`golden\ntext`, 12) }},
+
+	// warp.go
+	{"swirl", func(in *testInputs) (any, error) { return swirl(in.shapes, 90, float64(in.h/2)) }},
+	{"pinch", func(in *testInputs) (any, error) { return pinch(in.shapes, 0.5, float64(in.h/2)) }},
+	{"bulge", func(in *testInputs) (any, error) { return bulge(in.shapes, 0.5, float64(in.h/2)) }},
+	{"wave", func(in *testInputs) (any, error) { return wave(in.shapes, 2, float64(in.w/4)) }},
+	{"polar-to-cartesian", func(in *testInputs) (any, error) { return polarToCartesian(in.shapes) }},
+	{"cartesian-to-polar", func(in *testInputs) (any, error) { return cartesianToPolar(in.shapes) }},
+	{"displace", func(in *testInputs) (any, error) { return displace(in.shapes, in.gradient, 4) }},
+	{"perspective", func(in *testInputs) (any, error) {
+		corners := fmt.Sprintf("%d 0 %d 0 %d %d 0 %d", in.w/8, in.w-in.w/8, in.w-1, in.h-1, in.h-1)
+		return perspective(in.shapes, corners)
+	}},
+
+	// stylize.go
+	{"pixelate", func(in *testInputs) (any, error) { return pixelate(in.gradient, 5) }},
+	{"pixelate-region", func(in *testInputs) (any, error) {
+		return pixelateRegion(in.shapes, in.w/4, in.h/4, in.w/2, in.h/2, 4)
+	}},
+	{"mosaic", func(in *testInputs) (any, error) { return mosaic(in.shapes, 6) }},
+	{"oil-paint", func(in *testInputs) (any, error) { return oilPaint(in.shapes, 2, 8) }},
+	{"kuwahara", func(in *testInputs) (any, error) { return kuwahara(in.shapes, 2) }},
+	{"sketch", func(in *testInputs) (any, error) { return sketch(in.shapes, 1) }},
+	{"cartoon", func(in *testInputs) (any, error) { return cartoon(in.shapes, 4, 0.3) }},
+	{"ascii-art", func(in *testInputs) (any, error) { return asciiArt(in.shapes, 24) }},
+
+	// denoise.go and fft.go
+	{"bilateral", func(in *testInputs) (any, error) { return bilateral(in.shapes, 2, 30) }},
+	{"guided-filter", func(in *testInputs) (any, error) { return guidedFilter(in.shapes, 2, 0.01) }},
+	{"nl-means", func(in *testInputs) (any, error) { return nlMeans(in.shapes, 20) }},
+	{"fft", func(in *testInputs) (any, error) { return ifft(must(fft(in.shapes))) }},
+	{"ifft", func(in *testInputs) (any, error) { return ifft(must(fft(in.deepAlpha))) }},
+	{"fft-magnitude", func(in *testInputs) (any, error) { return fftMagnitude(must(fft(in.shapes))) }},
+	{"fft-phase", func(in *testInputs) (any, error) { return fftPhase(must(fft(in.shapes))) }},
+	{"low-pass", func(in *testInputs) (any, error) { return lowPass(in.shapes, 0.2) }},
+	{"high-pass", func(in *testInputs) (any, error) { return highPass(in.shapes, 0.1) }},
+	{"band-reject", func(in *testInputs) (any, error) { return bandReject(in.shapes, 0.3, 0.6) }},
+	{"convolve", func(in *testInputs) (any, error) { return convolve(in.shapes, "0 -1 0; -1 5 -1; 0 -1 0") }},
+	{"gaussian-blur", func(in *testInputs) (any, error) { return gaussianBlur(in.shapes, 6) }},
+
+	// keying.go
+	{"chroma-key", func(in *testInputs) (any, error) {
+		return chromaKey(in.shapes, must(hsla(0, 0.8, 0.5, 1)), 0.1, 0.1, 0.5)
+	}},
+	{"chroma-key-mask", func(in *testInputs) (any, error) {
+		return chromaKeyMask(in.shapes, must(hsla(0, 0.8, 0.5, 1)), 0.1, 0.1)
+	}},
+	{"flood-select", func(in *testInputs) (any, error) { return floodSelect(in.shapes, 0, 0, 10) }},
+	{"apply-mask", func(in *testInputs) (any, error) {
+		return applyMask(in.gradient, must(floodSelect(in.shapes, 0, 0, 10)))
+	}},
+
+	// layout.go
+	{"grid", func(in *testInputs) (any, error) {
+		return grid([]*image.NRGBA{in.gradient, in.shapes, in.alphaRamp}, 2, 2, must(hsla(0, 0, 0.2, 1)))
+	}},
+	{"tile", func(in *testInputs) (any, error) { return tile(in.shapes, 2, 2) }},
+	{"pad", func(in *testInputs) (any, error) { return pad(in.gradient, 1, 2, 3, 4, must(hsla(0, 0, 1, 1))) }},
+	{"extend-canvas", func(in *testInputs) (any, error) {
+		return extendCanvas(in.shapes, in.w+8, in.h+4, "bottom-right", must(hsla(0, 0, 0, 1)))
+	}},
+	{"concat-h", func(in *testInputs) (any, error) { return concatH([]*image.NRGBA{in.gradient, in.shapes}) }},
+	{"concat-v", func(in *testInputs) (any, error) { return concatV([]*image.NRGBA{in.gradient, in.shapes}) }},
+	{"sprite-sheet", func(in *testInputs) (any, error) {
+		return spriteSheetFromFrames([]*image.NRGBA{in.gradient, in.shapes, in.solid}, 2)
+	}},
+	{"sheet-image", func(in *testInputs) (any, error) {
+		return sheetImage(must(spriteSheetFromFrames([]*image.NRGBA{in.gradient, in.shapes, in.solid}, 2)))
+	}},
+	{"sheet-frames", func(in *testInputs) (any, error) {
+		return sheetFrames(must(spriteSheetFromFrames([]*image.NRGBA{in.gradient, in.shapes, in.solid}, 2)))
+	}},
+
+	// hdr.go
+	{"exposure", func(in *testInputs) (any, error) { return exposure(in.hdr, -4) }},
+	{"merge-debevec", func(in *testInputs) (any, error) {
+		return toneMapReinhard(must(mergeDebevec(in.brackets, bracketTimes)), 0.18, 0)
+	}},
+	{"merge-mertens", func(in *testInputs) (any, error) { return mergeMertens(in.brackets, 1, 1, 1) }},
+	{"tone-map-reinhard", func(in *testInputs) (any, error) { return toneMapReinhard(in.hdr, 0.18, 0) }},
+	{"tone-map-aces", func(in *testInputs) (any, error) { return toneMapACES(in.hdr, -1) }},
+	{"tone-map-hable", func(in *testInputs) (any, error) {
+		return toneMapHable(must(fromBase64(must(toBase64(in.hdr, "hdr")))).(image.Image), 1, 11.2)
+	}},
+
+	// features.go
+	{"harris-corners", func(in *testInputs) (any, error) { return harrisCorners(in.shapes, 0.04, 0.05) }},
+	{"fast-corners", func(in *testInputs) (any, error) { return fastCorners(in.shapes, 30) }},
+	{"blob-detect", func(in *testInputs) (any, error) { return blobDetect(in.shapes, 1, 8, 0.05) }},
+	{"hough-lines", func(in *testInputs) (any, error) { return houghLines(in.shapes, 0.3, in.h*3/4) }},
+	{"match-template", func(in *testInputs) (any, error) {
+		return matchTemplate(in.shapes, in.shapes.SubImage(image.Rect(in.w/2, in.h/5, in.w-2, in.h*4/5)), "ncc")
+	}},
+	{"feature-count", func(in *testInputs) (any, error) { return featureCount(must(fastCorners(in.shapes, 30))) }},
+	{"draw-features", func(in *testInputs) (any, error) {
+		return drawFeatures(in.shapes, must(harrisCorners(in.shapes, 0.04, 0.05)), 1, must(hsla(60, 1, 0.5, 1)))
+	}},
+
+	// components.go
+	{"threshold", func(in *testInputs) (any, error) { return threshold(in.gradient, 0.5) }},
+	{"label-components", func(in *testInputs) (any, error) { return labelComponents(in.binary, 8) }},
+	{"component-count", func(in *testInputs) (any, error) { return componentCount(must(labelComponents(in.binary, 4))) }},
+	{"label-image", func(in *testInputs) (any, error) { return labelImage(must(labelComponents(in.binary, 4))) }},
+	{"component-mask", func(in *testInputs) (any, error) { return componentMask(must(labelComponents(in.binary, 8)), 2) }},
+	{"find-contours", func(in *testInputs) (any, error) { return findContours(in.binary, 8) }},
+	{"draw-contours", func(in *testInputs) (any, error) {
+		return drawContours(in.shapes, must(findContours(in.binary, 8)), 1, must(hsla(300, 1, 0.5, 1)))
+	}},
+	{"fill-holes", func(in *testInputs) (any, error) { return fillHoles(in.binary, 8) }},
+
+	// pyramid.go and align.go
+	{"pyramid-gaussian", func(in *testInputs) (any, error) { return pyramidGaussian(in.shapes, 0) }},
+	{"pyramid-laplacian", func(in *testInputs) (any, error) { return pyramidLaplacian(in.shapes, 2) }},
+	{"pyramid-level", func(in *testInputs) (any, error) { return pyramidLevel(must(pyramidLaplacian(in.shapes, 0)), 1) }},
+	{"pyramid-collapse", func(in *testInputs) (any, error) { return pyramidCollapse(must(pyramidLaplacian(in.gradient, 0))) }},
+	{"multiband-blend", func(in *testInputs) (any, error) { return multibandBlend(in.gradient, in.shapes, in.binary, 0) }},
+	{"align", func(in *testInputs) (any, error) {
+		return align(in.shapes.SubImage(image.Rect(0, 0, in.w*3/4, in.h)), in.shapes.SubImage(image.Rect(in.w/4, 0, in.w, in.h)), "phase")
+	}},
+	{"stitch", func(in *testInputs) (any, error) {
+		return stitch(in.shapes.SubImage(image.Rect(0, 0, in.w*3/4, in.h)), in.shapes.SubImage(image.Rect(in.w/4, 0, in.w, in.h)), "phase", 0)
+	}},
+
+	// seamcarve.go and lazy.go
+	{"seam-carve", func(in *testInputs) (any, error) { return seamCarve(in.shapes, in.w*3/4, in.h+in.h/4) }},
+	{"lazy", func(in *testInputs) (any, error) {
+		return sepia(must(brightness(must(invert(must(lazy(in.gradient)))).(image.Image), 1.2)).(image.Image))
+	}},
+	{"render", func(in *testInputs) (any, error) {
+		imageCache.clear()
+		return render(must(brightness(must(lazy(in.alphaRamp)), 1.5)).(image.Image))
+	}},
+}
+
+// Helper function to panic on errors of intermediate results, the panic is reported as test failure
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Helper function to write an image as JPEG or PNG with the given EXIF data and ICC profile into the temporary directory
+func writeTestFile(in *testInputs, name string, img image.Image, exif, icc []byte) (string, error) {
+	path := filepath.Join(in.dir, name)
+	var buf bytes.Buffer
+	if _, err := encodeImage(&buf, img, imageFormat(path)); err != nil {
+		return "", err
+	}
+	embed := embedPNGMetadata
+	if imageFormat(path) == "jpg" {
+		embed = embedJPEGMetadata
+	}
+	data, err := embed(buf.Bytes(), exif, icc)
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0o644)
+}
+
+// Helper function to build little-endian EXIF data with camera, date and orientation,
+// and optionally a GPS position (48°51'29.6"N 2°17'40.2"W at 35 m)
+func testEXIF(orientation uint16, gps bool) []byte {
+	type field struct {
+		tag, typ uint16
+		count    uint32
+		value    []byte
+	}
+	le := binary.LittleEndian
+	ascii := func(tag uint16, s string) field { return field{tag, 2, uint32(len(s) + 1), []byte(s + "\x00")} }
+	rationals := func(tag uint16, v ...uint32) field {
+		var b []byte
+		for _, x := range v {
+			b = le.AppendUint32(b, x)
+		}
+		return field{tag, 5, uint32(len(v) / 2), b}
+	}
+	ifd0 := []field{
+		ascii(tagMake, "Golden"),
+		ascii(tagModel, "Test Camera"),
+		{tagOrientation, 3, 1, le.AppendUint16(nil, orientation)},
+		ascii(tagDateTime, "2024:04:01 12:34:56"),
+	}
+	var gpsIFD []field
+	if gps {
+		gpsIFD = []field{
+			ascii(tagGPSLatitudeRef, "N"),
+			rationals(tagGPSLatitude, 48, 1, 51, 1, 296, 10),
+			ascii(tagGPSLongitudeRef, "W"),
+			rationals(tagGPSLongitude, 2, 1, 17, 1, 402, 10),
+			{tagGPSAltitudeRef, 1, 1, []byte{0}},
+			rationals(tagGPSAltitude, 35, 1),
+		}
+		ifd0 = append(ifd0, field{tagGPSIFD, 4, 1, nil}) // the offset is known once IFD0's size is
+	}
+
+	ifdSize := func(fields []field) int { return 2 + len(fields)*12 + 4 }
+	gpsOffset := 8 + ifdSize(ifd0)
+	dataOffset := gpsOffset
+	if gps {
+		ifd0[len(ifd0)-1].value = le.AppendUint32(nil, uint32(gpsOffset))
+		dataOffset += ifdSize(gpsIFD)
+	}
+	out := []byte("II*\x00\x08\x00\x00\x00")
+	var data []byte
+	for _, ifd := range [][]field{ifd0, gpsIFD} {
+		if len(ifd) == 0 {
+			continue
+		}
+		out = le.AppendUint16(out, uint16(len(ifd)))
+		for _, f := range ifd {
+			out = le.AppendUint16(out, f.tag)
+			out = le.AppendUint16(out, f.typ)
+			out = le.AppendUint32(out, f.count)
+			if len(f.value) > 4 {
+				out = le.AppendUint32(out, uint32(dataOffset+len(data)))
+				data = append(data, f.value...)
+				continue
+			}
+			out = append(out, f.value...)
+			out = append(out, make([]byte, 4-len(f.value))...)
+		}
+		out = le.AppendUint32(out, 0) // no next IFD
+	}
+	return append(out, data...)
+}
+
+// Helper function to build a minimal ICC profile that only has a v2 description tag
+func testICC(description string) []byte {
+	desc := append([]byte("desc\x00\x00\x00\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(description)+1))...)
+	desc = append(desc, description+"\x00"...)
+	icc := make([]byte, 128)
+	icc = binary.BigEndian.AppendUint32(icc, 1)
+	icc = append(icc, "desc"...)
+	icc = binary.BigEndian.AppendUint32(icc, 144)
+	icc = binary.BigEndian.AppendUint32(icc, uint32(len(desc)))
+	return append(icc, desc...)
+}
+
+// Helper function to create the synthetic inputs of the given size
+func newTestInputs(w, h int) *testInputs {
+	in := &testInputs{
+		w:         w,
+		h:         h,
+		gradient:  image.NewNRGBA(image.Rect(0, 0, w, h)),
+		solid:     image.NewNRGBA(image.Rect(0, 0, w, h)),
+		alphaRamp: image.NewNRGBA(image.Rect(0, 0, w, h)),
+		shapes:    image.NewNRGBA(image.Rect(0, 0, w, h)),
+		binary:    image.NewNRGBA(image.Rect(0, 0, w, h)),
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g := uint8(x*255/max(w-1, 1)), uint8(y*255/max(h-1, 1))
+			in.gradient.SetNRGBA(x, y, color.NRGBA{R: r, G: g, B: 128, A: 255})
+			in.solid.SetNRGBA(x, y, color.NRGBA{R: 40, G: 90, B: 160, A: 255})
+			in.alphaRamp.SetNRGBA(x, y, color.NRGBA{R: r, G: g, B: 128, A: r})
+
+			c := color.NRGBA{R: 230, G: 230, B: 220, A: 255}
+			dx, dy := float64(x)-float64(w)/3, float64(y)-float64(h)/2
+			if dx*dx+dy*dy < float64(h*h)/9 {
+				c = color.NRGBA{R: 220, G: 40, B: 30, A: 255}
+			} else if x > w*3/5 && x < w*9/10 && y > h/4 && y < h*3/4 {
+				c = color.NRGBA{R: 30, G: 60, B: 200, A: 255}
+			}
+			in.shapes.SetNRGBA(x, y, c)
+
+			white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+			in.binary.SetNRGBA(x, y, color.NRGBA{A: 255})
+			rx, ry := float64(x)-float64(w)/4, float64(y)-float64(h)/2
+			if d := rx*rx + ry*ry; d < float64(h*h)/9 && d > float64(h*h)/36 {
+				in.binary.SetNRGBA(x, y, white)
+			}
+			inSquare := x > w/2 && x < w*7/8 && y > h/8 && y < h*5/8
+			inHole := x > w*5/8 && x < w*3/4 && y > h/4 && y < h/2
+			if inSquare && !inHole {
+				in.binary.SetNRGBA(x, y, white)
+			}
+			if (x == w*3/4 && y == h*3/4) || (x == w*3/4+1 && y == h*3/4+1) {
+				in.binary.SetNRGBA(x, y, white)
+			}
+		}
+	}
+	in.deep = image.NewRGBA64(in.gradient.Bounds())
+	in.deepAlpha = image.NewRGBA64(in.gradient.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g := uint16(x*0xffff/max(w-1, 1)), uint16(y*0xffff/max(h-1, 1))
+			in.deep.SetRGBA64(x, y, color.RGBA64{R: r, G: g, B: 0x8000, A: 0xffff})
+			in.deepAlpha.Set(x, y, color.NRGBA64{R: r, G: g, B: 0x8000, A: r})
+		}
+	}
+	in.hdr = newFloatImage(in.gradient.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			e := math.Pow(2, 12*float64(x)/float64(max(w-1, 1))-6)
+			v := float64(y) / float64(max(h-1, 1))
+			c := [3]float64{e * (0.5 + 0.5*v), e * 0.7, e * (1 - 0.5*v)}
+			dx, dy := float64(x)-float64(w)/2, float64(y)-float64(h)/2
+			if dx*dx+dy*dy < float64(h*h)/16 {
+				c = [3]float64{200, 180, 150}
+			}
+			p := in.hdr.Pix[in.hdr.PixOffset(x, y):]
+			p[0], p[1], p[2], p[3] = float32(c[0]), float32(c[1]), float32(c[2]), 1
+		}
+	}
+	for _, t := range []float64{1.0 / 16, 1, 16} {
+		img := image.NewNRGBA(in.hdr.Rect)
+		for i := 0; i < len(img.Pix); i += 4 {
+			for ch := 0; ch < 3; ch++ {
+				img.Pix[i+ch] = uint8(math.Round(linearToSRGB(math.Min(float64(in.hdr.Pix[i+ch])*t, 1)) * 255))
+			}
+			img.Pix[i+3] = 255
+		}
+		in.brackets = append(in.brackets, img)
+	}
+	return in
+}
+
+// Helper function to run a case, turning panics of intermediate results into errors
+func (c funcCase) result(in *testInputs) (res any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return c.run(in)
+}
+
+// Helper function to get the image of a result, returns false for results that aren't images
+func resultImage(res any) (*image.NRGBA, bool) {
+	switch v := res.(type) {
+	case image.Image:
+		return toNRGBA(v), true
+	case *spriteSheet:
+		return v.image, true
+	case *animation:
+		return v.frames[0], true
+	}
+	return nil, false
+}
+
+// Helper function to format results that aren't images, floats are rounded so small
+// differences between platforms don't matter
+func resultText(res any) string {
+	switch v := res.(type) {
+	case float64:
+		return fmt.Sprintf("%.4f\n", v)
+	case *animation:
+		return fmt.Sprintf("%d frames\n", len(v.frames))
+	}
+	return fmt.Sprintf("%v\n", res)
+}
+
+func TestGolden(t *testing.T) {
+	in := newTestInputs(48, 32)
+	in.dir = t.TempDir()
+	for _, c := range funcCases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := c.result(in)
+			if err != nil {
+				t.Fatalf("%s failed: %v", c.name, err)
+			}
+			if img, ok := resultImage(res); ok {
+				checkGoldenImage(t, c.name, img)
+				return
+			}
+			checkGoldenText(t, c.name, resultText(res))
+		})
+	}
+}
+
+// TestGoldenCoverage makes sure every function annotated with @Name has a golden case
+func TestGoldenCoverage(t *testing.T) {
+	cases := map[string]bool{}
+	for _, c := range funcCases {
+		if cases[c.name] {
+			t.Errorf("duplicate case %q", c.name)
+		}
+		cases[c.name] = true
+	}
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a function's annotations are directly followed by its declaration,
+	// variables are declared in var blocks and are skipped this way
+	re := regexp.MustCompile(`(?m)^// @Name:\s*(\S+)\n(?://.*\n)*func `)
+	var missing []string
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range re.FindAllStringSubmatch(string(src), -1) {
+			if _, skip := untestedFuncs[m[1]]; !skip && !cases[m[1]] {
+				missing = append(missing, fmt.Sprintf("%s (%s)", m[1], f))
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("functions without golden case: %s", strings.Join(missing, ", "))
+	}
+}
+
+// Helper function to compare an image to its golden PNG or to write it with -update
+func checkGoldenImage(t *testing.T, name string, img *image.NRGBA) {
+	t.Helper()
+	path := filepath.Join(goldenDir, name+".png")
+	if *update {
+		writeGolden(t, path, func(f *os.File) error { return png.Encode(f, img) })
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("missing golden image, run the tests with -update to create it: %v", err)
+	}
+	defer f.Close()
+	decoded, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := toNRGBA(decoded)
+	if !img.Bounds().Eq(want.Bounds()) {
+		t.Fatalf("bounds %v, golden image has %v", img.Bounds(), want.Bounds())
+	}
+	b := img.Bounds()
+	var diffs, maxDiff int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g, w := img.NRGBAAt(x, y), want.NRGBAAt(x, y)
+			d := max(absInt(int(g.R)-int(w.R)), absInt(int(g.G)-int(w.G)), absInt(int(g.B)-int(w.B)), absInt(int(g.A)-int(w.A)))
+			if d > goldenTolerance {
+				diffs++
+				maxDiff = max(maxDiff, d)
+			}
+		}
+	}
+	if diffs > 0 {
+		t.Errorf("%d pixels differ from %s by more than %d (max %d)", diffs, path, goldenTolerance, maxDiff)
+	}
+}
+
+// Helper function to compare text to its golden file or to write it with -update
+func checkGoldenText(t *testing.T, name string, text string) {
+	t.Helper()
+	path := filepath.Join(goldenDir, name+".txt")
+	if *update {
+		writeGolden(t, path, func(f *os.File) error { _, err := f.WriteString(text); return err })
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file, run the tests with -update to create it: %v", err)
+	}
+	if text != string(want) {
+		t.Errorf("result differs from %s\ngot:  %s\nwant: %s", path, text, want)
+	}
+}
+
+// Helper function to write a golden file
+func writeGolden(t *testing.T, path string, write func(f *os.File) error) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var benchSizes = []struct {
+	name string
+	w, h int
+}{
+	{"1MP", 1000, 1000},
+	{"12MP", 4000, 3000},
+}
+
+// BenchmarkFuncs runs every golden case at 1 and 12 megapixels, e.g.
+// go test -run '^$' -bench 'Funcs/sepia/' -benchmem
+func BenchmarkFuncs(b *testing.B) {
+	for _, c := range funcCases {
+		b.Run(c.name, func(b *testing.B) {
+			for _, s := range benchSizes {
+				b.Run(s.name, func(b *testing.B) {
+					in := benchInputs(s.w, s.h)
+					in.dir = b.TempDir()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						if _, err := c.result(in); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+var benchInputCache = map[image.Point]*testInputs{}
+
+// Helper function to create the inputs for a benchmark size once
+func benchInputs(w, h int) *testInputs {
+	key := image.Pt(w, h)
+	if in, ok := benchInputCache[key]; ok {
+		return in
+	}
+	in := newTestInputs(w, h)
+	benchInputCache[key] = in
+	return in
+}
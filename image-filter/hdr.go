@@ -0,0 +1,509 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/toxyl/math"
+)
+
+var (
+	// @Name:  hdr-16-bit
+	// @Desc:  Whether tone mapping and exposure fusion produce 16 bit images instead of 8 bit images
+	// @Range: -
+	// @Unit:  -
+	hdr16Bit = false
+)
+
+// responseSmoothness weighs the smoothness of the camera response curve recovered by
+// merge-debevec against how well it fits the samples
+const responseSmoothness = 50
+
+// floatImage is an image with linear, unbounded float channels (RGBA, alpha not premultiplied),
+// e.g. a Radiance image or a merged exposure bracket. As image.Image it is clipped to 0..1 and sRGB encoded.
+type floatImage struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+func newFloatImage(r image.Rectangle) *floatImage {
+	return &floatImage{Pix: make([]float32, 4*r.Dx()*r.Dy()), Stride: 4 * r.Dx(), Rect: r}
+}
+
+func (f *floatImage) String() string {
+	var peak float32
+	for i := 0; i < len(f.Pix); i += 4 {
+		peak = max(peak, f.Pix[i], f.Pix[i+1], f.Pix[i+2])
+	}
+	return fmt.Sprintf("%dx%d HDR image, peak %.4g", f.Rect.Dx(), f.Rect.Dy(), peak)
+}
+
+func (f *floatImage) ColorModel() color.Model { return color.NRGBA64Model }
+
+func (f *floatImage) Bounds() image.Rectangle { return f.Rect }
+
+func (f *floatImage) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(f.Rect) {
+		return color.NRGBA64{}
+	}
+	p := f.Pix[f.PixOffset(x, y):]
+	to16 := func(v float32) uint16 { return uint16(math.Round(linearToSRGB(math.Clamp(float64(v), 0, 1)) * 0xffff)) }
+	return color.NRGBA64{R: to16(p[0]), G: to16(p[1]), B: to16(p[2]), A: uint16(math.Round(math.Clamp(float64(p[3]), 0, 1) * 0xffff))}
+}
+
+func (f *floatImage) PixOffset(x, y int) int {
+	return (y-f.Rect.Min.Y)*f.Stride + (x-f.Rect.Min.X)*4
+}
+
+// @Name: exposure
+// @Desc: Scales the linear light of an image by a number of stops, the result is an HDR image that can exceed white
+// @Param:      img     - 	-   	-   The image to adjust
+// @Param:      stops   EV 	-   	0   The exposure change, each stop doubles the light
+// @Returns:    result  - 	-   	-   The HDR image
+func exposure(img image.Image, stops float64) (*floatImage, error) {
+	src := toFloatImage(img)
+	res := newFloatImage(src.Rect)
+	scale := float32(math.Pow(2, stops))
+	for i := 0; i < len(src.Pix); i += 4 {
+		res.Pix[i] = src.Pix[i] * scale
+		res.Pix[i+1] = src.Pix[i+1] * scale
+		res.Pix[i+2] = src.Pix[i+2] * scale
+		res.Pix[i+3] = src.Pix[i+3]
+	}
+	return res, nil
+}
+
+// @Name: merge-debevec
+// @Desc: Merges bracketed exposures into an HDR image, the camera response is recovered from the images (Debevec and Malik)
+// @Param:      images  - 	-   	-   The exposures of the same scene, aligned and of the same size
+// @Param:      times   s 	-   	-   The exposure times in the order of the images, e.g. "1/60 1/15 1/4"
+// @Returns:    result  - 	-   	-   The HDR image with relative radiance
+func mergeDebevec(images []*image.NRGBA, times string) (*floatImage, error) {
+	lnT, err := checkBracket(images, times)
+	if err != nil {
+		return nil, err
+	}
+	b := images[0].Bounds()
+	samples := bracketSamples(b, len(images))
+	var curves [3][256]float64
+	for ch := range curves {
+		curves[ch] = recoverResponse(images, samples, lnT, ch)
+	}
+
+	res := newFloatImage(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p := res.Pix[res.PixOffset(x, y):]
+			for ch := 0; ch < 3; ch++ {
+				var sum, sumW float64
+				best, bestDist := 0, 256.0
+				for j, img := range images {
+					z := img.Pix[img.PixOffset(x, y)+ch]
+					w := hatWeight(z)
+					sum += w * (curves[ch][z] - lnT[j])
+					sumW += w
+					if d := math.Abs(float64(z) - 127.5); d < bestDist {
+						best, bestDist = j, d
+					}
+				}
+				if sumW == 0 {
+					// under- or overexposed in all images, use the one closest to the middle
+					z := images[best].Pix[images[best].PixOffset(x, y)+ch]
+					sum, sumW = curves[ch][z]-lnT[best], 1
+				}
+				p[ch] = float32(math.Exp(sum / sumW))
+			}
+			p[3] = 1
+		}
+	}
+	return res, nil
+}
+
+// @Name: merge-mertens
+// @Desc: Fuses bracketed exposures directly into a displayable image, well exposed, saturated and detailed parts of each image are blended with a Laplacian pyramid (Mertens, Kautz and Van Reeth)
+// @Param:      images      - 	-   	-   	The exposures of the same scene, aligned and of the same size
+// @Param:      contrast    - 	0..   	1.0   	Weight of local contrast
+// @Param:      saturation  - 	0..   	1.0   	Weight of color saturation
+// @Param:      exposedness - 	0..   	1.0   	Weight of being close to middle gray
+// @Returns:    result      - 	-   	-   	The fused image
+func mergeMertens(images []*image.NRGBA, contrast, saturation, exposedness float64) (image.Image, error) {
+	if len(images) < 2 {
+		return nil, fmt.Errorf("need at least 2 images, got %d", len(images))
+	}
+	if contrast < 0 || saturation < 0 || exposedness < 0 {
+		return nil, fmt.Errorf("weights must not be negative")
+	}
+	b := images[0].Bounds()
+	for _, img := range images[1:] {
+		if img.Bounds().Size() != b.Size() {
+			return nil, fmt.Errorf("all images must have the same size, got %v and %v", b.Size(), img.Bounds().Size())
+		}
+	}
+	w, h := b.Dx(), b.Dy()
+
+	planes := make([][3][]float64, len(images))
+	weights := make([][]float64, len(images))
+	for k, img := range images {
+		planes[k], weights[k] = mertensWeights(img, contrast, saturation, exposedness)
+	}
+	for i := 0; i < w*h; i++ {
+		var sum float64
+		for k := range weights {
+			sum += weights[k][i]
+		}
+		for k := range weights {
+			weights[k][i] /= sum
+		}
+	}
+
+	levels := pyramidLevels(w, h)
+	var fused [3][]float64
+	for ch := range fused {
+		var acc [][]float64
+		for k := range images {
+			wp := gaussianPyramid(weights[k], w, h, levels)
+			lp := laplacianPyramid(planes[k][ch], w, h, levels)
+			if acc == nil {
+				acc = make([][]float64, levels)
+				for l := range acc {
+					acc[l] = make([]float64, len(lp[l]))
+				}
+			}
+			for l := range lp {
+				for i := range lp[l] {
+					acc[l][i] += wp[l][i] * lp[l][i]
+				}
+			}
+		}
+		fused[ch] = collapsePyramid(acc, w, h)
+	}
+	return displayImage(b, func(i int) [4]float64 {
+		return [4]float64{fused[0][i], fused[1][i], fused[2][i], 1}
+	}), nil
+}
+
+// @Name: tone-map-reinhard
+// @Desc: Maps an HDR image to a displayable image with the global Reinhard operator, the luminance is scaled to the key and compressed so that the white point becomes white
+// @Param:      img     - 	-   	 	-   	The image to map
+// @Param:      key     - 	0..1   		0.18   	The brightness of the mapped image, the log-average luminance is mapped to it
+// @Param:      white   - 	0..   		0   	The scaled luminance that becomes white, 0 uses the brightest pixel
+// @Returns:    result  - 	-   		-   	The tone mapped image
+func toneMapReinhard(img image.Image, key, white float64) (image.Image, error) {
+	if key <= 0 {
+		return nil, fmt.Errorf("key must be greater than 0")
+	}
+	if white < 0 {
+		return nil, fmt.Errorf("white must not be negative")
+	}
+	f := toFloatImage(img)
+	var logSum, peak float64
+	n := 0
+	for i := 0; i < len(f.Pix); i += 4 {
+		l := floatLuminance(f.Pix[i:])
+		logSum += math.Log(1e-6 + l)
+		peak = max(peak, l)
+		n++
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	scale := key / math.Exp(logSum/float64(n))
+	if white == 0 {
+		white = peak * scale
+	}
+	white2 := max(white*white, 1e-12)
+	return toneMap(f, func(c [3]float64) [3]float64 {
+		l := (0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]) * scale
+		if l <= 0 {
+			return [3]float64{}
+		}
+		ratio := (1 + l/white2) / (1 + l) * scale
+		return [3]float64{c[0] * ratio, c[1] * ratio, c[2] * ratio}
+	}), nil
+}
+
+// @Name: tone-map-aces
+// @Desc: Maps an HDR image to a displayable image with a filmic curve fitted to the ACES reference rendering
+// @Param:      img     	- 	-   	-   	The image to map
+// @Param:      exposure    EV 	-   	0   	Exposure change applied before mapping
+// @Returns:    result  	- 	-   	-   	The tone mapped image
+func toneMapACES(img image.Image, exposure float64) (image.Image, error) {
+	scale := math.Pow(2, exposure)
+	aces := func(x float64) float64 {
+		x *= scale
+		return x * (2.51*x + 0.03) / (x*(2.43*x+0.59) + 0.14)
+	}
+	return toneMap(toFloatImage(img), func(c [3]float64) [3]float64 {
+		return [3]float64{aces(c[0]), aces(c[1]), aces(c[2])}
+	}), nil
+}
+
+// @Name: tone-map-hable
+// @Desc: Maps an HDR image to a displayable image with John Hable's filmic curve, which keeps more contrast in the shadows than Reinhard
+// @Param:      img     	- 	-   	-   	The image to map
+// @Param:      exposure    EV 	-   	1   	Exposure change applied before mapping
+// @Param:      white       - 	0..   	11.2   	The linear value that becomes white
+// @Returns:    result  	- 	-   	-   	The tone mapped image
+func toneMapHable(img image.Image, exposure, white float64) (image.Image, error) {
+	if white <= 0 {
+		return nil, fmt.Errorf("white must be greater than 0")
+	}
+	const a, b, c, d, e, f = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+	curve := func(x float64) float64 {
+		return (x*(a*x+c*b)+d*e)/(x*(a*x+b)+d*f) - e/f
+	}
+	scale := math.Pow(2, exposure)
+	norm := curve(white)
+	hable := func(x float64) float64 { return curve(max(x, 0)*scale) / norm }
+	return toneMap(toFloatImage(img), func(c [3]float64) [3]float64 {
+		return [3]float64{hable(c[0]), hable(c[1]), hable(c[2])}
+	}), nil
+}
+
+// Helper function to convert an image to a float image, sRGB values are converted to linear values
+func toFloatImage(img image.Image) *floatImage {
+	if f, ok := img.(*floatImage); ok {
+		return f
+	}
+	b := img.Bounds()
+	res := newFloatImage(b)
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		var lut [256]float32
+		for i := range lut {
+			lut[i] = float32(srgbToLinear(float64(i) / 255))
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			src := nrgba.Pix[nrgba.PixOffset(b.Min.X, y):nrgba.PixOffset(b.Max.X, y)]
+			dst := res.Pix[res.PixOffset(b.Min.X, y):]
+			for i := 0; i < len(src); i += 4 {
+				dst[i], dst[i+1], dst[i+2] = lut[src[i]], lut[src[i+1]], lut[src[i+2]]
+				dst[i+3] = float32(src[i+3]) / 255
+			}
+		}
+		return res
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			p := res.Pix[res.PixOffset(x, y):]
+			p[0] = float32(srgbToLinear(float64(c.R) / 0xffff))
+			p[1] = float32(srgbToLinear(float64(c.G) / 0xffff))
+			p[2] = float32(srgbToLinear(float64(c.B) / 0xffff))
+			p[3] = float32(c.A) / 0xffff
+		}
+	}
+	return res
+}
+
+// Helper function to get the luminance of a linear float pixel
+func floatLuminance(p []float32) float64 {
+	return 0.2126*float64(p[0]) + 0.7152*float64(p[1]) + 0.0722*float64(p[2])
+}
+
+// Helper function to apply a tone mapping curve to the linear values of a float image,
+// the curve maps to 0..1 which is then sRGB encoded
+func toneMap(f *floatImage, curve func(c [3]float64) [3]float64) image.Image {
+	w := f.Rect.Dx()
+	return displayImage(f.Rect, func(i int) [4]float64 {
+		p := f.Pix[f.PixOffset(f.Rect.Min.X+i%w, f.Rect.Min.Y+i/w):]
+		c := curve([3]float64{float64(p[0]), float64(p[1]), float64(p[2])})
+		for ch := range c {
+			c[ch] = linearToSRGB(math.Clamp(c[ch], 0, 1))
+		}
+		return [4]float64{c[0], c[1], c[2], float64(p[3])}
+	})
+}
+
+// Helper function to create an 8 bit or 16 bit image (see hdr-16-bit) from display values in 0..1,
+// at returns the non-premultiplied channels of the i-th pixel in row-major order
+func displayImage(r image.Rectangle, at func(i int) [4]float64) image.Image {
+	w, h := r.Dx(), r.Dy()
+	if hdr16Bit {
+		img := image.NewNRGBA64(r)
+		to16 := func(v float64) uint16 { return uint16(math.Round(math.Clamp(v, 0, 1) * 0xffff)) }
+		for i := 0; i < w*h; i++ {
+			c := at(i)
+			img.SetNRGBA64(r.Min.X+i%w, r.Min.Y+i/w, color.NRGBA64{R: to16(c[0]), G: to16(c[1]), B: to16(c[2]), A: to16(c[3])})
+		}
+		return img
+	}
+	img := image.NewNRGBA(r)
+	to8 := func(v float64) uint8 { return uint8(math.Round(math.Clamp(v, 0, 1) * 255)) }
+	for i := 0; i < w*h; i++ {
+		c := at(i)
+		img.SetNRGBA(r.Min.X+i%w, r.Min.Y+i/w, color.NRGBA{R: to8(c[0]), G: to8(c[1]), B: to8(c[2]), A: to8(c[3])})
+	}
+	return img
+}
+
+// Helper function to validate an exposure bracket and parse its exposure times,
+// returns the natural logarithms of the times
+func checkBracket(images []*image.NRGBA, times string) ([]float64, error) {
+	if len(images) < 2 {
+		return nil, fmt.Errorf("need at least 2 images, got %d", len(images))
+	}
+	fields := strings.Fields(strings.ReplaceAll(times, ",", " "))
+	if len(fields) != len(images) {
+		return nil, fmt.Errorf("need one exposure time per image, got %d times for %d images", len(fields), len(images))
+	}
+	lnT := make([]float64, len(fields))
+	for i, s := range fields {
+		num, den, isFraction := strings.Cut(s, "/")
+		t, err := strconv.ParseFloat(num, 64)
+		if err == nil && isFraction {
+			var d float64
+			if d, err = strconv.ParseFloat(den, 64); err == nil {
+				t /= d
+			}
+		}
+		if err != nil || t <= 0 || math.IsInf(t, 0) {
+			return nil, fmt.Errorf("invalid exposure time %q", s)
+		}
+		lnT[i] = math.Log(t)
+	}
+	b := images[0].Bounds()
+	if b.Empty() {
+		return nil, fmt.Errorf("images are empty")
+	}
+	for _, img := range images[1:] {
+		if !img.Bounds().Eq(b) {
+			return nil, fmt.Errorf("all images must have the same bounds, got %v and %v", b, img.Bounds())
+		}
+	}
+	return lnT, nil
+}
+
+// Helper function to choose the pixels used to recover the camera response, on a regular grid.
+// Debevec and Malik need n*(samples-1) > 255 to determine the curve, twice that is used.
+func bracketSamples(b image.Rectangle, n int) []image.Point {
+	count := min(b.Dx()*b.Dy(), max(64, 2*256/(n-1)+1))
+	cols := max(1, int(math.Ceil(math.Sqrt(float64(count)*float64(b.Dx())/float64(b.Dy())))))
+	rows := max(1, (count+cols-1)/cols)
+	samples := make([]image.Point, 0, rows*cols)
+	seen := map[image.Point]bool{}
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			p := image.Pt(b.Min.X+(2*c+1)*b.Dx()/(2*cols), b.Min.Y+(2*r+1)*b.Dy()/(2*rows))
+			if !seen[p] {
+				seen[p] = true
+				samples = append(samples, p)
+			}
+		}
+	}
+	return samples
+}
+
+// Helper function to weigh a pixel value by how well exposed it is, 0 for black and white
+func hatWeight(z uint8) float64 {
+	return float64(min(z, 255-z))
+}
+
+// Helper function to recover the logarithmic camera response g(z) = ln(E*t) of a channel by solving
+// the least squares problem of Debevec and Malik, with g(128) fixed to 0
+func recoverResponse(images []*image.NRGBA, samples []image.Point, lnT []float64, ch int) [256]float64 {
+	n := 256 + len(samples) // g(0..255) followed by ln(E) of every sample
+	ata := make([]float64, n*n)
+	atb := make([]float64, n)
+	addRow := func(idx []int, val []float64, b float64) {
+		for i := range idx {
+			for j := range idx {
+				ata[idx[i]*n+idx[j]] += val[i] * val[j]
+			}
+			atb[idx[i]] += val[i] * b
+		}
+	}
+	for i, p := range samples {
+		for j, img := range images {
+			z := img.Pix[img.PixOffset(p.X, p.Y)+ch]
+			w := hatWeight(z)
+			addRow([]int{int(z), 256 + i}, []float64{w, -w}, w*lnT[j])
+		}
+		ata[(256+i)*n+256+i] += 1e-9 // keeps samples that are badly exposed in all images solvable
+	}
+	addRow([]int{128}, []float64{1}, 0)
+	for z := 1; z < 255; z++ {
+		w := responseSmoothness * hatWeight(uint8(z))
+		addRow([]int{z - 1, z, z + 1}, []float64{w, -2 * w, w}, 0)
+	}
+	x := solveCholesky(ata, atb, n)
+	var g [256]float64
+	copy(g[:], x)
+	return g
+}
+
+// Helper function to solve a symmetric positive definite system a*x = b in place
+func solveCholesky(a, b []float64, n int) []float64 {
+	for j := 0; j < n; j++ {
+		d := a[j*n+j]
+		for k := 0; k < j; k++ {
+			d -= a[j*n+k] * a[j*n+k]
+		}
+		d = math.Sqrt(max(d, 1e-300))
+		a[j*n+j] = d
+		for i := j + 1; i < n; i++ {
+			s := a[i*n+j]
+			for k := 0; k < j; k++ {
+				s -= a[i*n+k] * a[j*n+k]
+			}
+			a[i*n+j] = s / d
+		}
+	}
+	x := make([]float64, n)
+	for i := 0; i < n; i++ {
+		s := b[i]
+		for k := 0; k < i; k++ {
+			s -= a[i*n+k] * x[k]
+		}
+		x[i] = s / a[i*n+i]
+	}
+	for i := n - 1; i >= 0; i-- {
+		s := x[i]
+		for k := i + 1; k < n; k++ {
+			s -= a[k*n+i] * x[k]
+		}
+		x[i] = s / a[i*n+i]
+	}
+	return x
+}
+
+// Helper function to split an image into channel planes in 0..1 and compute its Mertens weight,
+// the product of contrast, saturation and well-exposedness raised to their weights
+func mertensWeights(img *image.NRGBA, contrast, saturation, exposedness float64) ([3][]float64, []float64) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var planes [3][]float64
+	for ch := range planes {
+		planes[ch] = make([]float64, w*h)
+	}
+	gray := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := img.Pix[img.PixOffset(b.Min.X+x, b.Min.Y+y):]
+			i := y*w + x
+			for ch := range planes {
+				planes[ch][i] = float64(p[ch]) / 255
+			}
+			gray[i] = luminance(color.NRGBA{R: p[0], G: p[1], B: p[2]}) / 255
+		}
+	}
+	weights := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			lap := math.Abs(gray[min(y+1, h-1)*w+x] + gray[max(y-1, 0)*w+x] + gray[y*w+min(x+1, w-1)] + gray[y*w+max(x-1, 0)] - 4*gray[i])
+			r, g, bl := planes[0][i], planes[1][i], planes[2][i]
+			mean := (r + g + bl) / 3
+			sat := math.Sqrt(((r-mean)*(r-mean) + (g-mean)*(g-mean) + (bl-mean)*(bl-mean)) / 3)
+			well := 1.0
+			for _, v := range []float64{r, g, bl} {
+				well *= math.Exp(-(v - 0.5) * (v - 0.5) / (2 * 0.2 * 0.2))
+			}
+			weights[i] = math.Pow(lap, contrast)*math.Pow(sat, saturation)*math.Pow(well, exposedness) + 1e-12
+		}
+	}
+	return planes, weights
+}
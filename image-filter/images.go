@@ -1,35 +1,164 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"image"
+	"image/gif"
+	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// @Name:  jpeg-quality
+	// @Desc:  Quality used when saving JPEG images
+	// @Range: 1..100
+	// @Unit:  -
+	jpegQuality = 90
 )
 
 // @Name: load
-// @Desc: Loads an image
-// @Param:      path    - -   -   Path to the image
+// @Desc: Loads an image, animated GIFs are loaded as a frame sequence. Unchanged files are taken from the cache.
+// @Param:      path    - -   -   Path to the image, "-" reads the image from stdin and detects its format
 // @Returns:    result  - -   -   The loaded image
 func load(path string) (any, error) {
-	file, err := os.Open(path)
+	if path == "-" {
+		return loadStdin()
+	}
+	fi, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	return png.Decode(file)
+	// decoding depends on the metadata settings, so they are part of the key
+	key := fmt.Sprintf("load:%s:%d:%d:%t:%t", path, fi.Size(), fi.ModTime().UnixNano(), autoOrient, convertToSRGB)
+	if img, ok := imageCache.get(key); ok {
+		return img, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeImage(data, imageFormat(path))
+	if err != nil {
+		return nil, err
+	}
+	imageCache.put(key, img)
+	return img, nil
 }
 
 // @Name: save
-// @Desc: Saves an image
+// @Desc: Saves an image in the format given by the extension: png, jpg, gif, pbm, pgm, ppm, pnm, pam, qoi, f32 (raw float) or hdr (Radiance). Frame sequences are saved as animated GIF, 16 bit images keep their precision in png, Netpbm and f32, HDR images keep values above white in hdr and f32.
 // @Param:      img     - -   -    The image to save
-// @Param:      path     - -   -   Path where to save
-func save(img *image.NRGBA, path string) (any, error) {
-	file, err := os.Create(path)
+// @Param:      path     - -   -   Path where to save, "-" writes the image to stdout in the format set by stream-format
+func save(img any, path string) (any, error) {
+	if path == "-" {
+		return saveStdout(img)
+	}
+	// encoding into memory first keeps an existing file intact if the value can't be encoded
+	var buf bytes.Buffer
+	res, err := encodeImage(&buf, img, imageFormat(path))
+	if err != nil {
+		return nil, err
+	}
+	return res, os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// Helper function to decode an image in the given format.
+// JPEG and PNG images are oriented and converted to sRGB according to their metadata unless it is malformed,
+// Netpbm, QOI, raw float and Radiance images carry no metadata and are returned as decoded.
+// If the format is empty, it is detected from the data.
+func decodeImage(data []byte, format string) (any, error) {
+	if format == "" {
+		if format = sniffFormat(data); format == "" {
+			return nil, fmt.Errorf("unknown image format")
+		}
+	}
+	var img image.Image
+	var err error
+	switch format {
+	case "gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		anim := animationFromGIF(g)
+		if len(anim.frames) == 1 {
+			return anim.frames[0], nil
+		}
+		return anim, nil
+	case "jpg", "jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "pbm", "pgm", "ppm", "pnm", "pam":
+		return decodeNetpbm(data)
+	case "qoi":
+		return decodeQOI(data)
+	case "f32":
+		return decodeFloat32(data)
+	case "hdr":
+		return decodeRadiance(data)
+	default:
+		img, err = png.Decode(bytes.NewReader(data))
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	png.Encode(file, img) // note that we use NRGBA because storing PNGs is much faster that way
+
+	meta, err := readMetadata(data)
+	if err != nil {
+		// the pixels decoded fine, broken metadata is treated as absent
+		return img, nil
+	}
+	if convertToSRGB && meta.ColorProfile == profileDisplayP3 {
+		img = displayP3ToSRGB(toNRGBA(img))
+	}
+	if autoOrient && meta.Orientation > 1 {
+		img = applyOrientation(toNRGBA(img), meta.Orientation)
+	}
 	return img, nil
 }
+
+// Helper function to encode an image in the given format, returns the image that was encoded
+func encodeImage(w io.Writer, img any, format string) (any, error) {
+	switch v := img.(type) {
+	case *animation:
+		if format != "gif" {
+			return nil, fmt.Errorf("frame sequences can only be saved as GIF")
+		}
+		return v, gif.EncodeAll(w, v.toGIF(gifSharedPalette))
+	case image.Image:
+		switch format {
+		case "pbm", "pgm", "ppm", "pnm", "pam":
+			return v, encodeNetpbm(w, v, format)
+		case "f32":
+			return v, encodeFloat32(w, v)
+		case "hdr":
+			return v, encodeRadiance(w, v)
+		case "png":
+			if isDeepImage(v) {
+				return v, png.Encode(w, v) // 16 bit images keep their precision
+			}
+		}
+		nrgba := toNRGBA(v)
+		switch format {
+		case "qoi":
+			return nrgba, encodeQOI(w, nrgba)
+		case "gif":
+			return nrgba, gif.EncodeAll(w, (&animation{frames: []*image.NRGBA{nrgba}, delays: []int{0}}).toGIF(false))
+		case "jpg", "jpeg":
+			return nrgba, jpeg.Encode(w, nrgba, &jpeg.Options{Quality: jpegQuality})
+		default:
+			return nrgba, png.Encode(w, nrgba) // note that we use NRGBA because storing PNGs is much faster that way
+		}
+	default:
+		return nil, fmt.Errorf("cannot save %T as image", img)
+	}
+}
+
+// Helper function to determine the image format from a path's extension
+func imageFormat(path string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/toxyl/math"
+)
+
+// @Name: chroma-key
+// @Desc: Makes all pixels that have a similar chroma as the key color transparent, e.g. a green screen
+// @Param:      img     		- 	-   		-   	The image to key
+// @Param:      key     		- 	-   		-   	The key color, e.g. hsla(120 1 0.5 1)
+// @Param:      tolerance 		"%" 0.0..1.0   	0.2   	Chroma distance up to which pixels become fully transparent
+// @Param:      softness 		"%" 0.0..1.0   	0.1   	Chroma distance over which the transparency fades out beyond the tolerance
+// @Param:      spill-suppression "%" 0.0..1.0  0.5   	How much of the key color reflected onto the foreground is removed
+// @Returns:    result  		- 	-   		-   	The keyed image
+func chromaKey(img *image.NRGBA, key color.RGBA64, tolerance, softness, spillSuppression float64) (*image.NRGBA, error) {
+	if err := checkChromaKeyParams(tolerance, softness, spillSuppression); err != nil {
+		return nil, err
+	}
+	mask := chromaKeyAlpha(img, key, tolerance, softness)
+	b := img.Bounds()
+	result := image.NewNRGBA(b)
+	k := rgba64ToNRGBA(key)
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := suppressSpill(img.NRGBAAt(b.Min.X+x, b.Min.Y+y), k, spillSuppression)
+			c.A = uint8(math.Round(float64(c.A) * mask[y*b.Dx()+x]))
+			result.SetNRGBA(b.Min.X+x, b.Min.Y+y, c)
+		}
+	}
+	return result, nil
+}
+
+// @Name: chroma-key-mask
+// @Desc: Creates a mask that is white where the image doesn't match the key color and black where it does
+// @Param:      img     		- 	-   		-   	The image to key
+// @Param:      key     		- 	-   		-   	The key color, e.g. hsla(120 1 0.5 1)
+// @Param:      tolerance 		"%" 0.0..1.0   	0.2   	Chroma distance up to which pixels become black
+// @Param:      softness 		"%" 0.0..1.0   	0.1   	Chroma distance over which the mask fades to white beyond the tolerance
+// @Returns:    result  		- 	-   		-   	The mask
+func chromaKeyMask(img *image.NRGBA, key color.RGBA64, tolerance, softness float64) (*image.NRGBA, error) {
+	if err := checkChromaKeyParams(tolerance, softness, 0); err != nil {
+		return nil, err
+	}
+	return maskImage(img.Bounds(), chromaKeyAlpha(img, key, tolerance, softness)), nil
+}
+
+// @Name: flood-select
+// @Desc: Selects the connected area of similar color around a point (magic wand)
+// @Param:      img     	- 	-   	-   The image to select from
+// @Param:      x       	px 	-   	0   X coordinate of the start point
+// @Param:      y       	px 	-   	0   Y coordinate of the start point
+// @Param:      tolerance 	- 	0..255  32  Maximum difference of any channel to the start point's color
+// @Returns:    result  	- 	-   	-   A mask that is white where the area is selected and black elsewhere
+func floodSelect(img *image.NRGBA, x, y int, tolerance int) (*image.NRGBA, error) {
+	b := img.Bounds()
+	if !(image.Point{x, y}).In(b) {
+		return nil, fmt.Errorf("start point (%d, %d) is outside of the image %v", x, y, b)
+	}
+	w := b.Dx()
+	seed := img.NRGBAAt(x, y)
+	similar := func(c color.NRGBA) bool {
+		d := max(
+			absInt(int(c.R)-int(seed.R)), absInt(int(c.G)-int(seed.G)),
+			absInt(int(c.B)-int(seed.B)), absInt(int(c.A)-int(seed.A)),
+		)
+		return d <= tolerance
+	}
+
+	selected := make([]float64, w*b.Dy())
+	stack := []image.Point{{x, y}}
+	selected[(y-b.Min.Y)*w+x-b.Min.X] = 1
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, n := range [4]image.Point{{p.X - 1, p.Y}, {p.X + 1, p.Y}, {p.X, p.Y - 1}, {p.X, p.Y + 1}} {
+			if !n.In(b) {
+				continue
+			}
+			i := (n.Y-b.Min.Y)*w + n.X - b.Min.X
+			if selected[i] == 0 && similar(img.NRGBAAt(n.X, n.Y)) {
+				selected[i] = 1
+				stack = append(stack, n)
+			}
+		}
+	}
+	return maskImage(b, selected), nil
+}
+
+// @Name: apply-mask
+// @Desc: Multiplies the alpha of an image with the luminance of a mask, e.g. one created by chroma-key-mask or flood-select
+// @Param:      img     - 	-   	-   The image to mask
+// @Param:      mask    - 	-   	-   The mask, white keeps pixels, black removes them
+// @Returns:    result  - 	-   	-   The masked image
+func applyMask(img *image.NRGBA, mask *image.NRGBA) (*image.NRGBA, error) {
+	if err := checkSameSize(img, mask); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	mb := mask.Bounds()
+	result := image.NewNRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+			m := mask.NRGBAAt(mb.Min.X+x, mb.Min.Y+y)
+			c.A = uint8(math.Round(float64(c.A) * luminance(m) / 255.0 * float64(m.A) / 255.0))
+			result.SetNRGBA(b.Min.X+x, b.Min.Y+y, c)
+		}
+	}
+	return result, nil
+}
+
+// Helper function to validate the parameters shared by the chroma key functions
+func checkChromaKeyParams(tolerance, softness, spillSuppression float64) error {
+	for _, v := range []float64{tolerance, softness, spillSuppression} {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("tolerance, softness and spill-suppression must be between 0.0 and 1.0")
+		}
+	}
+	return nil
+}
+
+// Helper function to compute the alpha (0..1) of every pixel from its chroma distance to the key color
+func chromaKeyAlpha(img *image.NRGBA, key color.RGBA64, tolerance, softness float64) []float64 {
+	k := rgba64ToNRGBA(key)
+	_, kcb, kcr := color.RGBToYCbCr(k.R, k.G, k.B)
+	b := img.Bounds()
+	alpha := make([]float64, b.Dx()*b.Dy())
+	i := 0
+	forEachNRGBA(img, func(c color.NRGBA) {
+		_, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+		// the maximum distance in the CbCr plane is about 255 * sqrt(2)
+		d := math.Hypot(float64(cb)-float64(kcb), float64(cr)-float64(kcr)) / (255 * math.Sqrt2)
+		switch {
+		case d <= tolerance:
+			alpha[i] = 0
+		case d >= tolerance+softness:
+			alpha[i] = 1
+		default:
+			t := (d - tolerance) / softness
+			alpha[i] = t * t * (3 - 2*t) // smoothstep
+		}
+		i++
+	})
+	return alpha
+}
+
+// Helper function to reduce the key color's dominant channel to the level of the other channels
+func suppressSpill(c, key color.NRGBA, amount float64) color.NRGBA {
+	if amount == 0 {
+		return c
+	}
+	ch := [3]uint8{c.R, c.G, c.B}
+	k := [3]uint8{key.R, key.G, key.B}
+	dominant := 0
+	for i := range k {
+		if k[i] > k[dominant] {
+			dominant = i
+		}
+	}
+	limit := max(ch[(dominant+1)%3], ch[(dominant+2)%3])
+	if ch[dominant] > limit {
+		ch[dominant] = uint8(math.Round(float64(ch[dominant]) - float64(ch[dominant]-limit)*amount))
+	}
+	return color.NRGBA{R: ch[0], G: ch[1], B: ch[2], A: c.A}
+}
+
+// Helper function to create an opaque grayscale mask image from values in 0..1
+func maskImage(b image.Rectangle, values []float64) *image.NRGBA {
+	mask := image.NewNRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			v := uint8(math.Round(values[y*b.Dx()+x] * 255))
+			mask.SetNRGBA(b.Min.X+x, b.Min.Y+y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return mask
+}
+
+// Helper function to get the absolute value of an integer
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
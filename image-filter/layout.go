@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// canvasAnchors maps the anchors of extend-canvas to the horizontal and vertical position
+// of the image in the free space: 0 = start, 1 = center, 2 = end
+var canvasAnchors = map[string][2]int{
+	"top-left": {0, 0}, "top": {1, 0}, "top-right": {2, 0},
+	"left": {0, 1}, "center": {1, 1}, "right": {2, 1},
+	"bottom-left": {0, 2}, "bottom": {1, 2}, "bottom-right": {2, 2},
+}
+
+// spriteSheet is an image atlas together with the position of every frame in it
+type spriteSheet struct {
+	image  *image.NRGBA
+	frames []image.Rectangle
+}
+
+func (s *spriteSheet) String() string {
+	b := s.image.Bounds()
+	return fmt.Sprintf("%dx%d sprite sheet with %d frames", b.Dx(), b.Dy(), len(s.frames))
+}
+
+// @Name: grid
+// @Desc: Arranges images in a grid, e.g. for contact sheets, each image is centered in a cell as large as the largest image
+// @Param:      images  - 	-   	-   The images to arrange
+// @Param:      cols    - 	1..   	4   The number of columns
+// @Param:      gap     px 	0..   	0   The space between cells
+// @Param:      bg      - 	-   	-   The background color
+// @Returns:    result  - 	-   	-   The grid image
+func grid(images []*image.NRGBA, cols int, gap int, bg color.RGBA64) (*image.NRGBA, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("cannot create a grid without images")
+	}
+	if cols < 1 {
+		return nil, fmt.Errorf("cols must be at least 1")
+	}
+	if gap < 0 {
+		return nil, fmt.Errorf("gap must not be negative")
+	}
+	var cell image.Point
+	for _, img := range images {
+		cell.X = max(cell.X, img.Bounds().Dx())
+		cell.Y = max(cell.Y, img.Bounds().Dy())
+	}
+	cols = min(cols, len(images))
+	rows := (len(images) + cols - 1) / cols
+	w := float64(cols)*float64(cell.X) + float64(cols-1)*float64(gap)
+	h := float64(rows)*float64(cell.Y) + float64(rows-1)*float64(gap)
+	if err := checkImageSize(w, h); err != nil {
+		return nil, err
+	}
+	result := newCanvas(int(w), int(h), bg)
+	for i, img := range images {
+		b := img.Bounds()
+		x := i%cols*(cell.X+gap) + (cell.X-b.Dx())/2
+		y := i/cols*(cell.Y+gap) + (cell.Y-b.Dy())/2
+		draw.Draw(result, image.Rect(x, y, x+b.Dx(), y+b.Dy()), img, b.Min, draw.Over)
+	}
+	return result, nil
+}
+
+// @Name: tile
+// @Desc: Repeats an image horizontally and vertically, e.g. to preview a seamless pattern
+// @Param:      img     - 	-   	-   The image to repeat
+// @Param:      nx      - 	1..   	2   The number of repetitions horizontally
+// @Param:      ny      - 	1..   	2   The number of repetitions vertically
+// @Returns:    result  - 	-   	-   The tiled image
+func tile(img *image.NRGBA, nx, ny int) (*image.NRGBA, error) {
+	if nx < 1 || ny < 1 {
+		return nil, fmt.Errorf("nx and ny must be at least 1")
+	}
+	b := img.Bounds()
+	if err := checkImageSize(float64(b.Dx())*float64(nx), float64(b.Dy())*float64(ny)); err != nil {
+		return nil, err
+	}
+	result := image.NewNRGBA(image.Rect(0, 0, b.Dx()*nx, b.Dy()*ny))
+	for ty := 0; ty < ny; ty++ {
+		for tx := 0; tx < nx; tx++ {
+			r := image.Rect(0, 0, b.Dx(), b.Dy()).Add(image.Pt(tx*b.Dx(), ty*b.Dy()))
+			draw.Draw(result, r, img, b.Min, draw.Src)
+		}
+	}
+	return result, nil
+}
+
+// @Name: pad
+// @Desc: Adds a border of the given color around an image
+// @Param:      img     - 	-   	-   The image to pad
+// @Param:      top     px 	0..   	0   The padding above the image
+// @Param:      right   px 	0..   	0   The padding right of the image
+// @Param:      bottom  px 	0..   	0   The padding below the image
+// @Param:      left    px 	0..   	0   The padding left of the image
+// @Param:      col     - 	-   	-   The color of the padding
+// @Returns:    result  - 	-   	-   The padded image
+func pad(img *image.NRGBA, top, right, bottom, left int, col color.RGBA64) (*image.NRGBA, error) {
+	if top < 0 || right < 0 || bottom < 0 || left < 0 {
+		return nil, fmt.Errorf("padding must not be negative")
+	}
+	b := img.Bounds()
+	if err := checkImageSize(float64(left)+float64(b.Dx())+float64(right), float64(top)+float64(b.Dy())+float64(bottom)); err != nil {
+		return nil, err
+	}
+	result := newCanvas(left+b.Dx()+right, top+b.Dy()+bottom, col)
+	draw.Draw(result, image.Rect(left, top, left+b.Dx(), top+b.Dy()), img, b.Min, draw.Src)
+	return result, nil
+}
+
+// @Name: extend-canvas
+// @Desc: Changes the canvas size without scaling the image, the image is placed according to the anchor and cropped if the canvas is smaller
+// @Param:      img     - 	-   	-   		The image to place
+// @Param:      w       px 	1..   	-   		The width of the new canvas
+// @Param:      h       px 	1..   	-   		The height of the new canvas
+// @Param:      anchor  - 	-   	"center"   	Where to place the image: top-left, top, top-right, left, center, right, bottom-left, bottom or bottom-right
+// @Param:      col     - 	-   	-   		The color of the new canvas area
+// @Returns:    result  - 	-   	-   		The image on the new canvas
+func extendCanvas(img *image.NRGBA, w, h int, anchor string, col color.RGBA64) (*image.NRGBA, error) {
+	if w < 1 || h < 1 {
+		return nil, fmt.Errorf("canvas size must be at least 1x1")
+	}
+	if err := checkImageSize(float64(w), float64(h)); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	f, ok := canvasAnchors[anchor]
+	if !ok {
+		return nil, fmt.Errorf("unknown anchor %q", anchor)
+	}
+	x := (w - b.Dx()) * f[0] / 2
+	y := (h - b.Dy()) * f[1] / 2
+	result := newCanvas(w, h, col)
+	draw.Draw(result, image.Rect(x, y, x+b.Dx(), y+b.Dy()), img, b.Min, draw.Src)
+	return result, nil
+}
+
+// @Name: concat-h
+// @Desc: Places images next to each other from left to right, aligned at the top
+// @Param:      images  - 	-   	-   The images to concatenate
+// @Returns:    result  - 	-   	-   The concatenated image
+func concatH(images []*image.NRGBA) (*image.NRGBA, error) {
+	return concat(images, true)
+}
+
+// @Name: concat-v
+// @Desc: Places images below each other from top to bottom, aligned at the left
+// @Param:      images  - 	-   	-   The images to concatenate
+// @Returns:    result  - 	-   	-   The concatenated image
+func concatV(images []*image.NRGBA) (*image.NRGBA, error) {
+	return concat(images, false)
+}
+
+// @Name: sprite-sheet
+// @Desc: Packs frames into a sprite atlas with the given number of columns, use sheet-image and sheet-frames to get the atlas and the frame coordinates
+// @Param:      frames  - 	-   	-   The frames to pack, e.g. frames(load("anim.gif"))
+// @Param:      cols    - 	1..   	8   The number of columns
+// @Returns:    result  - 	-   	-   The sprite sheet
+func spriteSheetFromFrames(frames []*image.NRGBA, cols int) (*spriteSheet, error) {
+	img, err := grid(frames, cols, 0, color.RGBA64{})
+	if err != nil {
+		return nil, err
+	}
+	cols = min(cols, len(frames))
+	var cell image.Point
+	for _, f := range frames {
+		cell.X = max(cell.X, f.Bounds().Dx())
+		cell.Y = max(cell.Y, f.Bounds().Dy())
+	}
+	sheet := &spriteSheet{image: img, frames: make([]image.Rectangle, len(frames))}
+	for i, f := range frames {
+		b := f.Bounds()
+		x := i%cols*cell.X + (cell.X-b.Dx())/2
+		y := i/cols*cell.Y + (cell.Y-b.Dy())/2
+		sheet.frames[i] = image.Rect(x, y, x+b.Dx(), y+b.Dy())
+	}
+	return sheet, nil
+}
+
+// @Name: sheet-image
+// @Desc: Returns the atlas image of a sprite sheet
+// @Param:      sheet   - 	-   	-   The sprite sheet
+// @Returns:    result  - 	-   	-   The atlas image
+func sheetImage(sheet *spriteSheet) (*image.NRGBA, error) {
+	return sheet.image, nil
+}
+
+// @Name: sheet-frames
+// @Desc: Returns the frame coordinates of a sprite sheet as JSON, e.g. [{"x":0,"y":0,"w":32,"h":32}, ...]
+// @Param:      sheet   - 	-   	-   The sprite sheet
+// @Returns:    result  - 	-   	-   The frame coordinates
+func sheetFrames(sheet *spriteSheet) (string, error) {
+	type frameRect struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+		W int `json:"w"`
+		H int `json:"h"`
+	}
+	rects := make([]frameRect, len(sheet.frames))
+	for i, r := range sheet.frames {
+		rects[i] = frameRect{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy()}
+	}
+	data, err := json.Marshal(rects)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Helper function to concatenate images horizontally or vertically
+func concat(images []*image.NRGBA, horizontal bool) (*image.NRGBA, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("cannot concatenate without images")
+	}
+	var w, h int
+	for _, img := range images {
+		b := img.Bounds()
+		if horizontal {
+			w += b.Dx()
+			h = max(h, b.Dy())
+		} else {
+			w = max(w, b.Dx())
+			h += b.Dy()
+		}
+	}
+	result := image.NewNRGBA(image.Rect(0, 0, w, h))
+	var offset int
+	for _, img := range images {
+		b := img.Bounds()
+		pos := image.Pt(0, offset)
+		if horizontal {
+			pos = image.Pt(offset, 0)
+		}
+		draw.Draw(result, image.Rect(0, 0, b.Dx(), b.Dy()).Add(pos), img, b.Min, draw.Src)
+		if horizontal {
+			offset += b.Dx()
+		} else {
+			offset += b.Dy()
+		}
+	}
+	return result, nil
+}
+
+// Helper function to create an image of the given size filled with a color
+func newCanvas(w, h int, col color.RGBA64) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(rgba64ToNRGBA(col)), image.Point{}, draw.Src)
+	return img
+}
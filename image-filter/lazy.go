@@ -0,0 +1,285 @@
+package main
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+
+	"github.com/toxyl/math"
+)
+
+var (
+	// @Name:  cache-size
+	// @Desc:  Memory in MB used to keep loaded images and rendered lazy images, 0 disables the cache
+	// @Range: 0..
+	// @Unit:  MB
+	cacheSize = 512
+)
+
+// rgb is a color with channels on a 0..255 scale that may exceed the range between operations
+type rgb [3]float64
+
+// pixelOp is an operation that maps each pixel independently of its neighbors,
+// so a chain of them can be applied in a single pass over the image
+type pixelOp struct {
+	desc  string // the operation and its parameters, part of the cache key
+	apply func(c rgb) rgb
+}
+
+// lazyImage is a node of the operation graph: a source image with a chain of pixel operations
+// that is only computed when the pixels are needed, e.g. by render or a function that isn't a pixel operation
+type lazyImage struct {
+	src    *image.NRGBA
+	srcKey string
+	ops    []pixelOp
+}
+
+func (l *lazyImage) String() string {
+	b := l.src.Bounds()
+	steps := []string{"source"}
+	for _, op := range l.ops {
+		steps = append(steps, op.desc)
+	}
+	return fmt.Sprintf("lazy %dx%d image: %s", b.Dx(), b.Dy(), strings.Join(steps, " -> "))
+}
+
+func (l *lazyImage) ColorModel() color.Model { return color.NRGBAModel }
+
+func (l *lazyImage) Bounds() image.Rectangle { return l.src.Bounds() }
+
+func (l *lazyImage) At(x, y int) color.Color {
+	return applyPixelOps(l.src.NRGBAAt(x, y), l.ops)
+}
+
+// @Name: lazy
+// @Desc: Starts a lazy operation graph, pixel operations such as invert, brightness or sepia applied to the result are fused into one pass that runs when the image is rendered. Chains without lazy are computed step by step. Only load results and rendered chains are cached, other functions such as gaussian-blur or warps are recomputed on every evaluation.
+// @Param:      img     - -   -   The source image
+// @Returns:    result  - -   -   The lazy image
+func lazy(img image.Image) (*lazyImage, error) {
+	if l, ok := img.(*lazyImage); ok {
+		return l, nil
+	}
+	src := toNRGBA(img)
+	return &lazyImage{src: src, srcKey: imageCache.keyOf(src)}, nil
+}
+
+// @Name: render
+// @Desc: Computes a lazy image, results are cached by the content of the source and the operations, so unchanged chains are not recomputed
+// @Param:      img     - -   -   The image to render
+// @Returns:    result  - -   -   The rendered image
+func render(img image.Image) (*image.NRGBA, error) {
+	return toNRGBA(img), nil
+}
+
+// @Name: clear-cache
+// @Desc: Removes all loaded images and rendered lazy images from the cache
+// @Returns:    result  - -   -   The number of removed entries
+func clearCache() (string, error) {
+	n := imageCache.clear()
+	return fmt.Sprintf("removed %d cached results", n), nil
+}
+
+// Helper function to apply a pixel operation: lazy images get the operation appended to their
+// chain, all other images are processed immediately
+func applyPixelOp(img image.Image, op pixelOp) (any, error) {
+	if l, ok := img.(*lazyImage); ok {
+		return &lazyImage{src: l.src, srcKey: l.srcKey, ops: append(l.ops[:len(l.ops):len(l.ops)], op)}, nil
+	}
+	return pixelPass(toNRGBA(img), []pixelOp{op}), nil
+}
+
+// Helper function to compute a lazy image or to get it from the cache
+func (l *lazyImage) render() *image.NRGBA {
+	if len(l.ops) == 0 {
+		return l.src
+	}
+	key := l.srcKey
+	for _, op := range l.ops {
+		key += "|" + op.desc
+	}
+	if res, ok := imageCache.get(key); ok {
+		return res.(*image.NRGBA)
+	}
+	res := pixelPass(l.src, l.ops)
+	imageCache.put(key, res)
+	return res
+}
+
+// Helper function to apply a chain of pixel operations to every pixel in one pass
+func pixelPass(img *image.NRGBA, ops []pixelOp) *image.NRGBA {
+	b := img.Bounds()
+	result := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		src := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		dst := result.Pix[result.PixOffset(b.Min.X, y):result.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(src); i += 4 {
+			c := applyPixelOps(color.NRGBA{R: src[i], G: src[i+1], B: src[i+2], A: src[i+3]}, ops)
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = c.R, c.G, c.B, c.A
+		}
+	}
+	return result
+}
+
+// Helper function to apply a chain of pixel operations to a color, intermediate results are not
+// rounded, the final channels are truncated to 0..255
+func applyPixelOps(c color.NRGBA, ops []pixelOp) color.NRGBA {
+	v := rgb{float64(c.R), float64(c.G), float64(c.B)}
+	for _, op := range ops {
+		v = op.apply(v)
+	}
+	to8 := func(f float64) uint8 { return uint8(math.Clamp(f, 0, 255)) }
+	return color.NRGBA{R: to8(v[0]), G: to8(v[1]), B: to8(v[2]), A: c.A}
+}
+
+// resultCache is a least recently used cache of results, limited by the memory they use
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List              // most recently used first
+	keys    map[*image.NRGBA]string // cache keys of cached images, so they don't need to be hashed again
+	size    int
+	seeds   [2]maphash.Seed // seeds of the two hashes that make up an image's key
+}
+
+type cacheEntry struct {
+	key   string
+	value any
+	size  int
+}
+
+var imageCache = &resultCache{
+	entries: map[string]*list.Element{},
+	order:   list.New(),
+	keys:    map[*image.NRGBA]string{},
+	seeds:   [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+}
+
+// Helper function to get a cached result
+func (c *resultCache) get(key string) (any, bool) {
+	if cacheSize <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).value, true
+}
+
+// Helper function to cache a result, evicting the least recently used results if the cache is full
+func (c *resultCache) put(key string, value any) {
+	size := cachedSize(value)
+	limit := cacheSize * 1024 * 1024
+	if limit <= 0 || size > limit {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, size: size})
+	c.size += size
+	if img, ok := value.(*image.NRGBA); ok {
+		c.keys[img] = key
+	}
+	for c.size > limit {
+		c.remove(c.order.Back())
+	}
+}
+
+// Helper function to remove all cached results, returns the number of removed results
+func (c *resultCache) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = map[string]*list.Element{}
+	c.keys = map[*image.NRGBA]string{}
+	c.order.Init()
+	c.size = 0
+	return n
+}
+
+// Helper function to remove a cache entry, the caller must hold the lock
+func (c *resultCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	if img, ok := entry.value.(*image.NRGBA); ok {
+		delete(c.keys, img)
+	}
+	c.size -= entry.size
+}
+
+// Helper function to get the key that identifies the content of an image. Images from the
+// cache already have one, other images are hashed.
+func (c *resultCache) keyOf(img *image.NRGBA) string {
+	c.mu.Lock()
+	key, ok := c.keys[img]
+	c.mu.Unlock()
+	if ok {
+		return key
+	}
+	// two independently seeded 64 bit hashes, so a collision that would return the wrong image is negligible
+	var h1, h2 maphash.Hash
+	h1.SetSeed(c.seeds[0])
+	h2.SetSeed(c.seeds[1])
+	write := func(p []byte) {
+		h1.Write(p)
+		h2.Write(p)
+	}
+	b := img.Bounds()
+	var buf [32]byte
+	for i, v := range []int{b.Min.X, b.Min.Y, b.Max.X, b.Max.Y} {
+		binary.LittleEndian.PutUint64(buf[i*8:], uint64(v))
+	}
+	write(buf[:])
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		write(img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)])
+	}
+	return fmt.Sprintf("image:%016x%016x", h1.Sum64(), h2.Sum64())
+}
+
+// cacheEntryOverhead is the memory counted for every cached result in addition to its pixels,
+// so results without pixels are evicted eventually as well
+const cacheEntryOverhead = 64
+
+// Helper function to estimate the memory used by a cached result
+func cachedSize(value any) int {
+	switch v := value.(type) {
+	case *floatImage:
+		return cacheEntryOverhead + len(v.Pix)*4
+	case *image.YCbCr:
+		return cacheEntryOverhead + len(v.Y) + len(v.Cb) + len(v.Cr)
+	case *animation:
+		size := cacheEntryOverhead
+		for _, f := range v.frames {
+			size += len(f.Pix)
+		}
+		return size
+	case image.Image:
+		b := v.Bounds()
+		return cacheEntryOverhead + b.Dx()*b.Dy()*bytesPerPixel(v)
+	}
+	return cacheEntryOverhead
+}
+
+// Helper function to get the number of bytes an image uses per pixel
+func bytesPerPixel(img image.Image) int {
+	switch img.(type) {
+	case *image.Gray, *image.Alpha, *image.Paletted:
+		return 1
+	case *image.Gray16, *image.Alpha16:
+		return 2
+	case *image.NRGBA, *image.RGBA, *image.CMYK:
+		return 4
+	}
+	return 8 // 16 bits per channel, e.g. *image.NRGBA64 and *image.RGBA64
+}
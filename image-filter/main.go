@@ -1,5 +1,39 @@
 package main
 
+import (
+	"fmt"
+	"os"
+)
+
 func main() {
+	if len(os.Args) == 5 && os.Args[1] == "batch" {
+		// command mode: image-filter batch <glob> <out-template> <expr>
+		summary, err := runBatch(os.Args[2], os.Args[3], os.Args[4], batchWorkers)
+		if err != nil {
+			fmt.Println("\x1b[31mError:\x1b[0m", err)
+			os.Exit(1)
+		}
+		fmt.Println(summary)
+		if len(summary.errors) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) == 3 && os.Args[1] == "run" {
+		// command mode: image-filter run <expr>, e.g. to use it as filter in a pipe:
+		// cat in.png | image-filter run 'save(invert(load("-")) "-")' > out.png
+		r, err := dsl.run(os.Args[2], true)
+		if err == nil {
+			err = r.err
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "\x1b[31mError:\x1b[0m", err)
+			os.Exit(1)
+		}
+		if !stdoutUsed {
+			fmt.Println(r.value)
+		}
+		return
+	}
 	dsl.shell()
 }
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/toxyl/math"
+)
+
+var (
+	// @Name:  auto-orient
+	// @Desc:  Whether load rotates and flips images according to their EXIF orientation
+	// @Range: -
+	// @Unit:  -
+	autoOrient = true
+
+	// @Name:  convert-to-srgb
+	// @Desc:  Whether load converts images with a Display P3 color profile to sRGB
+	// @Range: -
+	// @Unit:  -
+	convertToSRGB = true
+)
+
+// Color profiles detected from embedded ICC profiles or PNG color chunks
+const (
+	profileNone      = "none"
+	profileSRGB      = "sRGB"
+	profileDisplayP3 = "Display P3"
+)
+
+// imageMetadata is the metadata found in an image file
+type imageMetadata struct {
+	Make         string
+	Model        string
+	Software     string
+	DateTime     string
+	Orientation  int
+	HasGPS       bool
+	GPSLatitude  float64
+	GPSLongitude float64
+	GPSAltitude  float64
+	ColorProfile string
+
+	exif              []byte           // raw TIFF structure of the EXIF data
+	icc               []byte           // raw ICC profile
+	orientationOffset int              // offset of the orientation value within exif, -1 if there is none
+	byteOrder         binary.ByteOrder // byte order of the EXIF data
+}
+
+func (m *imageMetadata) String() string {
+	var sb strings.Builder
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s: %s\n", name, value)
+		}
+	}
+	field("Camera", strings.TrimSpace(m.Make+" "+m.Model))
+	field("Software", m.Software)
+	field("Date", m.DateTime)
+	field("Orientation", fmt.Sprint(m.Orientation))
+	if m.HasGPS {
+		field("GPS", fmt.Sprintf("%.6f, %.6f (%.1f m)", m.GPSLatitude, m.GPSLongitude, m.GPSAltitude))
+	}
+	field("Color profile", m.ColorProfile)
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// @Name: metadata
+// @Desc: Reads the metadata (EXIF and color profile) of a JPEG or PNG image
+// @Param:      path    - -   -   Path to the image
+// @Returns:    result  - -   -   Camera, date, GPS position, orientation and color profile of the image
+func metadata(path string) (*imageMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return readMetadata(data)
+}
+
+// @Name: save-with-metadata
+// @Desc: Saves an image as JPEG or PNG and copies the metadata of another image into it
+// @Param:      img     - -   -    The image to save
+// @Param:      path    - -   -    Path where to save
+// @Param:      source  - -   -    Path of the image whose metadata to copy
+func saveWithMetadata(img *image.NRGBA, path string, source string) (any, error) {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := readMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+
+	exif := meta.exif
+	if autoOrient && meta.orientationOffset >= 0 {
+		// the pixels have already been oriented by load, so the tag must not rotate them again
+		exif = append([]byte(nil), exif...)
+		meta.byteOrder.PutUint16(exif[meta.orientationOffset:], 1)
+	}
+	icc := meta.icc
+	if convertToSRGB && meta.ColorProfile == profileDisplayP3 {
+		icc = nil // the pixels have been converted to sRGB by load
+	}
+
+	var buf bytes.Buffer
+	if _, err := encodeImage(&buf, img, imageFormat(path)); err != nil {
+		return nil, err
+	}
+	var out []byte
+	switch imageFormat(path) {
+	case "jpg", "jpeg":
+		out, err = embedJPEGMetadata(buf.Bytes(), exif, icc)
+	case "png":
+		out, err = embedPNGMetadata(buf.Bytes(), exif, icc)
+	default:
+		return nil, fmt.Errorf("metadata can only be saved to JPEG and PNG images")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return img, os.WriteFile(path, out, 0o644)
+}
+
+// Helper function to extract and parse the metadata of an encoded JPEG or PNG image
+func readMetadata(data []byte) (*imageMetadata, error) {
+	meta := &imageMetadata{Orientation: 1, ColorProfile: profileNone, orientationOffset: -1}
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		meta.exif, meta.icc, err = jpegMetadataSegments(data)
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		var srgb bool
+		meta.exif, meta.icc, srgb, err = pngMetadataChunks(data)
+		if srgb {
+			meta.ColorProfile = profileSRGB
+		}
+	default:
+		return meta, nil // other formats carry no metadata we know of
+	}
+	if err != nil {
+		return nil, err
+	}
+	if meta.icc != nil {
+		meta.ColorProfile = iccProfileName(meta.icc)
+	}
+	if meta.exif != nil {
+		if err := parseEXIF(meta); err != nil {
+			return nil, fmt.Errorf("invalid EXIF data: %w", err)
+		}
+	}
+	return meta, nil
+}
+
+// Helper function to find the EXIF and ICC segments of a JPEG image
+func jpegMetadataSegments(data []byte) (exif, icc []byte, err error) {
+	var iccChunks [][]byte
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil, nil, fmt.Errorf("invalid JPEG marker at offset %d", i)
+		}
+		marker := data[i+1]
+		if marker == 0xd8 || (marker >= 0xd0 && marker <= 0xd7) || marker == 0xff {
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			break // start of scan, no more metadata
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, nil, fmt.Errorf("truncated JPEG segment at offset %d", i)
+		}
+		payload := data[i+4 : i+2+length]
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			exif = payload[6:]
+		case marker == 0xe2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")) && len(payload) > 14:
+			// ICC profiles are split into numbered chunks
+			seq := int(payload[12])
+			for len(iccChunks) < seq {
+				iccChunks = append(iccChunks, nil)
+			}
+			if seq > 0 {
+				iccChunks[seq-1] = payload[14:]
+			}
+		}
+		i += 2 + length
+	}
+	if len(iccChunks) > 0 {
+		icc = bytes.Join(iccChunks, nil)
+	}
+	return exif, icc, nil
+}
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// Helper function to find the EXIF, ICC and sRGB chunks of a PNG image
+func pngMetadataChunks(data []byte) (exif, icc []byte, srgb bool, err error) {
+	for i := len(pngSignature); i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		if i+12+length > len(data) {
+			return nil, nil, false, fmt.Errorf("truncated PNG chunk %q", typ)
+		}
+		payload := data[i+8 : i+8+length]
+		switch typ {
+		case "eXIf":
+			exif = payload
+		case "sRGB":
+			srgb = true
+		case "iCCP":
+			// profile name, null separator, compression method, compressed profile
+			sep := bytes.IndexByte(payload, 0)
+			if sep < 0 || sep+2 > len(payload) {
+				return nil, nil, false, fmt.Errorf("invalid iCCP chunk")
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(payload[sep+2:]))
+			if err != nil {
+				return nil, nil, false, err
+			}
+			icc, err = io.ReadAll(zr)
+			if err != nil {
+				return nil, nil, false, err
+			}
+		case "IEND":
+			return exif, icc, srgb, nil
+		}
+		i += 12 + length
+	}
+	return exif, icc, srgb, nil
+}
+
+// EXIF tags read by parseEXIF
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+	tagGPSAltitudeRef   = 0x0005
+	tagGPSAltitude      = 0x0006
+)
+
+// exifEntry is a single entry of an EXIF IFD
+type exifEntry struct {
+	typ         uint16
+	count       uint32
+	valueOffset int // offset of the value within the TIFF structure
+}
+
+// Helper function to parse the TIFF structure of EXIF data into the metadata
+func parseEXIF(meta *imageMetadata) error {
+	data := meta.exif
+	if len(data) < 8 {
+		return fmt.Errorf("too short")
+	}
+	switch string(data[:2]) {
+	case "II":
+		meta.byteOrder = binary.LittleEndian
+	case "MM":
+		meta.byteOrder = binary.BigEndian
+	default:
+		return fmt.Errorf("unknown byte order")
+	}
+	bo := meta.byteOrder
+
+	readIFD := func(offset int) (map[uint16]exifEntry, error) {
+		if offset < 8 || offset+2 > len(data) {
+			return nil, fmt.Errorf("IFD offset %d out of range", offset)
+		}
+		n := int(bo.Uint16(data[offset:]))
+		entries := make(map[uint16]exifEntry, n)
+		for i := 0; i < n; i++ {
+			p := offset + 2 + i*12
+			if p+12 > len(data) {
+				return nil, fmt.Errorf("truncated IFD")
+			}
+			e := exifEntry{typ: bo.Uint16(data[p+2:]), count: bo.Uint32(data[p+4:]), valueOffset: p + 8}
+			if exifTypeSize(e.typ)*int(e.count) > 4 {
+				e.valueOffset = int(bo.Uint32(data[p+8:]))
+			}
+			if e.valueOffset+exifTypeSize(e.typ)*int(e.count) > len(data) {
+				continue // ignore entries pointing outside of the data
+			}
+			entries[bo.Uint16(data[p:])] = e
+		}
+		return entries, nil
+	}
+	str := func(e exifEntry) string {
+		// entries of unknown types pass readIFD's bounds check with a size of 0
+		if e.typ != 2 || e.valueOffset+int(e.count) > len(data) {
+			return ""
+		}
+		return strings.TrimRight(string(data[e.valueOffset:e.valueOffset+int(e.count)]), "\x00 ")
+	}
+	rational := func(e exifEntry, i int) float64 {
+		p := e.valueOffset + i*8
+		num, den := bo.Uint32(data[p:]), bo.Uint32(data[p+4:])
+		if den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	}
+	degrees := func(e exifEntry) float64 {
+		if e.count < 3 {
+			return 0
+		}
+		return rational(e, 0) + rational(e, 1)/60 + rational(e, 2)/3600
+	}
+
+	ifd0, err := readIFD(int(bo.Uint32(data[4:])))
+	if err != nil {
+		return err
+	}
+	if e, ok := ifd0[tagMake]; ok {
+		meta.Make = str(e)
+	}
+	if e, ok := ifd0[tagModel]; ok {
+		meta.Model = str(e)
+	}
+	if e, ok := ifd0[tagSoftware]; ok {
+		meta.Software = str(e)
+	}
+	if e, ok := ifd0[tagDateTime]; ok {
+		meta.DateTime = str(e)
+	}
+	if e, ok := ifd0[tagOrientation]; ok && e.typ == 3 {
+		meta.Orientation = int(bo.Uint16(data[e.valueOffset:]))
+		meta.orientationOffset = e.valueOffset
+	}
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := readIFD(int(bo.Uint32(data[e.valueOffset:]))); err == nil {
+			if e, ok := sub[tagDateTimeOriginal]; ok {
+				meta.DateTime = str(e)
+			}
+		}
+	}
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := readIFD(int(bo.Uint32(data[e.valueOffset:]))); err == nil {
+			lat, okLat := gps[tagGPSLatitude]
+			lon, okLon := gps[tagGPSLongitude]
+			if okLat && okLon && lat.typ == 5 && lon.typ == 5 {
+				meta.HasGPS = true
+				meta.GPSLatitude = degrees(lat)
+				meta.GPSLongitude = degrees(lon)
+				if ref, ok := gps[tagGPSLatitudeRef]; ok && str(ref) == "S" {
+					meta.GPSLatitude = -meta.GPSLatitude
+				}
+				if ref, ok := gps[tagGPSLongitudeRef]; ok && str(ref) == "W" {
+					meta.GPSLongitude = -meta.GPSLongitude
+				}
+				if alt, ok := gps[tagGPSAltitude]; ok && alt.typ == 5 {
+					meta.GPSAltitude = rational(alt, 0)
+					if ref, ok := gps[tagGPSAltitudeRef]; ok && data[ref.valueOffset] == 1 {
+						meta.GPSAltitude = -meta.GPSAltitude
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Helper function to get the size in bytes of an EXIF value type
+func exifTypeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7: // byte, ascii, sbyte, undefined
+		return 1
+	case 3, 8: // short, sshort
+		return 2
+	case 4, 9, 11: // long, slong, float
+		return 4
+	case 5, 10, 12: // rational, srational, double
+		return 8
+	}
+	return 0
+}
+
+// Helper function to classify an ICC profile by its description
+func iccProfileName(icc []byte) string {
+	desc := iccDescription(icc)
+	switch {
+	case strings.Contains(desc, "P3"):
+		return profileDisplayP3
+	case strings.Contains(desc, "sRGB"):
+		return profileSRGB
+	case desc == "":
+		return "unknown"
+	}
+	return desc
+}
+
+// Helper function to read the description tag of an ICC profile (v2 'desc' or v4 'mluc')
+func iccDescription(icc []byte) string {
+	if len(icc) < 132 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint32(icc[128:]))
+	for i := 0; i < n; i++ {
+		p := 132 + i*12
+		if p+12 > len(icc) {
+			return ""
+		}
+		if string(icc[p:p+4]) != "desc" {
+			continue
+		}
+		off := int(binary.BigEndian.Uint32(icc[p+4:]))
+		size := int(binary.BigEndian.Uint32(icc[p+8:]))
+		if off+size > len(icc) || size < 12 {
+			return ""
+		}
+		tag := icc[off : off+size]
+		switch string(tag[:4]) {
+		case "desc":
+			l := int(binary.BigEndian.Uint32(tag[8:]))
+			if 12+l > len(tag) {
+				return ""
+			}
+			return strings.TrimRight(string(tag[12:12+l]), "\x00")
+		case "mluc":
+			if len(tag) < 28 {
+				return ""
+			}
+			l := int(binary.BigEndian.Uint32(tag[20:]))
+			o := int(binary.BigEndian.Uint32(tag[24:]))
+			if o+l > len(tag) {
+				return ""
+			}
+			u := make([]uint16, l/2)
+			for j := range u {
+				u[j] = binary.BigEndian.Uint16(tag[o+j*2:])
+			}
+			return string(utf16.Decode(u))
+		}
+		return ""
+	}
+	return ""
+}
+
+// Helper function to rotate and flip an image according to an EXIF orientation
+func applyOrientation(img *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w // orientations 5 to 8 swap the axes
+	}
+	result := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			result.SetNRGBA(dx, dy, img.NRGBAAt(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return result
+}
+
+// Helper function to convert an image from Display P3 to sRGB, both share the same transfer function
+func displayP3ToSRGB(img *image.NRGBA) *image.NRGBA {
+	result := image.NewNRGBA(img.Bounds())
+	toLinear := func(v uint8) float64 {
+		return srgbToLinear(float64(v) / 255.0)
+	}
+	fromLinear := func(f float64) uint8 {
+		return uint8(math.Round(linearToSRGB(math.Clamp(f, 0, 1)) * 255))
+	}
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b := toLinear(img.Pix[i]), toLinear(img.Pix[i+1]), toLinear(img.Pix[i+2])
+		result.Pix[i] = fromLinear(1.2249401*r - 0.2249404*g)
+		result.Pix[i+1] = fromLinear(-0.0420569*r + 1.0420571*g)
+		result.Pix[i+2] = fromLinear(-0.0196376*r - 0.0786361*g + 1.0982735*b)
+		result.Pix[i+3] = img.Pix[i+3]
+	}
+	return result
+}
+
+// Helper function to insert EXIF and ICC segments into an encoded JPEG image
+func embedJPEGMetadata(jpg, exif, icc []byte) ([]byte, error) {
+	const maxPayload = 0xffff - 2
+	var segments bytes.Buffer
+	writeSegment := func(marker byte, payload ...[]byte) {
+		n := 0
+		for _, p := range payload {
+			n += len(p)
+		}
+		segments.Write([]byte{0xff, marker})
+		binary.Write(&segments, binary.BigEndian, uint16(n+2))
+		for _, p := range payload {
+			segments.Write(p)
+		}
+	}
+
+	if exif != nil {
+		if len(exif)+6 > maxPayload {
+			return nil, fmt.Errorf("EXIF data too large for a JPEG segment")
+		}
+		writeSegment(0xe1, []byte("Exif\x00\x00"), exif)
+	}
+	if icc != nil {
+		const chunkSize = maxPayload - 14
+		count := (len(icc) + chunkSize - 1) / chunkSize
+		if count > 255 {
+			return nil, fmt.Errorf("ICC profile too large")
+		}
+		for i := 0; i < count; i++ {
+			chunk := icc[i*chunkSize : min((i+1)*chunkSize, len(icc))]
+			writeSegment(0xe2, []byte("ICC_PROFILE\x00"), []byte{byte(i + 1), byte(count)}, chunk)
+		}
+	}
+
+	// segments go right after the SOI marker
+	out := make([]byte, 0, len(jpg)+segments.Len())
+	out = append(out, jpg[:2]...)
+	out = append(out, segments.Bytes()...)
+	return append(out, jpg[2:]...), nil
+}
+
+// Helper function to insert eXIf and iCCP chunks into an encoded PNG image
+func embedPNGMetadata(png, exif, icc []byte) ([]byte, error) {
+	var chunks bytes.Buffer
+	writeChunk := func(typ string, payload []byte) {
+		binary.Write(&chunks, binary.BigEndian, uint32(len(payload)))
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(typ))
+		crc.Write(payload)
+		chunks.WriteString(typ)
+		chunks.Write(payload)
+		binary.Write(&chunks, binary.BigEndian, crc.Sum32())
+	}
+
+	if icc != nil {
+		var compressed bytes.Buffer
+		compressed.WriteString("ICC profile\x00\x00")
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(icc); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		writeChunk("iCCP", compressed.Bytes())
+	}
+	if exif != nil {
+		writeChunk("eXIf", exif)
+	}
+
+	// chunks go right after the IHDR chunk
+	ihdrEnd := len(pngSignature) + 12 + int(binary.BigEndian.Uint32(png[len(pngSignature):]))
+	out := make([]byte, 0, len(png)+chunks.Len())
+	out = append(out, png[:ihdrEnd]...)
+	out = append(out, chunks.Bytes()...)
+	return append(out, png[ihdrEnd:]...), nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// pyramid is a Gaussian or Laplacian image pyramid, level 0 has the size of the image and
+// every further level half the size of the previous one. The planes are premultiplied.
+type pyramid struct {
+	laplacian bool
+	levels    []*channelPlanes
+}
+
+func (p *pyramid) String() string {
+	kind := "gaussian"
+	if p.laplacian {
+		kind = "laplacian"
+	}
+	sizes := make([]string, len(p.levels))
+	for i, l := range p.levels {
+		sizes[i] = fmt.Sprintf("%dx%d", l.w, l.h)
+	}
+	return fmt.Sprintf("%s pyramid with %d levels: %s", kind, len(p.levels), strings.Join(sizes, ", "))
+}
+
+// @Name: pyramid-gaussian
+// @Desc: Builds a Gaussian pyramid, each level is a blurred copy of the previous one at half the size
+// @Param:      img     - 	-   	-   The image
+// @Param:      levels  - 	0..   	0   The number of levels, 0 builds levels down to about 8 pixels
+// @Returns:    result  - 	-   	-   The pyramid
+func pyramidGaussian(img image.Image, levels int) (*pyramid, error) {
+	p, levels, err := imagePyramid(img, levels)
+	if err != nil {
+		return nil, err
+	}
+	res := &pyramid{levels: make([]*channelPlanes, levels)}
+	for ch := range p.c {
+		for l, plane := range gaussianPyramid(p.c[ch], p.w, p.h, levels) {
+			if res.levels[l] == nil {
+				res.levels[l] = pyramidLevelPlanes(p, l)
+			}
+			res.levels[l].c[ch] = plane
+		}
+	}
+	return res, nil
+}
+
+// @Name: pyramid-laplacian
+// @Desc: Builds a Laplacian pyramid, each level holds the detail lost between two levels of the Gaussian pyramid and the last level the remaining low frequencies
+// @Param:      img     - 	-   	-   The image
+// @Param:      levels  - 	0..   	0   The number of levels, 0 builds levels down to about 8 pixels
+// @Returns:    result  - 	-   	-   The pyramid
+func pyramidLaplacian(img image.Image, levels int) (*pyramid, error) {
+	p, levels, err := imagePyramid(img, levels)
+	if err != nil {
+		return nil, err
+	}
+	res := &pyramid{laplacian: true, levels: make([]*channelPlanes, levels)}
+	for ch := range p.c {
+		for l, plane := range laplacianPyramid(p.c[ch], p.w, p.h, levels) {
+			if res.levels[l] == nil {
+				res.levels[l] = pyramidLevelPlanes(p, l)
+			}
+			res.levels[l].c[ch] = plane
+		}
+	}
+	return res, nil
+}
+
+// @Name: pyramid-level
+// @Desc: Returns a level of a pyramid as image, detail levels of a Laplacian pyramid are shown around middle gray
+// @Param:      p       - 	-   	-   The pyramid
+// @Param:      level   - 	0..   	0   The level, 0 is the largest
+// @Returns:    result  - 	-   	-   The level
+func pyramidLevel(p *pyramid, level int) (*image.NRGBA, error) {
+	if level < 0 || level >= len(p.levels) {
+		return nil, fmt.Errorf("level must be between 0 and %d", len(p.levels)-1)
+	}
+	l := p.levels[level]
+	if !p.laplacian || level == len(p.levels)-1 {
+		return l.toNRGBA(), nil
+	}
+	detail := &channelPlanes{rect: l.rect, w: l.w, h: l.h}
+	for ch := 0; ch < 3; ch++ {
+		detail.c[ch] = make([]float64, len(l.c[ch]))
+		for i, v := range l.c[ch] {
+			detail.c[ch][i] = v + 0.5
+		}
+	}
+	detail.c[3] = make([]float64, len(l.c[3]))
+	for i := range detail.c[3] {
+		detail.c[3][i] = 1
+	}
+	return detail.toNRGBA(), nil
+}
+
+// @Name: pyramid-collapse
+// @Desc: Reconstructs the image from a Laplacian pyramid, for a Gaussian pyramid the largest level is returned
+// @Param:      p       - 	-   	-   The pyramid
+// @Returns:    result  - 	-   	-   The image
+func pyramidCollapse(p *pyramid) (*image.NRGBA, error) {
+	if !p.laplacian {
+		return p.levels[0].toNRGBA(), nil
+	}
+	return collapseLevels(p.levels).toNRGBA(), nil
+}
+
+// @Name: multiband-blend
+// @Desc: Blends two images along a mask without visible seams, every frequency band is blended over a transition as wide as its wavelength (Burt and Adelson)
+// @Param:      a       - 	-   	-   The image shown where the mask is white
+// @Param:      b       - 	-   	-   The image shown where the mask is black
+// @Param:      mask    - 	-   	-   The mask, its luminance selects between the images
+// @Param:      levels  - 	0..   	0   The number of pyramid levels, more levels give wider transitions for low frequencies, 0 uses levels down to about 8 pixels
+// @Returns:    result  - 	-   	-   The blended image
+func multibandBlend(a, b, mask image.Image, levels int) (image.Image, error) {
+	if a.Bounds().Size() != b.Bounds().Size() || a.Bounds().Size() != mask.Bounds().Size() {
+		return nil, fmt.Errorf("images and mask must have the same size, got %v, %v and %v", a.Bounds().Size(), b.Bounds().Size(), mask.Bounds().Size())
+	}
+	pa, levels, err := imagePyramid(a, levels)
+	if err != nil {
+		return nil, err
+	}
+	m := toNRGBA(mask)
+	weights := make([]float64, 0, pa.w*pa.h)
+	forEachNRGBA(m, func(c color.NRGBA) {
+		weights = append(weights, luminance(c)/255*float64(c.A)/255)
+	})
+	return blendPlanes(pa, newChannelPlanes(b), weights, levels).toImageLike(a), nil
+}
+
+// Helper function to split an image into channel planes and check the number of pyramid levels,
+// 0 levels is replaced by the largest useful number
+func imagePyramid(img image.Image, levels int) (*channelPlanes, int, error) {
+	p := newChannelPlanes(img)
+	if p.w == 0 || p.h == 0 {
+		return nil, 0, fmt.Errorf("image is empty")
+	}
+	maxLevels := pyramidLevels(p.w, p.h)
+	if levels == 0 {
+		levels = maxLevels
+	}
+	if levels < 1 || levels > maxLevels {
+		return nil, 0, fmt.Errorf("levels must be between 1 and %d for a %dx%d image", maxLevels, p.w, p.h)
+	}
+	return p, levels, nil
+}
+
+// Helper function to create empty planes of the size of a pyramid level, level 0 keeps the bounds of the image
+func pyramidLevelPlanes(p *channelPlanes, level int) *channelPlanes {
+	w, h := pyramidSize(p.w, p.h, level)
+	rect := image.Rect(0, 0, w, h)
+	if level == 0 {
+		rect = p.rect
+	}
+	return &channelPlanes{rect: rect, w: w, h: h}
+}
+
+// Helper function to reconstruct the planes of an image from the levels of its Laplacian pyramid
+func collapseLevels(levels []*channelPlanes) *channelPlanes {
+	res := &channelPlanes{rect: levels[0].rect, w: levels[0].w, h: levels[0].h}
+	for ch := range res.c {
+		bands := make([][]float64, len(levels))
+		for l, lp := range levels {
+			bands[l] = lp.c[ch]
+		}
+		res.c[ch] = collapsePyramid(bands, res.w, res.h)
+	}
+	return res
+}
+
+// Helper function to blend the planes of two images of the same size with Laplacian pyramids,
+// weights selects a (1) or b (0) per pixel and is blurred by a Gaussian pyramid
+func blendPlanes(a, b *channelPlanes, weights []float64, levels int) *channelPlanes {
+	w, h := a.w, a.h
+	wp := gaussianPyramid(weights, w, h, levels)
+	res := &channelPlanes{rect: a.rect, w: w, h: h}
+	for ch := range a.c {
+		la, lb := laplacianPyramid(a.c[ch], w, h, levels), laplacianPyramid(b.c[ch], w, h, levels)
+		for l := range la {
+			for i := range la[l] {
+				la[l][i] = wp[l][i]*la[l][i] + (1-wp[l][i])*lb[l][i]
+			}
+		}
+		res.c[ch] = collapsePyramid(la, w, h)
+	}
+	return res
+}
+
+// pyramidKernel is the 5-tap binomial filter used to build image pyramids
+var pyramidKernel = [5]float64{1.0 / 16, 4.0 / 16, 6.0 / 16, 4.0 / 16, 1.0 / 16}
+
+// Helper function to get the number of pyramid levels for an image, the smallest level is at least 8 pixels wide and high
+func pyramidLevels(w, h int) int {
+	levels := 1
+	for s := min(w, h); s >= 16; s = (s + 1) / 2 {
+		levels++
+	}
+	return levels
+}
+
+// Helper function to blur a plane and drop every second row and column
+func pyrDown(p []float64, w, h int) ([]float64, int, int) {
+	dw, dh := (w+1)/2, (h+1)/2
+	tmp := make([]float64, dw*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < dw; x++ {
+			var s float64
+			for k, kv := range pyramidKernel {
+				s += kv * p[y*w+mirrorIndex(2*x+k-2, w)]
+			}
+			tmp[y*dw+x] = s
+		}
+	}
+	res := make([]float64, dw*dh)
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var s float64
+			for k, kv := range pyramidKernel {
+				s += kv * tmp[mirrorIndex(2*y+k-2, h)*dw+x]
+			}
+			res[y*dw+x] = s
+		}
+	}
+	return res, dw, dh
+}
+
+// Helper function to upsample a plane to tw x th, interpolating with the pyramid kernel
+func pyrUp(p []float64, w, h, tw, th int) []float64 {
+	tmp := make([]float64, tw*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < tw; x++ {
+			var s float64
+			for k, kv := range pyramidKernel {
+				if sx := x + k - 2; sx&1 == 0 {
+					s += 2 * kv * p[y*w+mirrorIndex(sx>>1, w)]
+				}
+			}
+			tmp[y*tw+x] = s
+		}
+	}
+	res := make([]float64, tw*th)
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			var s float64
+			for k, kv := range pyramidKernel {
+				if sy := y + k - 2; sy&1 == 0 {
+					s += 2 * kv * tmp[mirrorIndex(sy>>1, h)*tw+x]
+				}
+			}
+			res[y*tw+x] = s
+		}
+	}
+	return res
+}
+
+// Helper function to build a Gaussian pyramid, the first level is the plane itself
+func gaussianPyramid(p []float64, w, h, levels int) [][]float64 {
+	pyr := [][]float64{p}
+	for l := 1; l < levels; l++ {
+		p, w, h = pyrDown(p, w, h)
+		pyr = append(pyr, p)
+	}
+	return pyr
+}
+
+// Helper function to build a Laplacian pyramid, each level holds the detail lost by the next level
+// of the Gaussian pyramid, the last level is the smallest Gaussian level
+func laplacianPyramid(p []float64, w, h, levels int) [][]float64 {
+	g := gaussianPyramid(p, w, h, levels)
+	pyr := make([][]float64, levels)
+	for l := 0; l < levels-1; l++ {
+		lw, lh := pyramidSize(w, h, l)
+		nw, nh := pyramidSize(w, h, l+1)
+		up := pyrUp(g[l+1], nw, nh, lw, lh)
+		pyr[l] = make([]float64, len(g[l]))
+		for i := range up {
+			pyr[l][i] = g[l][i] - up[i]
+		}
+	}
+	pyr[levels-1] = g[levels-1]
+	return pyr
+}
+
+// Helper function to reconstruct a plane of size w x h from its Laplacian pyramid
+func collapsePyramid(pyr [][]float64, w, h int) []float64 {
+	levels := len(pyr)
+	res := pyr[levels-1]
+	for l := levels - 2; l >= 0; l-- {
+		lw, lh := pyramidSize(w, h, l)
+		nw, nh := pyramidSize(w, h, l+1)
+		up := pyrUp(res, nw, nh, lw, lh)
+		for i := range up {
+			up[i] += pyr[l][i]
+		}
+		res = up
+	}
+	return res
+}
+
+// Helper function to get the size of a pyramid level
+func pyramidSize(w, h, level int) (int, int) {
+	for ; level > 0; level-- {
+		w, h = (w+1)/2, (h+1)/2
+	}
+	return w, h
+}
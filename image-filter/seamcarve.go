@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+
+	"github.com/toxyl/math"
+)
+
+var (
+	// @Name:  seam-protect-mask
+	// @Desc:  Mask of areas seam-carve must not remove, white areas are protected, none if not set
+	// @Range: -
+	// @Unit:  -
+	seamProtectMask *image.NRGBA
+
+	// @Name:  seam-remove-mask
+	// @Desc:  Mask of areas seam-carve removes first, white areas are removed, none if not set
+	// @Range: -
+	// @Unit:  -
+	seamRemoveMask *image.NRGBA
+
+	// @Name:  seam-forward-energy
+	// @Desc:  Whether seam-carve picks seams by the energy they introduce (forward energy) instead of the energy they remove
+	// @Range: -
+	// @Unit:  -
+	seamForwardEnergy = false
+)
+
+// maskBias is the energy added to protected pixels and subtracted from pixels to remove,
+// large enough to outweigh any gradient a seam can cross
+const maskBias = 1e8
+
+// carver holds the state of an image during seam carving, row-major with the current width
+type carver struct {
+	w, h int
+	pix  []color.NRGBA
+	bias []float64
+	cols []int // column of each pixel in the image before carving started
+}
+
+// @Name: seam-carve
+// @Desc: Resizes an image by removing or duplicating the paths of least importance (seams) instead of scaling, so the main subjects keep their shape
+// @Param:      img     - 	-   	-   The image to resize
+// @Param:      new-w   px 	1..   	-   The new width
+// @Param:      new-h   px 	1..   	-   The new height
+// @Returns:    result  - 	-   	-   The resized image
+func seamCarve(img *image.NRGBA, newW, newH int) (*image.NRGBA, error) {
+	b := img.Bounds()
+	if newW < 1 || newH < 1 {
+		return nil, fmt.Errorf("new size must be at least 1x1")
+	}
+	if newW > 2*b.Dx() || newH > 2*b.Dy() {
+		return nil, fmt.Errorf("seam-carve can at most double the size of an image")
+	}
+	c, err := newCarver(img)
+	if err != nil {
+		return nil, err
+	}
+	c.resizeWidth(newW)
+	c.transpose()
+	c.resizeWidth(newH)
+	c.transpose()
+	return c.image(), nil
+}
+
+// Helper function to prepare the carving state of an image and the masks set in the DSL variables
+func newCarver(img *image.NRGBA) (*carver, error) {
+	b := img.Bounds()
+	c := &carver{w: b.Dx(), h: b.Dy(), bias: make([]float64, b.Dx()*b.Dy())}
+	forEachNRGBA(img, func(col color.NRGBA) { c.pix = append(c.pix, col) })
+	for _, m := range []struct {
+		mask *image.NRGBA
+		bias float64
+	}{{seamProtectMask, maskBias}, {seamRemoveMask, -maskBias}} {
+		if m.mask == nil {
+			continue
+		}
+		if err := checkSameSize(img, m.mask); err != nil {
+			return nil, fmt.Errorf("seam mask: %w", err)
+		}
+		for i, l := range luminancePlane(m.mask) {
+			if l >= 128 {
+				c.bias[i] += m.bias
+			}
+		}
+	}
+	return c, nil
+}
+
+// Helper function to remove or insert vertical seams until the image has the given width
+func (c *carver) resizeWidth(w int) {
+	for c.w > w {
+		c.removeSeam(c.findSeam())
+	}
+	for c.w < w {
+		// seams are inserted in batches of at most half the width so the
+		// same low energy seam is not duplicated over and over
+		c.insertSeams(min(w-c.w, max(c.w/2, 1)))
+	}
+}
+
+// Helper function to compute the energy of every pixel from the gradient magnitude
+func (c *carver) energy() []float64 {
+	return sobelMagnitude(c.luminance(), c.w, c.h)
+}
+
+// Helper function to get the luminance of every pixel
+func (c *carver) luminance() []float64 {
+	lum := make([]float64, len(c.pix))
+	for i, p := range c.pix {
+		lum[i] = luminance(p)
+	}
+	return lum
+}
+
+// Helper function to find the vertical seam with the lowest cumulative energy using
+// dynamic programming, returns the column of the seam in each row
+func (c *carver) findSeam() []int {
+	w, h := c.w, c.h
+	cost := make([]float64, w*h)
+	from := make([]int8, w*h) // -1, 0 or 1: offset of the column in the previous row
+
+	var energy, lum []float64
+	if seamForwardEnergy {
+		lum = c.luminance()
+	} else {
+		energy = c.energy()
+	}
+	at := func(x, y int) float64 { return lum[y*w+min(max(x, 0), w-1)] }
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			var steps [3]float64 // cost of coming from the left, middle and right column
+			if seamForwardEnergy {
+				// energy of the new edges that appear when the pixel is removed
+				up := math.Abs(at(x+1, y) - at(x-1, y))
+				steps[1] = up
+				if y > 0 {
+					steps[0] = up + math.Abs(at(x, y-1)-at(x-1, y))
+					steps[2] = up + math.Abs(at(x, y-1)-at(x+1, y))
+				}
+			} else {
+				steps = [3]float64{energy[i], energy[i], energy[i]}
+			}
+			if y == 0 {
+				cost[i] = steps[1] + c.bias[i]
+				continue
+			}
+			best := math.Inf(1)
+			for d := -1; d <= 1; d++ {
+				if px := x + d; px >= 0 && px < w {
+					if v := cost[(y-1)*w+px] + steps[d+1]; v < best {
+						best, from[i] = v, int8(d)
+					}
+				}
+			}
+			cost[i] = best + c.bias[i]
+		}
+	}
+
+	seam := make([]int, h)
+	last := (h - 1) * w
+	for x := 1; x < w; x++ {
+		if cost[last+x] < cost[last+seam[h-1]] {
+			seam[h-1] = x
+		}
+	}
+	for y := h - 1; y > 0; y-- {
+		seam[y-1] = seam[y] + int(from[y*w+seam[y]])
+	}
+	return seam
+}
+
+// Helper function to remove a vertical seam
+func (c *carver) removeSeam(seam []int) {
+	w := c.w - 1
+	for y, sx := range seam {
+		src, dst := y*c.w, y*w
+		for _, s := range [][2]int{{0, sx}, {sx + 1, c.w}} {
+			n := s[1] - s[0]
+			copy(c.pix[dst:dst+n], c.pix[src+s[0]:src+s[1]])
+			copy(c.bias[dst:dst+n], c.bias[src+s[0]:src+s[1]])
+			if c.cols != nil {
+				copy(c.cols[dst:dst+n], c.cols[src+s[0]:src+s[1]])
+			}
+			dst += n
+		}
+	}
+	c.w = w
+	c.pix = c.pix[:w*c.h]
+	c.bias = c.bias[:w*c.h]
+	if c.cols != nil {
+		c.cols = c.cols[:w*c.h]
+	}
+}
+
+// Helper function to widen the image by n seams: the n seams that would be removed
+// first are found on a copy and then duplicated in the image, blended with their right neighbor
+func (c *carver) insertSeams(n int) {
+	tmp := &carver{
+		w:    c.w,
+		h:    c.h,
+		pix:  append([]color.NRGBA(nil), c.pix...),
+		bias: append([]float64(nil), c.bias...),
+		cols: make([]int, len(c.pix)),
+	}
+	for i := range tmp.cols {
+		tmp.cols[i] = i % c.w
+	}
+	dup := make([][]int, c.h)
+	for k := 0; k < n; k++ {
+		seam := tmp.findSeam()
+		for y, x := range seam {
+			dup[y] = append(dup[y], tmp.cols[y*tmp.w+x])
+		}
+		tmp.removeSeam(seam)
+	}
+
+	w := c.w + n
+	pix := make([]color.NRGBA, 0, w*c.h)
+	bias := make([]float64, 0, w*c.h)
+	for y := 0; y < c.h; y++ {
+		sort.Ints(dup[y])
+		row := c.pix[y*c.w : (y+1)*c.w]
+		next := 0
+		for x, p := range row {
+			pix = append(pix, p)
+			bias = append(bias, c.bias[y*c.w+x])
+			for next < len(dup[y]) && dup[y][next] == x {
+				pix = append(pix, averageNRGBA(p, row[min(x+1, c.w-1)]))
+				bias = append(bias, c.bias[y*c.w+x])
+				next++
+			}
+		}
+	}
+	c.w, c.pix, c.bias = w, pix, bias
+}
+
+// Helper function to swap rows and columns so horizontal seams can be handled as vertical ones
+func (c *carver) transpose() {
+	pix := make([]color.NRGBA, len(c.pix))
+	bias := make([]float64, len(c.bias))
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			pix[x*c.h+y] = c.pix[y*c.w+x]
+			bias[x*c.h+y] = c.bias[y*c.w+x]
+		}
+	}
+	c.w, c.h, c.pix, c.bias = c.h, c.w, pix, bias
+}
+
+// Helper function to convert the carving state to an image
+func (c *carver) image() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, c.w, c.h))
+	for i, p := range c.pix {
+		img.SetNRGBA(i%c.w, i/c.w, p)
+	}
+	return img
+}
+
+// Helper function to average two colors
+func averageNRGBA(a, b color.NRGBA) color.NRGBA {
+	avg := func(x, y uint8) uint8 { return uint8((int(x) + int(y) + 1) / 2) }
+	return color.NRGBA{R: avg(a.R, b.R), G: avg(a.G, b.G), B: avg(a.B, b.B), A: avg(a.A, b.A)}
+}
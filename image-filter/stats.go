@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/toxyl/math"
+)
+
+// imageInfo describes the properties of an image as returned by info
+type imageInfo struct {
+	Width      int
+	Height     int
+	ColorModel string
+	Origin     image.Point
+	HasAlpha   bool
+	BitDepth   int // bits per channel
+}
+
+func (i imageInfo) String() string {
+	return fmt.Sprintf("%dx%d, %s, origin %v, alpha: %v, %d bit", i.Width, i.Height, i.ColorModel, i.Origin, i.HasAlpha, i.BitDepth)
+}
+
+// @Name: info
+// @Desc: Returns basic information about an image
+// @Param:      img     - -   -   The image to inspect
+// @Returns:    result  - -   -   Width, height, color model, bounds origin, whether it has alpha and the bit depth per channel
+func info(img image.Image) (imageInfo, error) {
+	b := img.Bounds()
+	res := imageInfo{Width: b.Dx(), Height: b.Dy(), Origin: b.Min, BitDepth: 8}
+
+	switch v := img.(type) {
+	case *image.NRGBA:
+		res.ColorModel = "NRGBA"
+	case *image.RGBA:
+		res.ColorModel = "RGBA"
+	case *image.NRGBA64:
+		res.ColorModel, res.BitDepth = "NRGBA64", 16
+	case *image.RGBA64:
+		res.ColorModel, res.BitDepth = "RGBA64", 16
+	case *image.Gray:
+		res.ColorModel = "Gray"
+	case *image.Gray16:
+		res.ColorModel, res.BitDepth = "Gray16", 16
+	case *image.YCbCr:
+		res.ColorModel = "YCbCr " + v.SubsampleRatio.String()
+	case *image.CMYK:
+		res.ColorModel = "CMYK"
+	case *image.Paletted:
+		res.ColorModel = fmt.Sprintf("Paletted (%d colors)", len(v.Palette))
+	default:
+		res.ColorModel = fmt.Sprintf("%T", img)
+	}
+
+	// an image only has alpha if at least one pixel is not fully opaque
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		res.HasAlpha = !o.Opaque()
+	} else {
+		for y := b.Min.Y; y < b.Max.Y && !res.HasAlpha; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+					res.HasAlpha = true
+					break
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// @Name: mean-color
+// @Desc: Returns the average color of an image
+// @Param:      img     - -   -   The image to inspect
+// @Returns:    result  - -   -   The average color as color.RGBA64
+func meanColor(img *image.NRGBA) (color.RGBA64, error) {
+	n := float64(img.Bounds().Dx() * img.Bounds().Dy())
+	if n == 0 {
+		return color.RGBA64{}, fmt.Errorf("image is empty")
+	}
+	var r, g, b, a float64
+	forEachNRGBA(img, func(c color.NRGBA) {
+		r += float64(c.R)
+		g += float64(c.G)
+		b += float64(c.B)
+		a += float64(c.A)
+	})
+	scale := 65535.0 / 255.0 / n
+	return color.RGBA64{
+		R: uint16(math.Round(r * scale)),
+		G: uint16(math.Round(g * scale)),
+		B: uint16(math.Round(b * scale)),
+		A: uint16(math.Round(a * scale)),
+	}, nil
+}
+
+// @Name: mean-luminance
+// @Desc: Returns the average luminance of an image
+// @Param:      img     - -   		-   The image to inspect
+// @Returns:    result  "%" 0.0..1.0   	0   The average luminance
+func meanLuminance(img *image.NRGBA) (float64, error) {
+	mean, _, err := luminanceStats(img)
+	return mean, err
+}
+
+// @Name: std-dev
+// @Desc: Returns the standard deviation of the luminance of an image, a measure of its contrast
+// @Param:      img     - -   		-   The image to inspect
+// @Returns:    result  "%" 0.0..0.5   	0   The standard deviation of the luminance
+func stdDev(img *image.NRGBA) (float64, error) {
+	_, sd, err := luminanceStats(img)
+	return sd, err
+}
+
+// @Name: min-luminance
+// @Desc: Returns the luminance of the darkest pixel of an image
+// @Param:      img     - -   		-   The image to inspect
+// @Returns:    result  "%" 0.0..1.0   	0   The minimum luminance
+func minLuminance(img *image.NRGBA) (float64, error) {
+	if img.Bounds().Empty() {
+		return 0, fmt.Errorf("image is empty")
+	}
+	res := 1.0
+	forEachNRGBA(img, func(c color.NRGBA) {
+		res = math.Min(res, luminance(c)/255.0)
+	})
+	return res, nil
+}
+
+// @Name: max-luminance
+// @Desc: Returns the luminance of the brightest pixel of an image
+// @Param:      img     - -   		-   The image to inspect
+// @Returns:    result  "%" 0.0..1.0   	0   The maximum luminance
+func maxLuminance(img *image.NRGBA) (float64, error) {
+	if img.Bounds().Empty() {
+		return 0, fmt.Errorf("image is empty")
+	}
+	res := 0.0
+	forEachNRGBA(img, func(c color.NRGBA) {
+		res = math.Max(res, luminance(c)/255.0)
+	})
+	return res, nil
+}
+
+// @Name: is-grayscale
+// @Desc: Checks whether all pixels of an image are gray
+// @Param:      img     	- -   		-   The image to inspect
+// @Param:      tolerance - 0..255  	0   Maximum difference between the channels of a pixel that is still considered gray
+// @Returns:    result  	- -   		-   Whether the image is grayscale
+func isGrayscale(img *image.NRGBA, tolerance int) (bool, error) {
+	gray := true
+	forEachNRGBA(img, func(c color.NRGBA) {
+		r, g, b := int(c.R), int(c.G), int(c.B)
+		if max(r, g, b)-min(r, g, b) > tolerance {
+			gray = false
+		}
+	})
+	return gray, nil
+}
+
+// @Name: dominant-hue
+// @Desc: Returns the most common hue of an image, weighted by saturation so that gray pixels don't count
+// @Param:      img     - -   		-   The image to inspect
+// @Returns:    result  "°" 0..360   	0   The dominant hue
+func dominantHue(img *image.NRGBA) (float64, error) {
+	const bins = 36
+	var hist [bins]float64
+	forEachNRGBA(img, func(c color.NRGBA) {
+		h, s, _ := rgbToHsl(float64(c.R)/255.0, float64(c.G)/255.0, float64(c.B)/255.0)
+		hist[int(h*bins)%bins] += s * float64(c.A) / 255.0
+	})
+
+	best := 0
+	for i := range hist {
+		if hist[i] > hist[best] {
+			best = i
+		}
+	}
+	if hist[best] == 0 {
+		return 0, fmt.Errorf("image has no saturated pixels")
+	}
+	return (float64(best) + 0.5) * 360 / bins, nil
+}
+
+// @Name: sharpness-score
+// @Desc: Returns the variance of the Laplacian of the luminance, higher values mean sharper images
+// @Param:      img     - -   		-   The image to inspect
+// @Returns:    result  - 0..   	0   The sharpness score
+func sharpnessScore(img *image.NRGBA) (float64, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 3 || h < 3 {
+		return 0, fmt.Errorf("image must be at least 3x3 pixels")
+	}
+
+	lum := luminancePlane(img)
+	var sum, sumSq float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			l := lum[i-1] + lum[i+1] + lum[i-w] + lum[i+w] - 4*lum[i]
+			sum += l
+			sumSq += l * l
+		}
+	}
+	n := float64((w - 2) * (h - 2))
+	mean := sum / n
+	return sumSq/n - mean*mean, nil
+}
+
+// Helper function to get the mean and standard deviation of the luminance on a 0..1 scale
+func luminanceStats(img *image.NRGBA) (mean, stdDev float64, err error) {
+	n := float64(img.Bounds().Dx() * img.Bounds().Dy())
+	if n == 0 {
+		return 0, 0, fmt.Errorf("image is empty")
+	}
+	var sum, sumSq float64
+	forEachNRGBA(img, func(c color.NRGBA) {
+		l := luminance(c) / 255.0
+		sum += l
+		sumSq += l * l
+	})
+	mean = sum / n
+	return mean, math.Sqrt(math.Max(sumSq/n-mean*mean, 0)), nil
+}
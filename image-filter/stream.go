@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// @Name:  stream-format
+	// @Desc:  Format used by save when writing to stdout, e.g. png, jpg or ppm
+	// @Range: -
+	// @Unit:  -
+	streamFormat = "png"
+)
+
+var (
+	storedImages   = map[string]any{}
+	storedImagesMu sync.Mutex
+
+	// stdoutUsed is set once an image has been written to stdout, so command mode
+	// doesn't mix the result of the expression into the image data
+	stdoutUsed bool
+)
+
+// imageMimeTypes maps image formats to the MIME types used in data URLs
+var imageMimeTypes = map[string]string{
+	"png":  "image/png",
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"gif":  "image/gif",
+	"pbm":  "image/x-portable-bitmap",
+	"pgm":  "image/x-portable-graymap",
+	"ppm":  "image/x-portable-pixmap",
+	"pnm":  "image/x-portable-anymap",
+	"pam":  "image/x-portable-arbitrarymap",
+	"qoi":  "image/qoi",
+	"f32":  "application/octet-stream",
+	"hdr":  "image/vnd.radiance",
+}
+
+// @Name: from-base64
+// @Desc: Decodes an image from base64, data URLs are accepted as well, the format is detected from the content
+// @Param:      data    - -   -   The base64 encoded image or data URL
+// @Returns:    result  - -   -   The decoded image
+func fromBase64(data string) (any, error) {
+	data = strings.TrimSpace(data)
+	if strings.HasPrefix(data, "data:") {
+		i := strings.Index(data, ",")
+		if i < 0 || !strings.HasSuffix(data[:i], ";base64") {
+			return nil, fmt.Errorf("only base64 encoded data URLs are supported")
+		}
+		data = data[i+1:]
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	return decodeImage(raw, "")
+}
+
+// @Name: to-base64
+// @Desc: Encodes an image as base64
+// @Param:      img     - -   	-   	The image to encode
+// @Param:      format  - -   	"png"   The image format, e.g. png, jpg or qoi
+// @Returns:    result  - -   	-   	The base64 encoded image
+func toBase64(img any, format string) (string, error) {
+	format = strings.ToLower(format)
+	if _, ok := imageMimeTypes[format]; !ok {
+		return "", fmt.Errorf("unknown image format %q", format)
+	}
+	var buf bytes.Buffer
+	if _, err := encodeImage(&buf, img, format); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// @Name: data-url
+// @Desc: Encodes an image as data URL, e.g. for the src attribute of an img tag in HTML
+// @Param:      img     - -   	-   	The image to encode
+// @Param:      format  - -   	"png"   The image format, e.g. png, jpg or gif
+// @Returns:    result  - -   	-   	The data URL
+func dataURL(img any, format string) (string, error) {
+	data, err := toBase64(img, format)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + imageMimeTypes[strings.ToLower(format)] + ";base64," + data, nil
+}
+
+// @Name: store
+// @Desc: Keeps an image in memory under a name, so it can be used by later expressions with recall
+// @Param:      name    - -   -   The name of the image
+// @Param:      img     - -   -   The image to keep
+// @Returns:    result  - -   -   The image
+func store(name string, img any) (any, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name must not be empty")
+	}
+	storedImagesMu.Lock()
+	defer storedImagesMu.Unlock()
+	storedImages[name] = img
+	return img, nil
+}
+
+// @Name: recall
+// @Desc: Returns an image kept in memory with store
+// @Param:      name    - -   -   The name of the image
+// @Returns:    result  - -   -   The image
+func recall(name string) (any, error) {
+	storedImagesMu.Lock()
+	defer storedImagesMu.Unlock()
+	img, ok := storedImages[name]
+	if !ok {
+		names := make([]string, 0, len(storedImages))
+		for n := range storedImages {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("no image stored as %q, stored images: %s", name, strings.Join(names, ", "))
+	}
+	return img, nil
+}
+
+// Helper function to read an image from stdin
+func loadStdin() (any, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(data, "")
+}
+
+// Helper function to write an image to stdout in the stream format
+func saveStdout(img any) (any, error) {
+	stdoutUsed = true
+	return encodeImage(os.Stdout, img, strings.ToLower(streamFormat))
+}
+
+// Helper function to detect the format of encoded image data from its first bytes,
+// returns an empty string if the format is unknown
+func sniffFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		return "png"
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return "jpg"
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return "gif"
+	case bytes.HasPrefix(data, []byte("qoif")):
+		return "qoi"
+	case bytes.HasPrefix(data, []byte(float32Magic+"\n")):
+		return "f32"
+	case bytes.HasPrefix(data, []byte("#?RADIANCE")), bytes.HasPrefix(data, []byte("#?RGBE")):
+		return "hdr"
+	case len(data) > 2 && data[0] == 'P' && data[1] >= '1' && data[1] <= '7':
+		return "pnm"
+	}
+	return ""
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/toxyl/math"
+)
+
+// @Name: pixelate
+// @Desc: Pixelates an image by replacing blocks with their average color
+// @Param:      img     	- 	-   -   The image to pixelate
+// @Param:      block-size 	px 	1..  8  The size of the blocks
+// @Returns:    result  	- 	-   -   The pixelated image
+func pixelate(img *image.NRGBA, blockSize int) (*image.NRGBA, error) {
+	return pixelateRegion(img, img.Bounds().Min.X, img.Bounds().Min.Y, img.Bounds().Dx(), img.Bounds().Dy(), blockSize)
+}
+
+// @Name: pixelate-region
+// @Desc: Pixelates a region of an image, e.g. to anonymize faces or license plates
+// @Param:      img     	- 	-   -   The image to pixelate
+// @Param:      x       	px 	-   0   X coordinate of the region's top-left corner
+// @Param:      y       	px 	-   0   Y coordinate of the region's top-left corner
+// @Param:      w       	px 	0.. 0   The width of the region
+// @Param:      h       	px 	0.. 0   The height of the region
+// @Param:      block-size 	px 	1..  8  The size of the blocks
+// @Returns:    result  	- 	-   -   The image with the region pixelated
+func pixelateRegion(img *image.NRGBA, x, y, w, h, blockSize int) (*image.NRGBA, error) {
+	if blockSize < 1 {
+		return nil, fmt.Errorf("block size must be at least 1")
+	}
+	result := cloneNRGBA(img)
+	region := image.Rect(x, y, x+w, y+h).Intersect(img.Bounds())
+
+	for by := region.Min.Y; by < region.Max.Y; by += blockSize {
+		for bx := region.Min.X; bx < region.Max.X; bx += blockSize {
+			block := image.Rect(bx, by, bx+blockSize, by+blockSize).Intersect(region)
+			c := averageColor(img, block)
+			for py := block.Min.Y; py < block.Max.Y; py++ {
+				for px := block.Min.X; px < block.Max.X; px++ {
+					result.SetNRGBA(px, py, c)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// @Name: mosaic
+// @Desc: Turns an image into a mosaic of irregular (Voronoi) cells filled with their average color
+// @Param:      img     	- 	-   -   The image to turn into a mosaic
+// @Param:      cell-size 	px 	2..  16 The average size of the cells
+// @Returns:    result  	- 	-   -   The mosaic
+func mosaic(img *image.NRGBA, cellSize int) (*image.NRGBA, error) {
+	if cellSize < 2 {
+		return nil, fmt.Errorf("cell size must be at least 2")
+	}
+	b := img.Bounds()
+	gw, gh := (b.Dx()+cellSize-1)/cellSize, (b.Dy()+cellSize-1)/cellSize
+
+	// one jittered seed per grid cell, with a fixed seed so results are reproducible
+	rng := rand.New(rand.NewPCG(uint64(cellSize), 0x6d6f73616963))
+	seeds := make([]point, gw*gh)
+	for gy := 0; gy < gh; gy++ {
+		for gx := 0; gx < gw; gx++ {
+			seeds[gy*gw+gx] = point{
+				float64(gx*cellSize) + rng.Float64()*float64(cellSize),
+				float64(gy*cellSize) + rng.Float64()*float64(cellSize),
+			}
+		}
+	}
+
+	// assign every pixel to the nearest seed, which is in the same or a neighboring grid cell
+	labels := make([]int, b.Dx()*b.Dy())
+	sums := make([][5]float64, len(seeds)) // premultiplied r, g, b, alpha and pixel count
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			gx, gy := x/cellSize, y/cellSize
+			best, bestDist := 0, math.Inf(1)
+			for ny := max(gy-1, 0); ny <= min(gy+1, gh-1); ny++ {
+				for nx := max(gx-1, 0); nx <= min(gx+1, gw-1); nx++ {
+					s := seeds[ny*gw+nx]
+					if d := (s.x-float64(x)-0.5)*(s.x-float64(x)-0.5) + (s.y-float64(y)-0.5)*(s.y-float64(y)-0.5); d < bestDist {
+						best, bestDist = ny*gw+nx, d
+					}
+				}
+			}
+			labels[y*b.Dx()+x] = best
+			c := img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+			a := float64(c.A)
+			sums[best][0] += float64(c.R) * a
+			sums[best][1] += float64(c.G) * a
+			sums[best][2] += float64(c.B) * a
+			sums[best][3] += a
+			sums[best][4]++
+		}
+	}
+
+	colors := make([]color.NRGBA, len(seeds))
+	for i, s := range sums {
+		if s[3] > 0 {
+			colors[i] = color.NRGBA{
+				R: uint8(math.Round(s[0] / s[3])),
+				G: uint8(math.Round(s[1] / s[3])),
+				B: uint8(math.Round(s[2] / s[3])),
+				A: uint8(math.Round(s[3] / s[4])),
+			}
+		}
+	}
+	result := image.NewNRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			result.SetNRGBA(b.Min.X+x, b.Min.Y+y, colors[labels[y*b.Dx()+x]])
+		}
+	}
+	return result, nil
+}
+
+// @Name: oil-paint
+// @Desc: Gives an image the look of an oil painting
+// @Param:      img     - 	-   	-   The image to paint
+// @Param:      radius  px 	1..  	3   The radius of the brush
+// @Param:      levels  - 	2..256  20  The number of intensity levels
+// @Returns:    result  - 	-   	-   The painted image
+func oilPaint(img *image.NRGBA, radius int, levels int) (*image.NRGBA, error) {
+	if radius < 1 {
+		return nil, fmt.Errorf("radius must be at least 1")
+	}
+	if levels < 2 || levels > 256 {
+		return nil, fmt.Errorf("levels must be between 2 and 256")
+	}
+	b := img.Bounds()
+	result := image.NewNRGBA(b)
+	count := make([]int, levels)
+	sums := make([][4]int, levels)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			clear(count)
+			clear(sums)
+			for ny := max(y-radius, b.Min.Y); ny <= min(y+radius, b.Max.Y-1); ny++ {
+				for nx := max(x-radius, b.Min.X); nx <= min(x+radius, b.Max.X-1); nx++ {
+					c := img.NRGBAAt(nx, ny)
+					l := int(luminance(c)) * (levels - 1) / 255
+					count[l]++
+					sums[l][0] += int(c.R)
+					sums[l][1] += int(c.G)
+					sums[l][2] += int(c.B)
+					sums[l][3] += int(c.A)
+				}
+			}
+			best := 0
+			for l := range count {
+				if count[l] > count[best] {
+					best = l
+				}
+			}
+			n := count[best]
+			result.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(sums[best][0] / n),
+				G: uint8(sums[best][1] / n),
+				B: uint8(sums[best][2] / n),
+				A: uint8(sums[best][3] / n),
+			})
+		}
+	}
+	return result, nil
+}
+
+// @Name: kuwahara
+// @Desc: Smooths an image while keeping edges by averaging the most uniform of the four quadrants around each pixel
+// @Param:      img     - 	-   	-   The image to filter
+// @Param:      radius  px 	1..  	4   The size of the quadrants
+// @Returns:    result  - 	-   	-   The filtered image
+func kuwahara(img *image.NRGBA, radius int) (*image.NRGBA, error) {
+	if radius < 1 {
+		return nil, fmt.Errorf("radius must be at least 1")
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// summed-area tables of the channels, the luminance and the squared luminance
+	stride := w + 1
+	sat := make([][6]float64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+			l := luminance(c)
+			v := [6]float64{float64(c.R), float64(c.G), float64(c.B), float64(c.A), l, l * l}
+			i := (y+1)*stride + x + 1
+			for k := range v {
+				sat[i][k] = v[k] + sat[i-1][k] + sat[i-stride][k] - sat[i-stride-1][k]
+			}
+		}
+	}
+	area := func(x0, y0, x1, y1 int) (sum [6]float64, n float64) {
+		x0, y0 = max(x0, 0), max(y0, 0)
+		x1, y1 = min(x1, w), min(y1, h)
+		for k := range sum {
+			sum[k] = sat[y1*stride+x1][k] - sat[y0*stride+x1][k] - sat[y1*stride+x0][k] + sat[y0*stride+x0][k]
+		}
+		return sum, float64((x1 - x0) * (y1 - y0))
+	}
+
+	result := image.NewNRGBA(b)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			quadrants := [4][4]int{
+				{x - radius, y - radius, x + 1, y + 1},
+				{x, y - radius, x + radius + 1, y + 1},
+				{x - radius, y, x + 1, y + radius + 1},
+				{x, y, x + radius + 1, y + radius + 1},
+			}
+			var best [6]float64
+			var bestN float64
+			bestVar := math.Inf(1)
+			for _, q := range quadrants {
+				sum, n := area(q[0], q[1], q[2], q[3])
+				mean := sum[4] / n
+				if v := sum[5]/n - mean*mean; v < bestVar {
+					best, bestN, bestVar = sum, n, v
+				}
+			}
+			result.SetNRGBA(b.Min.X+x, b.Min.Y+y, color.NRGBA{
+				R: uint8(math.Round(best[0] / bestN)),
+				G: uint8(math.Round(best[1] / bestN)),
+				B: uint8(math.Round(best[2] / bestN)),
+				A: uint8(math.Round(best[3] / bestN)),
+			})
+		}
+	}
+	return result, nil
+}
+
+// @Name: sketch
+// @Desc: Turns an image into a pencil sketch based on its edges
+// @Param:      img     	- 	-   	-   The image to sketch
+// @Param:      intensity 	- 	0..  	1   How dark the strokes are
+// @Returns:    result  	- 	-   	-   The sketch
+func sketch(img *image.NRGBA, intensity float64) (*image.NRGBA, error) {
+	if intensity < 0 {
+		return nil, fmt.Errorf("intensity must not be negative")
+	}
+	b := img.Bounds()
+	lum := luminancePlane(img)
+	edges := sobelMagnitude(lum, b.Dx(), b.Dy())
+
+	result := image.NewNRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			v := uint8(255 - math.Min(edges[y*b.Dx()+x]*intensity, 255))
+			result.SetNRGBA(b.Min.X+x, b.Min.Y+y, color.NRGBA{R: v, G: v, B: v, A: img.NRGBAAt(b.Min.X+x, b.Min.Y+y).A})
+		}
+	}
+	return result, nil
+}
+
+// @Name: cartoon
+// @Desc: Gives an image a cartoon look by smoothing it, reducing its colors and outlining its edges
+// @Param:      img     	- 	-   	-   The image to cartoonize
+// @Param:      levels  	- 	2..256  6   The number of levels per color channel
+// @Param:      threshold 	- 	0..  	100 Edge strength above which pixels are outlined
+// @Returns:    result  	- 	-   	-   The cartoonized image
+func cartoon(img *image.NRGBA, levels int, threshold float64) (*image.NRGBA, error) {
+	if levels < 2 || levels > 256 {
+		return nil, fmt.Errorf("levels must be between 2 and 256")
+	}
+	smooth := bilateralPlanes(newChannelPlanes(img), 3, 30.0/255).toNRGBA()
+	b := img.Bounds()
+	edges := sobelMagnitude(luminancePlane(smooth), b.Dx(), b.Dy())
+
+	step := 255.0 / float64(levels-1)
+	quantize := func(v uint8) uint8 {
+		return uint8(math.Round(math.Round(float64(v)/step) * step))
+	}
+	result := image.NewNRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := smooth.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+			if edges[y*b.Dx()+x] > threshold {
+				result.SetNRGBA(b.Min.X+x, b.Min.Y+y, color.NRGBA{A: c.A})
+				continue
+			}
+			result.SetNRGBA(b.Min.X+x, b.Min.Y+y, color.NRGBA{R: quantize(c.R), G: quantize(c.G), B: quantize(c.B), A: c.A})
+		}
+	}
+	return result, nil
+}
+
+// @Name: ascii-art
+// @Desc: Converts an image to ASCII art, bright pixels become dense characters
+// @Param:      img     - 	-   	-   The image to convert
+// @Param:      cols    - 	1..  	80  The number of characters per line
+// @Returns:    result  - 	-   	-   The ASCII art
+func asciiArt(img *image.NRGBA, cols int) (string, error) {
+	const ramp = " .:-=+*#%@"
+	if cols < 1 {
+		return "", fmt.Errorf("cols must be at least 1")
+	}
+	b := img.Bounds()
+	cw := float64(b.Dx()) / float64(cols)
+	ch := cw * 2 // characters are about twice as high as wide
+	rows := max(1, int(math.Round(float64(b.Dy())/ch)))
+
+	var sb strings.Builder
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			cell := image.Rect(
+				b.Min.X+int(float64(c)*cw), b.Min.Y+int(float64(r)*ch),
+				b.Min.X+int(float64(c+1)*cw), b.Min.Y+int(float64(r+1)*ch),
+			).Intersect(b)
+			if cell.Empty() {
+				sb.WriteByte(' ')
+				continue
+			}
+			avg := averageColor(img, cell)
+			v := luminance(avg) / 255.0 * float64(avg.A) / 255.0
+			sb.WriteByte(ramp[min(int(v*float64(len(ramp))), len(ramp)-1)])
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
+
+// Helper function to get the alpha-weighted average color of a region
+func averageColor(img *image.NRGBA, r image.Rectangle) color.NRGBA {
+	var sr, sg, sb, sa float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			a := float64(c.A)
+			sr += float64(c.R) * a
+			sg += float64(c.G) * a
+			sb += float64(c.B) * a
+			sa += a
+		}
+	}
+	if sa == 0 {
+		return color.NRGBA{}
+	}
+	return color.NRGBA{
+		R: uint8(math.Round(sr / sa)),
+		G: uint8(math.Round(sg / sa)),
+		B: uint8(math.Round(sb / sa)),
+		A: uint8(math.Round(sa / float64(r.Dx()*r.Dy()))),
+	}
+}
+
+// Helper function to compute the Sobel gradient magnitude of a plane, edges are clamped
+func sobelMagnitude(plane []float64, w, h int) []float64 {
+	at := func(x, y int) float64 {
+		return plane[min(max(y, 0), h-1)*w+min(max(x, 0), w-1)]
+	}
+	res := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			res[y*w+x] = math.Hypot(gx, gy)
+		}
+	}
+	return res
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+
+	"github.com/toxyl/math"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+var (
+	// @Name:  text-outline
+	// @Desc:  Width of the outline drawn around text by draw-text, 0 draws no outline
+	// @Range: 0..
+	// @Unit:  px
+	textOutline = 0.0
+
+	// @Name:  text-outline-color
+	// @Desc:  Color of the outline drawn around text by draw-text
+	// @Range: -
+	// @Unit:  -
+	textOutlineColor = color.RGBA64{A: 0xffff}
+
+	// @Name:  text-shadow
+	// @Desc:  Offset of the drop shadow drawn below text by draw-text, 0 draws no shadow
+	// @Range: 0..
+	// @Unit:  px
+	textShadow = 0.0
+
+	// @Name:  text-shadow-color
+	// @Desc:  Color of the drop shadow drawn below text by draw-text
+	// @Range: -
+	// @Unit:  -
+	textShadowColor = color.RGBA64{A: 0x8000}
+)
+
+var (
+	textFont     *opentype.Font
+	textFontErr  error
+	textFontOnce sync.Once
+)
+
+// textMetrics is the size of a text block as returned by measure-text
+type textMetrics struct {
+	Width  int
+	Height int
+	Lines  int
+}
+
+func (m textMetrics) String() string {
+	return fmt.Sprintf("%dx%d (%d lines)", m.Width, m.Height, m.Lines)
+}
+
+// @Name: draw-text
+// @Desc: Draws text onto a copy of the image using the embedded Go font, lines are separated by \n
+// @Param:      img     - -   	-   	The image to draw on
+// @Param:      text    - -   	-   	The text to draw
+// @Param:      x       px -   	0   	X coordinate of the anchor, which is the left, center or right of the text depending on align
+// @Param:      y       px -   	0   	Y coordinate of the top of the text
+// @Param:      size    px 1..  	12   	The font size
+// @Param:      col     - -   	-   	The text color
+// @Param:      align   - -   	"left"  The alignment of the lines, one of left, center or right
+// @Returns:    result  - -   	-   	The image with the text drawn
+func drawText(img *image.NRGBA, text string, x, y, size float64, col color.RGBA64, align string) (*image.NRGBA, error) {
+	face, err := textFace(size)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+	if align != "left" && align != "center" && align != "right" {
+		return nil, fmt.Errorf("unknown alignment %q, must be left, center or right", align)
+	}
+
+	b := img.Bounds()
+	mask := image.NewAlpha(image.Rect(0, 0, b.Dx(), b.Dy()))
+	d := &font.Drawer{Dst: mask, Src: image.Opaque, Face: face}
+	m := face.Metrics()
+	baseline := y - float64(b.Min.Y) + fixedToFloat(m.Ascent)
+
+	for _, line := range textLines(text) {
+		w := fixedToFloat(d.MeasureString(line))
+		left := x - float64(b.Min.X)
+		switch align {
+		case "center":
+			left -= w / 2
+		case "right":
+			left -= w
+		}
+		d.Dot = fixed.Point26_6{X: floatToFixed(left), Y: floatToFixed(baseline)}
+		d.DrawString(line)
+		baseline += fixedToFloat(m.Height)
+	}
+
+	result := cloneNRGBA(img)
+	outlined := mask
+	if textOutline > 0 {
+		outlined = dilateMask(mask, textOutline)
+	}
+	if textShadow > 0 {
+		off := int(math.Round(textShadow))
+		blendMask(result, shiftMask(outlined, off, off), rgba64ToNRGBA(textShadowColor))
+	}
+	if textOutline > 0 {
+		blendMask(result, outlined, rgba64ToNRGBA(textOutlineColor))
+	}
+	blendMask(result, mask, rgba64ToNRGBA(col))
+	return result, nil
+}
+
+// @Name: measure-text
+// @Desc: Measures the bounding box of text as drawn by draw-text, ignoring outline and shadow
+// @Param:      text    - -   	-   	The text to measure
+// @Param:      size    px 1..  	12   	The font size
+// @Returns:    result  - -   	-   	Width, height and number of lines of the text
+func measureText(text string, size float64) (textMetrics, error) {
+	face, err := textFace(size)
+	if err != nil {
+		return textMetrics{}, err
+	}
+	defer face.Close()
+	lines := textLines(text)
+	res := textMetrics{Lines: len(lines)}
+	for _, line := range lines {
+		res.Width = max(res.Width, font.MeasureString(face, line).Ceil())
+	}
+	m := face.Metrics()
+	res.Height = (m.Height*fixed.Int26_6(len(lines)-1) + m.Ascent + m.Descent).Ceil()
+	return res, nil
+}
+
+// Helper function to create a face of the embedded font for a size. Faces cache glyphs and are not
+// safe for concurrent use, so every call gets its own one, only the parsed font is shared.
+func textFace(size float64) (font.Face, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("font size must be at least 1")
+	}
+	textFontOnce.Do(func() {
+		textFont, textFontErr = opentype.Parse(goregular.TTF)
+	})
+	if textFontErr != nil {
+		return nil, textFontErr
+	}
+	return opentype.NewFace(textFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+}
+
+// Helper function to split text into lines, accepting both real and escaped line breaks
+func textLines(text string) []string {
+	return strings.Split(strings.ReplaceAll(text, `\n`, "\n"), "\n")
+}
+
+// Helper function to grow a mask by the given radius using a circular maximum filter
+func dilateMask(mask *image.Alpha, radius float64) *image.Alpha {
+	b := mask.Bounds()
+	r := int(math.Ceil(radius))
+	var offsets []image.Point
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if float64(dx*dx+dy*dy) <= radius*radius {
+				offsets = append(offsets, image.Point{dx, dy})
+			}
+		}
+	}
+
+	result := image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			a := mask.AlphaAt(x, y).A
+			if a == 0 {
+				continue
+			}
+			for _, o := range offsets {
+				p := image.Point{x + o.X, y + o.Y}
+				if p.In(b) && result.AlphaAt(p.X, p.Y).A < a {
+					result.SetAlpha(p.X, p.Y, color.Alpha{A: a})
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Helper function to shift a mask by the given offset, keeping its bounds
+func shiftMask(mask *image.Alpha, dx, dy int) *image.Alpha {
+	b := mask.Bounds()
+	result := image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if p := (image.Point{x + dx, y + dy}); p.In(b) {
+				result.SetAlpha(p.X, p.Y, mask.AlphaAt(x, y))
+			}
+		}
+	}
+	return result
+}
+
+// Helper function to convert a fixed point number to float
+func fixedToFloat(v fixed.Int26_6) float64 {
+	return float64(v) / 64
+}
+
+// Helper function to convert a float to a fixed point number
+func floatToFixed(v float64) fixed.Int26_6 {
+	return fixed.Int26_6(math.Round(v * 64))
+}
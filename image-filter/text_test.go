@@ -0,0 +1,39 @@
+package main
+
+import (
+	"image/color"
+	"sync"
+	"testing"
+)
+
+// TestDrawTextConcurrent draws text from several goroutines like batch workers do, run it with -race
+func TestDrawTextConcurrent(t *testing.T) {
+	in := newTestInputs(48, 32)
+	want, err := drawText(in.gradient, "Go 123", 2, 4, 14, color.RGBA64{A: 0xffff}, "left")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				got, err := drawText(in.gradient, "Go 123", 2, 4, 14, color.RGBA64{A: 0xffff}, "left")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if string(got.Pix) != string(want.Pix) {
+					t.Error("concurrently drawn text differs from text drawn alone")
+					return
+				}
+				if _, err := measureText("Go 123", 14); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+
+	"github.com/toxyl/math"
 )
 
 // Helper function to create a new RGBA64 image with the same bounds as the source
@@ -10,6 +14,60 @@ func createNewRGBA64FromBounds(img *image.RGBA64) *image.RGBA64 {
 	return image.NewRGBA64(img.Bounds())
 }
 
+// Helper function to convert any image to NRGBA, NRGBA images are returned as-is
+// and lazy images are rendered
+func toNRGBA(img image.Image) *image.NRGBA {
+	switch v := img.(type) {
+	case *image.NRGBA:
+		return v
+	case *lazyImage:
+		return v.render()
+	}
+	nrgba := image.NewNRGBA(img.Bounds())
+	draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return nrgba
+}
+
+// Helper function to check the size of an image that is about to be created against maxImagePixels,
+// the size is given as floats, so products of huge factors can't overflow
+func checkImageSize(w, h float64) error {
+	if !(w*h <= maxImagePixels) { // also catches NaN
+		return fmt.Errorf("an image of %.0fx%.0f pixels is too large, at most %d pixels are supported", w, h, maxImagePixels)
+	}
+	return nil
+}
+
+// Helper function to create a copy of an image
+func cloneNRGBA(img *image.NRGBA) *image.NRGBA {
+	b := img.Bounds()
+	clone := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		copy(clone.Pix[clone.PixOffset(b.Min.X, y):clone.PixOffset(b.Max.X, y)], img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)])
+	}
+	return clone
+}
+
+// Helper function to get the luminance of every pixel on a 0..255 scale, row by row
+func luminancePlane(img *image.NRGBA) []float64 {
+	b := img.Bounds()
+	lum := make([]float64, 0, b.Dx()*b.Dy())
+	forEachNRGBA(img, func(c color.NRGBA) {
+		lum = append(lum, luminance(c))
+	})
+	return lum
+}
+
+// Helper function to call fn for every pixel of an image
+func forEachNRGBA(img *image.NRGBA, fn func(c color.NRGBA)) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			fn(color.NRGBA{R: row[i], G: row[i+1], B: row[i+2], A: row[i+3]})
+		}
+	}
+}
+
 // Helper function to get RGBA64 components as uint32 for calculations
 func getRGBA64Components(c color.RGBA64) (r, g, b, a uint32) {
 	return uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
@@ -71,3 +129,19 @@ func hslToRGB(h, s, l float64) (r, g, b float64) {
 
 	return
 }
+
+// Helper function to convert a normalized sRGB channel value to linear light
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// Helper function to convert a normalized linear light value to an sRGB channel value
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/toxyl/math"
+)
+
+var (
+	// @Name:  interpolation
+	// @Desc:  Interpolation used when warping images, one of nearest, bilinear or bicubic
+	// @Range: -
+	// @Unit:  -
+	interpolation = "bilinear"
+)
+
+// @Name: swirl
+// @Desc: Swirls the image around its center
+// @Param:      img     - 	-   		-   	The image to swirl
+// @Param:      angle   "°" -   		90   	The rotation at the center, decreasing towards the radius
+// @Param:      radius  px 	0..  		100   	The radius of the swirl
+// @Returns:    result  - 	-   		-   	The swirled image
+func swirl(img *image.NRGBA, angle float64, radius float64) (*image.NRGBA, error) {
+	if radius <= 0 {
+		return nil, fmt.Errorf("radius must be greater than 0")
+	}
+	cx, cy := imageCenter(img)
+	rad := angle * math.Pi / 180
+	return warp(img, img.Bounds(), func(x, y float64) (float64, float64) {
+		dx, dy := x-cx, y-cy
+		r := math.Hypot(dx, dy)
+		if r >= radius {
+			return x, y
+		}
+		t := 1 - r/radius
+		a := rad * t * t
+		s, c := math.Sin(a), math.Cos(a)
+		return cx + dx*c - dy*s, cy + dx*s + dy*c
+	})
+}
+
+// @Name: pinch
+// @Desc: Pinches the image towards its center
+// @Param:      img     - 	-   		-   	The image to pinch
+// @Param:      amount  "%" 0.0..1.0   	0.5   	The strength of the pinch
+// @Param:      radius  px 	0..  		100   	The radius of the affected area
+// @Returns:    result  - 	-   		-   	The pinched image
+func pinch(img *image.NRGBA, amount float64, radius float64) (*image.NRGBA, error) {
+	if amount < 0 || amount > 1 {
+		return nil, fmt.Errorf("amount must be between 0.0 and 1.0")
+	}
+	return radialWarp(img, 1/(1+amount), radius)
+}
+
+// @Name: bulge
+// @Desc: Bulges the image out of its center
+// @Param:      img     - 	-   		-   	The image to bulge
+// @Param:      amount  "%" 0.0..1.0   	0.5   	The strength of the bulge
+// @Param:      radius  px 	0..  		100   	The radius of the affected area
+// @Returns:    result  - 	-   		-   	The bulged image
+func bulge(img *image.NRGBA, amount float64, radius float64) (*image.NRGBA, error) {
+	if amount < 0 || amount > 1 {
+		return nil, fmt.Errorf("amount must be between 0.0 and 1.0")
+	}
+	return radialWarp(img, 1+amount, radius)
+}
+
+// @Name: wave
+// @Desc: Displaces the image along sine waves
+// @Param:      img     	- 	-   	-   	The image to distort
+// @Param:      amplitude 	px 	0..  	5   	The amplitude of the waves
+// @Param:      wavelength 	px 	1..  	30   	The wavelength of the waves
+// @Returns:    result  	- 	-   	-   	The distorted image
+func wave(img *image.NRGBA, amplitude float64, wavelength float64) (*image.NRGBA, error) {
+	if wavelength <= 0 {
+		return nil, fmt.Errorf("wavelength must be greater than 0")
+	}
+	k := 2 * math.Pi / wavelength
+	return warp(img, img.Bounds(), func(x, y float64) (float64, float64) {
+		return x + amplitude*math.Sin(y*k), y + amplitude*math.Sin(x*k)
+	})
+}
+
+// @Name: polar-to-cartesian
+// @Desc: Treats the image as polar coordinates (x = angle, y = radius) and converts it to cartesian coordinates
+// @Param:      img     - -   -   The image in polar coordinates
+// @Returns:    result  - -   -   The image in cartesian coordinates
+func polarToCartesian(img *image.NRGBA) (*image.NRGBA, error) {
+	b := img.Bounds()
+	cx, cy := imageCenter(img)
+	maxR := math.Hypot(float64(b.Dx())/2, float64(b.Dy())/2)
+	return warp(img, b, func(x, y float64) (float64, float64) {
+		a := math.Atan2(y-cy, x-cx)
+		if a < 0 {
+			a += 2 * math.Pi
+		}
+		r := math.Hypot(x-cx, y-cy)
+		return float64(b.Min.X) + a/(2*math.Pi)*float64(b.Dx()), float64(b.Min.Y) + r/maxR*float64(b.Dy())
+	})
+}
+
+// @Name: cartesian-to-polar
+// @Desc: Converts the image to polar coordinates (x = angle, y = radius)
+// @Param:      img     - -   -   The image in cartesian coordinates
+// @Returns:    result  - -   -   The image in polar coordinates
+func cartesianToPolar(img *image.NRGBA) (*image.NRGBA, error) {
+	b := img.Bounds()
+	cx, cy := imageCenter(img)
+	maxR := math.Hypot(float64(b.Dx())/2, float64(b.Dy())/2)
+	return warp(img, b, func(x, y float64) (float64, float64) {
+		a := (x - float64(b.Min.X)) / float64(b.Dx()) * 2 * math.Pi
+		r := (y - float64(b.Min.Y)) / float64(b.Dy()) * maxR
+		return cx + r*math.Cos(a), cy + r*math.Sin(a)
+	})
+}
+
+// @Name: displace
+// @Desc: Displaces the pixels of the image by the red (x) and green (y) channels of a displacement map, 50% means no displacement
+// @Param:      img     - 	-   -   The image to displace
+// @Param:      dmap    - 	-   -   The displacement map, stretched to the size of the image
+// @Param:      scale   px 	-   10  The displacement at full channel intensity
+// @Returns:    result  - 	-   -   The displaced image
+func displace(img *image.NRGBA, dmap *image.NRGBA, scale float64) (*image.NRGBA, error) {
+	b := img.Bounds()
+	mb := dmap.Bounds()
+	if mb.Empty() {
+		return nil, fmt.Errorf("displacement map is empty")
+	}
+	return warp(img, b, func(x, y float64) (float64, float64) {
+		mx := mb.Min.X + min(int((x-float64(b.Min.X))/float64(b.Dx())*float64(mb.Dx())), mb.Dx()-1)
+		my := mb.Min.Y + min(int((y-float64(b.Min.Y))/float64(b.Dy())*float64(mb.Dy())), mb.Dy()-1)
+		c := dmap.NRGBAAt(mx, my)
+		return x + (float64(c.R)/255.0-0.5)*scale, y + (float64(c.G)/255.0-0.5)*scale
+	})
+}
+
+// @Name: perspective
+// @Desc: Corrects the perspective of a quadrilateral in the image, e.g. a photographed document
+// @Param:      img     - -   -   The image to correct
+// @Param:      corners - -   -   The corners of the quadrilateral in the order top-left, top-right, bottom-right, bottom-left, e.g. "12,8 410,30 400,560 5,540"
+// @Returns:    result  - -   -   The quadrilateral straightened into a rectangle
+func perspective(img *image.NRGBA, corners string) (*image.NRGBA, error) {
+	nums, err := parseNumbers(corners)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) != 8 {
+		return nil, fmt.Errorf("perspective needs exactly four x,y pairs")
+	}
+	q := [4]point{{nums[0], nums[1]}, {nums[2], nums[3]}, {nums[4], nums[5]}, {nums[6], nums[7]}}
+	dist := func(a, b point) float64 { return math.Hypot(b.x-a.x, b.y-a.y) }
+	wf := math.Round(math.Max(dist(q[0], q[1]), dist(q[3], q[2])))
+	hf := math.Round(math.Max(dist(q[0], q[3]), dist(q[1], q[2])))
+	if err := checkImageSize(wf, hf); err != nil {
+		return nil, err
+	}
+	w, h := int(wf), int(hf)
+	if w < 1 || h < 1 {
+		return nil, fmt.Errorf("corners must span an area")
+	}
+
+	rect := [4]point{{0, 0}, {float64(w), 0}, {float64(w), float64(h)}, {0, float64(h)}}
+	hm, err := homographyFromPoints(rect, q)
+	if err != nil {
+		return nil, err
+	}
+	return warp(img, image.Rect(0, 0, w, h), hm.apply)
+}
+
+// homography is a 3x3 projective transformation with h[8] = 1
+type homography [9]float64
+
+// Helper function to apply the homography to a point
+func (hm homography) apply(x, y float64) (float64, float64) {
+	w := hm[6]*x + hm[7]*y + hm[8]
+	return (hm[0]*x + hm[1]*y + hm[2]) / w, (hm[3]*x + hm[4]*y + hm[5]) / w
+}
+
+// Helper function to chain two homographies, the result applies other first and then hm
+func (hm homography) mul(other homography) homography {
+	var res homography
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			for k := 0; k < 3; k++ {
+				res[r*3+c] += hm[r*3+k] * other[k*3+c]
+			}
+		}
+	}
+	if res[8] != 0 {
+		scale := res[8]
+		for k := range res {
+			res[k] /= scale
+		}
+	}
+	return res
+}
+
+// Helper function to compute the inverse homography, which maps the transformed points back
+func (hm homography) inverse() (homography, error) {
+	a, b, c, d, e, f, g, h, i := hm[0], hm[1], hm[2], hm[3], hm[4], hm[5], hm[6], hm[7], hm[8]
+	det := a*(e*i-f*h) - b*(d*i-f*g) + c*(d*h-e*g)
+	if math.Abs(det) < 1e-12 {
+		return homography{}, fmt.Errorf("homography is not invertible")
+	}
+	inv := homography{
+		e*i - f*h, c*h - b*i, b*f - c*e,
+		f*g - d*i, a*i - c*g, c*d - a*f,
+		d*h - e*g, b*g - a*h, a*e - b*d,
+	}
+	if math.Abs(inv[8]) < 1e-12 {
+		return homography{}, fmt.Errorf("inverse homography maps the origin to infinity")
+	}
+	scale := inv[8]
+	for k := range inv {
+		inv[k] /= scale
+	}
+	return inv, nil
+}
+
+// Helper function to compute the homography that maps the four src points onto the four dst points
+func homographyFromPoints(src, dst [4]point) (homography, error) {
+	var a [8][9]float64
+	for i := 0; i < 4; i++ {
+		x, y, u, v := src[i].x, src[i].y, dst[i].x, dst[i].y
+		a[i*2] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		a[i*2+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+
+	// Gaussian elimination with partial pivoting
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for r := col + 1; r < 8; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return homography{}, fmt.Errorf("points are degenerate")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < 8; r++ {
+			if r == col {
+				continue
+			}
+			f := a[r][col] / a[col][col]
+			for c := col; c < 9; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+		}
+	}
+
+	var hm homography
+	for i := 0; i < 8; i++ {
+		hm[i] = a[i][8] / a[i][i]
+	}
+	hm[8] = 1
+	return hm, nil
+}
+
+// Helper function to get the center of an image in image coordinates
+func imageCenter(img image.Image) (x, y float64) {
+	b := img.Bounds()
+	return float64(b.Min.X) + float64(b.Dx())/2, float64(b.Min.Y) + float64(b.Dy())/2
+}
+
+// Helper function for warps that move pixels along the radius, r' = radius * (r / radius)^exponent
+func radialWarp(img *image.NRGBA, exponent float64, radius float64) (*image.NRGBA, error) {
+	if radius <= 0 {
+		return nil, fmt.Errorf("radius must be greater than 0")
+	}
+	cx, cy := imageCenter(img)
+	return warp(img, img.Bounds(), func(x, y float64) (float64, float64) {
+		dx, dy := x-cx, y-cy
+		r := math.Hypot(dx, dy)
+		if r >= radius || r == 0 {
+			return x, y
+		}
+		f := math.Pow(r/radius, exponent) * radius / r
+		return cx + dx*f, cy + dy*f
+	})
+}
+
+// Helper function to create an image by inverse mapping: for every pixel center of the
+// destination, fn returns the position in the source image to sample
+func warp(img *image.NRGBA, dst image.Rectangle, fn func(x, y float64) (float64, float64)) (*image.NRGBA, error) {
+	sample, err := sampler(interpolation)
+	if err != nil {
+		return nil, err
+	}
+	result := image.NewNRGBA(dst)
+	for y := dst.Min.Y; y < dst.Max.Y; y++ {
+		for x := dst.Min.X; x < dst.Max.X; x++ {
+			sx, sy := fn(float64(x)+0.5, float64(y)+0.5)
+			result.SetNRGBA(x, y, sample(img, sx, sy))
+		}
+	}
+	return result, nil
+}
+
+// Helper function to get the sampler for an interpolation method. Samplers take
+// coordinates in image space, where pixel centers are at .5, and return
+// transparent black outside of the image.
+func sampler(method string) (func(img *image.NRGBA, x, y float64) color.NRGBA, error) {
+	switch method {
+	case "nearest":
+		return sampleNearest, nil
+	case "bilinear":
+		return sampleBilinear, nil
+	case "bicubic":
+		return sampleBicubic, nil
+	}
+	return nil, fmt.Errorf("unknown interpolation %q, must be nearest, bilinear or bicubic", method)
+}
+
+// Helper function to sample the nearest pixel
+func sampleNearest(img *image.NRGBA, x, y float64) color.NRGBA {
+	p := image.Point{int(math.Floor(x)), int(math.Floor(y))}
+	if !p.In(img.Bounds()) {
+		return color.NRGBA{}
+	}
+	return img.NRGBAAt(p.X, p.Y)
+}
+
+// Helper function to sample with bilinear interpolation
+func sampleBilinear(img *image.NRGBA, x, y float64) color.NRGBA {
+	return sampleKernel(img, x, y, 1, func(t float64) float64 {
+		return math.Max(0, 1-math.Abs(t))
+	})
+}
+
+// Helper function to sample with bicubic (Catmull-Rom) interpolation
+func sampleBicubic(img *image.NRGBA, x, y float64) color.NRGBA {
+	return sampleKernel(img, x, y, 2, func(t float64) float64 {
+		t = math.Abs(t)
+		switch {
+		case t < 1:
+			return 1.5*t*t*t - 2.5*t*t + 1
+		case t < 2:
+			return -0.5*t*t*t + 2.5*t*t - 4*t + 2
+		}
+		return 0
+	})
+}
+
+// Helper function to sample using a separable kernel with the given support radius.
+// Colors are weighted by alpha so that transparent pixels don't darken edges.
+func sampleKernel(img *image.NRGBA, x, y float64, support int, kernel func(t float64) float64) color.NRGBA {
+	b := img.Bounds()
+	if x < float64(b.Min.X) || y < float64(b.Min.Y) || x >= float64(b.Max.X) || y >= float64(b.Max.Y) {
+		return color.NRGBA{}
+	}
+	// shift so that pixel centers are at integer positions
+	x -= 0.5
+	y -= 0.5
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+
+	var r, g, bl, a, wsum float64
+	for j := y0 - support + 1; j <= y0+support; j++ {
+		wy := kernel(y - float64(j))
+		if wy == 0 {
+			continue
+		}
+		py := min(max(j, b.Min.Y), b.Max.Y-1)
+		for i := x0 - support + 1; i <= x0+support; i++ {
+			wx := kernel(x - float64(i))
+			if wx == 0 {
+				continue
+			}
+			px := min(max(i, b.Min.X), b.Max.X-1)
+			c := img.NRGBAAt(px, py)
+			w := wx * wy
+			ca := float64(c.A)
+			r += float64(c.R) * ca * w
+			g += float64(c.G) * ca * w
+			bl += float64(c.B) * ca * w
+			a += ca * w
+			wsum += w
+		}
+	}
+	if a <= 0 || wsum == 0 {
+		return color.NRGBA{}
+	}
+	clamp := func(v float64) uint8 { return uint8(math.Clamp(math.Round(v), 0, 255)) }
+	return color.NRGBA{R: clamp(r / a), G: clamp(g / a), B: clamp(bl / a), A: clamp(a / wsum)}
+}
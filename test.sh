@@ -73,6 +73,10 @@ printBlue "Executing Calculator example..."
 #########################################
 genDSL "calculator" 
 build "calculator"
+go test ./calculator/ || {
+    printRed "Calculator tests failed"
+    exit 1
+}
 run "calculator"
 
 #########################################
@@ -94,6 +98,10 @@ printBlue "Executing Image Filter example..."
 #########################################
 genDSL "image-filter" 
 build "image-filter"
+go test ./image-filter/ || {
+    printRed "Image Filter tests failed, run 'go test ./image-filter/ -run Golden -update' if the changes are intended"
+    exit 1
+}
 run "image-filter" 
 
 #########################################